@@ -0,0 +1,42 @@
+// Локальная замена KMS для разработки: отдаёт протокол crypto.KMSHandler
+// поверх связки ключей в файле. Ротация: kms -rotate.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"yep-protocol/internal/crypto"
+)
+
+func main() {
+	addr := flag.String("addr", ":8200", "listen address")
+	path := flag.String("keyring", "kms-keyring.json", "keyring file")
+	rotate := flag.Bool("rotate", false, "add a new current key and exit")
+	flag.Parse()
+
+	keyring, err := crypto.OpenKeyring(*path)
+	if err != nil {
+		log.Fatal("Failed to open keyring:", err)
+	}
+
+	if *rotate {
+		id, err := keyring.Rotate()
+		if err != nil {
+			log.Fatal("Failed to rotate keyring:", err)
+		}
+		fmt.Println("🔑 New current key:", id)
+		return
+	}
+
+	token := os.Getenv("KMS_TOKEN")
+	if token == "" {
+		log.Println("⚠️ KMS_TOKEN is not set, requests are not authenticated")
+	}
+
+	fmt.Printf("🔐 KMS stand-in on %s, current key %s\n", *addr, keyring.CurrentKeyID())
+	log.Fatal(http.ListenAndServe(*addr, crypto.KMSHandler(keyring, token)))
+}
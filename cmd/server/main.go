@@ -1,15 +1,21 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"time"
 
 	"yep-protocol/internal/auth"
 	"yep-protocol/internal/config"
+	"yep-protocol/internal/crypto"
+	"yep-protocol/internal/middleware"
+	"yep-protocol/internal/notify"
 	"yep-protocol/internal/storage"
+	"yep-protocol/internal/telegram"
+	"yep-protocol/internal/transport/api"
 	"yep-protocol/internal/transport/ws"
 )
 
@@ -20,6 +26,11 @@ func main() {
 	fmt.Println("🔹 DATABASE_URL:", cfg.DBConn)
 	fmt.Println("🔹 MONGO_URI:", cfg.MongoURI)
 
+	// X-Forwarded-For принимаем только от своих прокси, иначе адрес клиента подделывается
+	if err := middleware.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// PostgreSQL
 	db, err := storage.NewDB(cfg.DBConn)
 	if err != nil {
@@ -34,30 +45,187 @@ func main() {
 	}
 	defer mongodb.Close()
 
+	// Шифрование сообщений в MongoDB (конвертное: KEK у провайдера ключей, ключ данных на диалог)
+	keyProvider, err := crypto.NewKeyProvider(crypto.ProviderConfig{
+		Type:        cfg.MessageKeyProvider,
+		KeyID:       cfg.MessageKEKID,
+		Key:         cfg.MessageKEK,
+		KeyFile:     cfg.MessageKEKFile,
+		OldKeys:     cfg.MessageOldKEKs,
+		KeyringPath: cfg.MessageKeyring,
+		KMSURL:      cfg.MessageKMSURL,
+		KMSToken:    cfg.MessageKMSToken,
+	})
+	if err != nil {
+		log.Fatal("Failed to set up message key provider:", err)
+	}
+	if keyProvider != nil {
+		cipher, err := mongodb.NewMessageCipher(keyProvider)
+		if err != nil {
+			log.Fatal("Failed to set up message encryption:", err)
+		}
+		mongodb.SetCipher(cipher)
+		cipher.StartRotation(cfg.KeyRotationInterval)
+		fmt.Println("🔐 Message encryption enabled, KEK:", keyProvider.CurrentKeyID())
+	} else {
+		log.Println("⚠️ MESSAGE_KEY_PROVIDER/MESSAGE_KEK is not set, messages are stored unencrypted")
+	}
+
+	// Ключи JWT
+	keys, err := auth.LoadKeySet(auth.KeyConfig{
+		Secret:        cfg.JWTSecret,
+		Keys:          cfg.JWTKeys,
+		ActiveKID:     cfg.JWTActiveKID,
+		LegacyHSUntil: cfg.JWTLegacyHSUntil,
+	})
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+	auth.SetKeySet(keys)
+
 	// Сервисы
 	authService := auth.NewService(db, mongodb)
+	auth.SetSessionChecker(authService) // отозванные токены отклоняются в ValidateToken
+	authService.StartCleanup(10 * time.Minute)
 	telegramHandler := auth.NewTelegramVerifyHandler(db, authService)
 
 	// WS handler
 	wsHandler := ws.NewHandler(authService, db, mongodb)
+	wsHandler.SetSendQueue(cfg.WSSendQueue, ws.ParseOverflowPolicy(cfg.WSOverflowPolicy))
+	authService.SetSessionCloser(wsHandler)
+	authService.SetSignInNotifier(wsHandler)
 
 	// HTTP роуты
 	http.HandleFunc("/", serveHTML)
 	http.HandleFunc("/ws", wsHandler.HandleWebSocket)
-	http.HandleFunc("/api/telegram/save-code", telegramHandler.HandleSaveCode)
-	http.HandleFunc("/api/telegram/check", telegramHandler.HandleTelegramCheck)
 
-	// API для истории сообщений
-	http.HandleFunc("/api/messages", func(w http.ResponseWriter, r *http.Request) {
-		yui := r.URL.Query().Get("yui")
-		messages, err := mongodb.GetMessageHistory(yui, 50)
+	// API бота: только подписанные TELEGRAM_BOT_SECRET запросы
+	if cfg.TelegramBotSecret == "" {
+		log.Println("⚠️ TELEGRAM_BOT_SECRET is not set, Telegram bot API is disabled")
+	}
+	http.Handle("POST /api/telegram/code", middleware.RequireBotSignature(cfg.TelegramBotSecret, http.HandlerFunc(telegramHandler.HandleIssueCode)))
+	http.Handle("/api/telegram/check", middleware.RequireBotSignature(cfg.TelegramBotSecret, http.HandlerFunc(telegramHandler.HandleTelegramCheck)))
+
+	// Каналы служебных сообщений: Telegram, затем почта; без них — в лог
+	var notifiers notify.Chain
+
+	// Встроенный бот верификации
+	if cfg.TelegramBotToken != "" {
+		bot := telegram.NewBot(cfg.TelegramBotToken, cfg.TelegramAPIURL, db, authService)
+		if cfg.TelegramWebhookURL != "" {
+			if cfg.TelegramWebhookSecret == "" {
+				log.Fatal("TELEGRAM_WEBHOOK_SECRET is required with TELEGRAM_WEBHOOK_URL")
+			}
+			if err := bot.SetWebhook(cfg.TelegramWebhookURL, cfg.TelegramWebhookSecret); err != nil {
+				log.Fatal("Failed to set Telegram webhook:", err)
+			}
+			http.HandleFunc("POST /telegram/webhook", bot.HandleWebhook)
+			fmt.Println("🤖 Telegram bot: webhook", cfg.TelegramWebhookURL)
+		} else {
+			bot.StartPolling()
+			fmt.Println("🤖 Telegram bot: long polling")
+		}
+		notifiers = append(notifiers, bot)
+	}
+	// Подтверждение email — только почтой; без SMTP письма пишутся в лог
+	var mailer notify.Notifier = notify.Log{}
+	if cfg.SMTPAddr != "" {
+		smtpSender := notify.NewSMTP(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+		notifiers = append(notifiers, smtpSender)
+		mailer = smtpSender
+	} else if cfg.RequireVerifiedEmail {
+		log.Println("⚠️ REQUIRE_VERIFIED_EMAIL is set without SMTP_ADDR, verification emails go to the log")
+	}
+	if len(notifiers) == 0 {
+		log.Println("⚠️ Neither TELEGRAM_BOT_TOKEN nor SMTP_ADDR is set, notifications go to the log")
+		notifiers = append(notifiers, notify.Log{})
+	}
+	authService.SetNotifier(notifiers)
+	authService.SetPasswordResetURL(cfg.PasswordResetURL)
+	authService.SetMailer(mailer)
+	authService.SetEmailVerifyURL(cfg.EmailVerifyURL)
+	authService.SetRequireVerifiedEmail(cfg.RequireVerifiedEmail)
+	authService.SetTOTPRequiredLevels(cfg.TOTPRequiredLevels)
+
+	// Хэширование и требования к паролям. Значения из окружения только приводим к типам
+	// параметров без переполнения, допустимые диапазоны проверяет NewPasswordHasher.
+	hasher, err := auth.NewPasswordHasher(cfg.PasswordHasher, auth.Argon2Params{
+		Memory:      uint32(min(max(cfg.Argon2Memory, 0), math.MaxInt32)),
+		Iterations:  uint32(min(max(cfg.Argon2Iterations, 0), math.MaxInt32)),
+		Parallelism: uint8(min(max(cfg.Argon2Parallelism, 0), math.MaxUint8)),
+		SaltLength:  auth.DefaultArgon2Params.SaltLength,
+		KeyLength:   auth.DefaultArgon2Params.KeyLength,
+	})
+	if err != nil {
+		log.Fatal("Failed to configure password hashing:", err)
+	}
+	authService.SetPasswordHasher(hasher)
+
+	passwordPolicy := auth.NewPasswordPolicy(cfg.PasswordMinLength)
+	if cfg.BreachedPasswordsFile != "" {
+		n, err := passwordPolicy.LoadBreachedList(cfg.BreachedPasswordsFile)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			log.Fatal("Failed to load breached passwords list:", err)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(messages)
+		fmt.Printf("🔐 Loaded %d breached passwords\n", n)
+	}
+	authService.SetPasswordPolicy(passwordPolicy)
+
+	// Обмен refresh token — публичный, access token к этому моменту уже истёк
+	authHandler := api.NewAuthHandler(authService, cfg.TelegramBotToken)
+	http.HandleFunc("/api/auth/refresh", authHandler.HandleRefresh)
+
+	// Вход через Telegram Login Widget — тоже публичный
+	http.HandleFunc("/api/auth/telegram", authHandler.HandleTelegramLogin)
+
+	// Сброс пароля — без входа, с ограничением частоты
+	passwordHandler := api.NewPasswordHandler(authService, wsHandler)
+	http.HandleFunc("POST /api/auth/password/reset", passwordHandler.HandleResetRequest)
+	http.HandleFunc("POST /api/auth/password/reset/confirm", passwordHandler.HandleResetConfirm)
+
+	// Ссылка из письма с подтверждением email — публичная
+	emailHandler := api.NewEmailHandler(authService, db)
+	http.HandleFunc("/api/auth/email/verify", emailHandler.HandleVerifyLink)
+
+	// Публичные ключи для проверки YEP-токенов другими сервисами
+	http.HandleFunc("/.well-known/jwks.json", authHandler.HandleJWKS)
+
+	// REST API: всё под /api/ проходит через JWT middleware.
+	// Исключения — /api/telegram/* (их вызывает бот) и /api/auth/* (вход, refresh, сброс пароля).
+	historyHandler := api.NewHistoryHandler(db, mongodb)
+
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("/api/messages", historyHandler.HandleMessages)
+	apiMux.HandleFunc("/api/messages/history", historyHandler.HandleHistory)
+
+	sessionsHandler := api.NewSessionsHandler(authService, wsHandler)
+	apiMux.HandleFunc("GET /api/sessions", sessionsHandler.HandleList)
+	apiMux.HandleFunc("DELETE /api/sessions", sessionsHandler.HandleRevokeAll)
+	apiMux.HandleFunc("DELETE /api/sessions/{id}", sessionsHandler.HandleRevoke)
+	apiMux.HandleFunc("GET /api/logins", sessionsHandler.HandleLoginHistory)
+
+	// Каталог ключей для E2E-сообщений
+	keysHandler := api.NewKeysHandler(db)
+	apiMux.HandleFunc("PUT /api/keys", keysHandler.HandleUpload)
+	apiMux.HandleFunc("GET /api/keys/{yui}", keysHandler.HandleBundles)
+	apiMux.HandleFunc("DELETE /api/keys/{device}", keysHandler.HandleDelete)
+
+	// Второй фактор (TOTP) и коды восстановления
+	twoFactorHandler := api.NewTwoFactorHandler(authService, db)
+	apiMux.HandleFunc("GET /api/2fa", twoFactorHandler.HandleStatus)
+	apiMux.HandleFunc("POST /api/2fa/totp/enroll", twoFactorHandler.HandleEnroll)
+	apiMux.HandleFunc("POST /api/2fa/totp/confirm", twoFactorHandler.HandleConfirm)
+	apiMux.HandleFunc("DELETE /api/2fa/totp", twoFactorHandler.HandleDisable)
+	apiMux.HandleFunc("POST /api/2fa/recovery-codes", twoFactorHandler.HandleRecoveryCodes)
+
+	// Подтверждение email кодом из письма
+	apiMux.HandleFunc("GET /api/email", emailHandler.HandleStatus)
+	apiMux.HandleFunc("POST /api/email/verify", emailHandler.HandleVerifyCode)
+	apiMux.HandleFunc("POST /api/email/verify/resend", emailHandler.HandleResend)
+	apiMux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		middleware.WriteError(w, http.StatusNotFound, "not_found", "unknown API route")
 	})
+	http.Handle("/api/", middleware.RequireAuth(apiMux))
 
 	// Определяем порт
 	port := cfg.Port
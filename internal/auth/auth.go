@@ -3,19 +3,31 @@ package auth
 import (
 	"crypto/sha256"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
 	"time"
 	"yep-protocol/internal/core"
+	"yep-protocol/internal/notify"
 	"yep-protocol/internal/storage"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Service struct {
-	db                   *storage.DB
+	db                   store
 	mongodb              *storage.MongoDB
-	otpCodes             map[string]string // phoneHash -> code
+	otpPolicy            OTPPolicy
+	loginPolicy          LoginPolicy
+	hasher               PasswordHasher
+	closer               SessionCloser // nil — живые соединения не закрываем
+	sessions             *sessionCache // недавние проверки отзыва access token
+	signIns              SignInNotifier
+	passwordPolicy       *PasswordPolicy
+	notifier             notify.Notifier // доставка служебных сообщений; nil — не настроена
+	resetURL             string
+	totpLevels           map[string]bool // уровни, для которых второй фактор обязателен
+	mailer               notify.Notifier // письма с подтверждением email; nil — не настроены
+	emailVerifyURL       string
+	requireVerifiedEmail bool // без подтверждённого email в чат не пускаем
 	pendingVerifications map[string]*PendingUser
 	mu                   sync.Mutex
 }
@@ -30,7 +42,11 @@ func NewService(db *storage.DB, mongodb *storage.MongoDB) *Service {
 	return &Service{
 		db:                   db,
 		mongodb:              mongodb,
-		otpCodes:             make(map[string]string),
+		otpPolicy:            DefaultOTPPolicy,
+		loginPolicy:          DefaultLoginPolicy,
+		hasher:               NewArgon2idHasher(DefaultArgon2Params),
+		passwordPolicy:       NewPasswordPolicy(MinPasswordLength),
+		sessions:             newSessionCache(),
 		pendingVerifications: make(map[string]*PendingUser),
 	}
 }
@@ -46,12 +62,18 @@ func HashPhone(phone string) string {
 }
 
 func (s *Service) Register(email, phone, password, level string) (*core.User, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := s.checkNewPassword(password); err != nil {
+		return nil, err
+	}
+	hash, err := s.hasher.Hash(password)
 	if err != nil {
 		return nil, err
 	}
 	phoneHash := HashPhone(phone)
 
+	// Адрес мог занять кто-то другой и бросить регистрацию
+	s.releaseEmail(email)
+
 	// Также выведи очищенный номер
 	cleaned := strings.ReplaceAll(phone, "+", "")
 	cleaned = strings.ReplaceAll(cleaned, " ", "")
@@ -62,7 +84,7 @@ func (s *Service) Register(email, phone, password, level string) (*core.User, er
 		Email:        email,
 		Phone:        "",        // ты всё равно не сохраняешь реальный номер
 		PhoneHash:    phoneHash, // сохраняем хэш
-		PasswordHash: string(hash),
+		PasswordHash: hash,
 		Level:        level,
 		IsActive:     false,
 	}
@@ -77,21 +99,15 @@ func (s *Service) Register(email, phone, password, level string) (*core.User, er
 		CreatedAt: time.Now(),
 	}
 
-	return user, nil
-}
-
-func (s *Service) Login(email, password string) (*core.User, error) {
-	user, err := s.db.GetUserByEmail(email)
-	if err != nil {
-		return nil, fmt.Errorf("invalid credentials")
-	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-	if err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+	// Письмо с подтверждением адреса — в фоне, регистрация его не ждёт
+	if s.mailer != nil {
+		go func() {
+			if err := s.SendEmailVerification(user); err != nil {
+				log.Printf("Email verification for %s failed: %v", user.YUI, err)
+			}
+		}()
 	}
 
-	s.db.UpdateLastLogin(user.YUI)
 	return user, nil
 }
 
@@ -116,24 +132,14 @@ func (s *Service) CreatePendingUser(email, phone string) (string, error) {
 	return yui, nil
 }
 
-// Сохраняем OTP по phone_hash
-func (s *Service) StoreOTP(phoneHash, code string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.otpCodes[phoneHash] = code
-}
-
 // Верификация OTP
 func (s *Service) VerifyOTP(phoneHash, code string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	stored, ok := s.otpCodes[phoneHash]
-	if !ok || stored != code {
-		return fmt.Errorf("invalid code")
+	if err := s.checkOTP(phoneHash, code); err != nil {
+		return err
 	}
 
-	delete(s.otpCodes, phoneHash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Активируем пользователя
 	for _, pending := range s.pendingVerifications {
@@ -150,21 +156,10 @@ func (s *Service) VerifyOTP(phoneHash, code string) error {
 
 // Проверяем OTP по phone_hash
 func (s *Service) VerifyCodeByPhoneHash(phoneHash, code string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	stored, ok := s.otpCodes[phoneHash]
-	if !ok {
-		return fmt.Errorf("no code found")
+	if err := s.checkOTP(phoneHash, code); err != nil {
+		return err
 	}
 
-	if stored != code {
-		return fmt.Errorf("invalid code")
-	}
-
-	// Удаляем использованный код
-	delete(s.otpCodes, phoneHash)
-
 	// Активируем пользователя
 	return s.db.ActivateUserByPhoneHash(phoneHash)
 }
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/notify"
+	"yep-protocol/internal/storage"
+)
+
+const (
+	EmailVerificationTTL = 24 * time.Hour
+
+	// Столько незавершённая регистрация держит адрес, потом его может занять другой
+	EmailClaimTTL = 24 * time.Hour
+
+	// Не больше стольких писем с подтверждением на аккаунт в час
+	maxEmailVerificationsPerHour = 3
+)
+
+var (
+	ErrEmailTokenInvalid    = errors.New("invalid or expired verification code")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	ErrEmailUndeliverable   = errors.New("account has no email address to verify")
+	ErrEmailNotConfigured   = errors.New("email delivery is not configured")
+	ErrEmailRateLimited     = errors.New("too many verification emails, try again later")
+)
+
+// SetMailer задаёт отправку писем для подтверждения адреса.
+// Только почта: подтверждение через Telegram ничего не говорит о владельце адреса.
+func (s *Service) SetMailer(m notify.Notifier) {
+	s.mailer = m
+}
+
+// SetEmailVerifyURL — ссылка в письме с подтверждением, к ней дописывается токен
+func (s *Service) SetEmailVerifyURL(url string) {
+	s.emailVerifyURL = url
+}
+
+// SetRequireVerifiedEmail — пускать в чат только с подтверждённым адресом
+func (s *Service) SetRequireVerifiedEmail(require bool) {
+	s.requireVerifiedEmail = require
+}
+
+// EmailVerificationRequired — нужно ли пользователю подтвердить адрес, прежде чем войти в чат.
+// У аккаунтов из Telegram адреса нет, их личность подтверждена через Telegram.
+func (s *Service) EmailVerificationRequired(user *core.User) bool {
+	return s.requireVerifiedEmail && !user.EmailVerifiedAt.Valid && hasRealEmail(user)
+}
+
+func hasRealEmail(user *core.User) bool {
+	return user.Email != "" &&
+		!strings.HasSuffix(user.Email, core.TelegramEmailDomain) &&
+		!strings.HasSuffix(user.Email, core.ReleasedEmailDomain)
+}
+
+// releaseEmail освобождает адрес у давно брошенной неподтверждённой регистрации
+func (s *Service) releaseEmail(email string) {
+	released, err := s.db.ReleaseUnverifiedEmail(email, core.ReleasedEmailDomain, time.Now().Add(-EmailClaimTTL))
+	if err != nil {
+		log.Printf("Failed to release unverified email: %v", err)
+	} else if released {
+		log.Printf("📭 Released an email held by an unverified registration")
+	}
+}
+
+// SendEmailVerification отправляет письмо со ссылкой и кодом подтверждения адреса
+func (s *Service) SendEmailVerification(user *core.User) error {
+	if user.EmailVerifiedAt.Valid {
+		return ErrEmailAlreadyVerified
+	}
+	if !hasRealEmail(user) {
+		return ErrEmailUndeliverable
+	}
+	if s.mailer == nil {
+		return ErrEmailNotConfigured
+	}
+
+	n, err := s.db.CountEmailVerifications(user.YUI, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if n >= maxEmailVerificationsPerHour {
+		return ErrEmailRateLimited
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	code, err := GenerateOTP()
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.CreateEmailVerification(
+		hashToken(token), hashToken(user.YUI+":"+code), user.YUI, user.Email,
+		time.Now().Add(EmailVerificationTTL),
+	); err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Confirm that %s is your email address for YEP.\n\n"+
+		"Verification code: %s\n", user.Email, code)
+	if s.emailVerifyURL != "" {
+		text += fmt.Sprintf("Or open: %s%s\n", s.emailVerifyURL, token)
+	}
+	text += fmt.Sprintf("\nThe code is valid for %d hours. "+
+		"If you didn't create a YEP account, ignore this message.", int(EmailVerificationTTL.Hours()))
+
+	return s.mailer.Notify(user, "Confirm your YEP email", text)
+}
+
+// EnsureEmailVerification отправляет письмо, если действующего ещё нет
+// (аккаунт создан до включения политики, прошлое письмо истекло или адрес сменился)
+func (s *Service) EnsureEmailVerification(user *core.User) error {
+	pending, err := s.db.HasPendingEmailVerification(user.YUI, user.Email)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return nil
+	}
+	return s.SendEmailVerification(user)
+}
+
+// ConfirmEmailToken подтверждает адрес по токену из ссылки. Возвращает YUI владельца.
+func (s *Service) ConfirmEmailToken(token string) (string, error) {
+	yui, err := s.db.ConsumeEmailToken(hashToken(token))
+	if errors.Is(err, storage.ErrEmailTokenInvalid) {
+		return "", ErrEmailTokenInvalid
+	}
+	return yui, err
+}
+
+// ConfirmEmailCode подтверждает адрес по коду из письма.
+// Код короткий, поэтому неудачи считаются с блокировкой по ключу "email:<yui>".
+func (s *Service) ConfirmEmailCode(yui, code string) error {
+	key := "email:" + yui
+	if err := s.checkLocked(key); err != nil {
+		return err
+	}
+
+	err := s.db.ConsumeEmailCode(yui, hashToken(yui+":"+strings.TrimSpace(code)))
+	if err == nil {
+		s.resetFailures(key)
+		return nil
+	}
+	if !errors.Is(err, storage.ErrEmailTokenInvalid) {
+		return err
+	}
+
+	if err := s.recordFailure(key, s.otpPolicy.Lockout); err != nil {
+		return err
+	}
+	return ErrEmailTokenInvalid
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+const testVerifyURL = "https://yep.example/verify?token="
+
+var (
+	verifyCodeRe  = regexp.MustCompile(`Verification code: (\d+)`)
+	verifyTokenRe = regexp.MustCompile(regexp.QuoteMeta(testVerifyURL) + `(\S+)`)
+)
+
+func newEmailService(t *testing.T) (*Service, *fakeStore, *fakeNotifier) {
+	t.Helper()
+	db := newFakeStore(testUser())
+	s := newTestService(t, db)
+	n := newFakeNotifier()
+	s.SetMailer(n)
+	s.SetEmailVerifyURL(testVerifyURL)
+	return s, db, n
+}
+
+// sendVerification отправляет письмо и достаёт из него токен ссылки и код
+func sendVerification(t *testing.T, s *Service, n *fakeNotifier, user *core.User) (token, code string) {
+	t.Helper()
+	if err := s.SendEmailVerification(user); err != nil {
+		t.Fatalf("SendEmailVerification = %v", err)
+	}
+	m := n.next(t)
+	tm, cm := verifyTokenRe.FindStringSubmatch(m.text), verifyCodeRe.FindStringSubmatch(m.text)
+	if tm == nil || cm == nil {
+		t.Fatalf("no link or code in %q", m.text)
+	}
+	return tm[1], cm[1]
+}
+
+func TestConfirmEmailToken(t *testing.T) {
+	s, db, n := newEmailService(t)
+	token, _ := sendVerification(t, s, n, testUser())
+
+	yui, err := s.ConfirmEmailToken(token)
+	if err != nil || yui != "YUI-1" {
+		t.Fatalf("ConfirmEmailToken = %q, %v; want YUI-1, nil", yui, err)
+	}
+	if !db.user("YUI-1").EmailVerifiedAt.Valid {
+		t.Fatal("email is not marked verified")
+	}
+	if _, err := s.ConfirmEmailToken(token); !errors.Is(err, ErrEmailTokenInvalid) {
+		t.Fatalf("second use: err = %v, want %v", err, ErrEmailTokenInvalid)
+	}
+}
+
+func TestConfirmEmailCode(t *testing.T) {
+	s, db, n := newEmailService(t)
+	token, code := sendVerification(t, s, n, testUser())
+
+	// Пробелы вокруг кода из письма не мешают
+	if err := s.ConfirmEmailCode("YUI-1", " "+code+"\n"); err != nil {
+		t.Fatalf("ConfirmEmailCode = %v", err)
+	}
+	if !db.user("YUI-1").EmailVerifiedAt.Valid {
+		t.Fatal("email is not marked verified")
+	}
+	// Подтверждение гасит и ссылку из того же письма
+	if _, err := s.ConfirmEmailToken(token); !errors.Is(err, ErrEmailTokenInvalid) {
+		t.Fatalf("link after code: err = %v, want %v", err, ErrEmailTokenInvalid)
+	}
+}
+
+func TestConfirmEmailCodeOnlyLatest(t *testing.T) {
+	s, _, n := newEmailService(t)
+	_, old := sendVerification(t, s, n, testUser())
+	_, latest := sendVerification(t, s, n, testUser())
+	if old == latest {
+		t.Skip("two random codes collided")
+	}
+
+	if err := s.ConfirmEmailCode("YUI-1", old); !errors.Is(err, ErrEmailTokenInvalid) {
+		t.Fatalf("older code: err = %v, want %v", err, ErrEmailTokenInvalid)
+	}
+	if err := s.ConfirmEmailCode("YUI-1", latest); err != nil {
+		t.Fatalf("latest code = %v", err)
+	}
+}
+
+func TestConfirmEmailCodeBoundToAccount(t *testing.T) {
+	s, db, n := newEmailService(t)
+	other := &core.User{YUI: "YUI-2", Email: "b@example.com", IsActive: true}
+	db.users[other.YUI] = other
+
+	_, code := sendVerification(t, s, n, testUser())
+	sendVerification(t, s, n, other)
+
+	// Код из чужого письма к своему аккаунту не подходит
+	if err := s.ConfirmEmailCode("YUI-2", code); !errors.Is(err, ErrEmailTokenInvalid) {
+		t.Fatalf("err = %v, want %v", err, ErrEmailTokenInvalid)
+	}
+}
+
+func TestConfirmEmailCodeLockout(t *testing.T) {
+	s, _, n := newEmailService(t)
+	s.otpPolicy.Lockout.MaxFailures = 3
+	_, code := sendVerification(t, s, n, testUser())
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 1; i < 3; i++ {
+		if err := s.ConfirmEmailCode("YUI-1", wrong); !errors.Is(err, ErrEmailTokenInvalid) {
+			t.Fatalf("attempt %d: err = %v, want %v", i, err, ErrEmailTokenInvalid)
+		}
+	}
+	var locked *LockedError
+	if err := s.ConfirmEmailCode("YUI-1", wrong); !errors.As(err, &locked) {
+		t.Fatalf("attempt 3: err = %v, want LockedError", err)
+	}
+	// Пока блокировка не снята, не проходит и верный код
+	if err := s.ConfirmEmailCode("YUI-1", code); !errors.As(err, &locked) {
+		t.Fatalf("correct code while locked: err = %v, want LockedError", err)
+	}
+}
+
+func TestConfirmEmailAfterAddressChange(t *testing.T) {
+	s, db, n := newEmailService(t)
+	token, _ := sendVerification(t, s, n, testUser())
+	db.users["YUI-1"].Email = "new@example.com"
+
+	if _, err := s.ConfirmEmailToken(token); !errors.Is(err, ErrEmailTokenInvalid) {
+		t.Fatalf("err = %v, want %v", err, ErrEmailTokenInvalid)
+	}
+	if db.user("YUI-1").EmailVerifiedAt.Valid {
+		t.Fatal("new address verified by a link sent to the old one")
+	}
+}
+
+func TestConfirmEmailExpired(t *testing.T) {
+	s, db, n := newEmailService(t)
+	token, code := sendVerification(t, s, n, testUser())
+
+	v := db.emails[0]
+	if ttl := time.Until(v.expiresAt); ttl <= EmailVerificationTTL-time.Minute || ttl > EmailVerificationTTL {
+		t.Fatalf("verification lives %s, want %s", ttl, EmailVerificationTTL)
+	}
+	v.expiresAt = time.Now().Add(-time.Second)
+
+	if _, err := s.ConfirmEmailToken(token); !errors.Is(err, ErrEmailTokenInvalid) {
+		t.Fatalf("link: err = %v, want %v", err, ErrEmailTokenInvalid)
+	}
+	if err := s.ConfirmEmailCode("YUI-1", code); !errors.Is(err, ErrEmailTokenInvalid) {
+		t.Fatalf("code: err = %v, want %v", err, ErrEmailTokenInvalid)
+	}
+}
+
+func TestSendEmailVerificationRefuses(t *testing.T) {
+	verified := testUser()
+	verified.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	telegram := testUser()
+	telegram.Email = "YUI-1" + core.TelegramEmailDomain
+	released := testUser()
+	released.Email = "YUI-1" + core.ReleasedEmailDomain
+
+	tests := []struct {
+		name     string
+		user     *core.User
+		noMailer bool
+		want     error
+	}{
+		{"already verified", verified, false, ErrEmailAlreadyVerified},
+		{"telegram account", telegram, false, ErrEmailUndeliverable},
+		{"released address", released, false, ErrEmailUndeliverable},
+		{"no mailer", testUser(), true, ErrEmailNotConfigured},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, n := newEmailService(t)
+			if tt.noMailer {
+				s.SetMailer(nil)
+			}
+			if err := s.SendEmailVerification(tt.user); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			n.none(t, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestSendEmailVerificationRateLimit(t *testing.T) {
+	s, _, n := newEmailService(t)
+	for i := 0; i < maxEmailVerificationsPerHour; i++ {
+		sendVerification(t, s, n, testUser())
+	}
+	if err := s.SendEmailVerification(testUser()); !errors.Is(err, ErrEmailRateLimited) {
+		t.Fatalf("err = %v, want %v", err, ErrEmailRateLimited)
+	}
+}
+
+func TestEnsureEmailVerification(t *testing.T) {
+	s, db, n := newEmailService(t)
+
+	if err := s.EnsureEmailVerification(testUser()); err != nil {
+		t.Fatal(err)
+	}
+	n.next(t)
+
+	// Действующее письмо уже есть — второе не отправляем
+	if err := s.EnsureEmailVerification(testUser()); err != nil {
+		t.Fatal(err)
+	}
+	n.none(t, 10*time.Millisecond)
+
+	// Адрес сменился — нужно письмо на новый
+	db.users["YUI-1"].Email = "new@example.com"
+	changed := testUser()
+	changed.Email = "new@example.com"
+	if err := s.EnsureEmailVerification(changed); err != nil {
+		t.Fatal(err)
+	}
+	if m := n.next(t); !strings.Contains(m.text, "new@example.com") {
+		t.Fatalf("verification text = %q, want the new address", m.text)
+	}
+}
+
+func TestEmailVerificationRequired(t *testing.T) {
+	verified := testUser()
+	verified.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	telegram := testUser()
+	telegram.Email = "YUI-1" + core.TelegramEmailDomain
+
+	tests := []struct {
+		name    string
+		require bool
+		user    *core.User
+		want    bool
+	}{
+		{"policy off", false, testUser(), false},
+		{"unverified", true, testUser(), true},
+		{"verified", true, verified, false},
+		{"telegram account", true, telegram, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newEmailService(t)
+			s.SetRequireVerifiedEmail(tt.require)
+			if got := s.EmailVerificationRequired(tt.user); got != tt.want {
+				t.Fatalf("EmailVerificationRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,52 +1,71 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"time"
 )
 
-// Секретный ключ (потом в ENV переменную!)
-var jwtSecret = []byte("yep-secret-key-change-this-in-production")
+const (
+	AccessTokenTTL  = 24 * time.Hour
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
 
 type TokenClaims struct {
-	YUI   string `json:"yui"`
-	Email string `json:"email"`
-	Level string `json:"level"`
+	YUI       string `json:"yui"`
+	Email     string `json:"email"`
+	Level     string `json:"level"`
+	SessionID string `json:"sid"` // стабильный id сессии, не меняется при ротации токенов
 	jwt.RegisteredClaims
 }
 
-// Создаём токен
-func GenerateToken(yui, email, level string) (string, error) {
+// SessionChecker проверяет по jti, что сессия токена не отозвана
+type SessionChecker interface {
+	IsSessionActive(jti string) bool
+}
+
+var sessionChecker SessionChecker
+
+// SetSessionChecker включает проверку отзыва в ValidateToken
+func SetSessionChecker(c SessionChecker) {
+	sessionChecker = c
+}
+
+// Создаём токен. Возвращает сам токен и его jti.
+func GenerateToken(yui, email, level, sessionID string) (string, string, error) {
+	jti := newTokenID()
 	claims := TokenClaims{
-		YUI:   yui,
-		Email: email,
-		Level: level,
+		YUI:       yui,
+		Email:     email,
+		Level:     level,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "yep-protocol",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	signed, err := keySet.sign(claims)
+	return signed, jti, err
 }
 
 // Проверяем токен
 func ValidateToken(tokenString string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, keySet.keyFunc, jwt.WithIssuer("yep-protocol"))
 
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
+		// Токен мог быть отозван до истечения срока
+		if sessionChecker != nil && (claims.ID == "" || !sessionChecker.IsSessionActive(claims.ID)) {
+			return nil, fmt.Errorf("token revoked")
+		}
 		return claims, nil
 	}
 
@@ -56,12 +75,33 @@ func ValidateToken(tokenString string) (*TokenClaims, error) {
 // Refresh token (живёт дольше)
 func GenerateRefreshToken(yui string) (string, error) {
 	claims := jwt.RegisteredClaims{
+		ID:        newTokenID(), // уникальный jti: два токена одного пользователя никогда не совпадут
 		Subject:   yui,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)), // 7 дней
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(RefreshTokenTTL)), // 7 дней
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
 		Issuer:    "yep-protocol-refresh",
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return keySet.sign(claims)
+}
+
+// Проверяем refresh token, возвращаем YUI владельца
+func ValidateRefreshToken(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keySet.keyFunc, jwt.WithIssuer("yep-protocol-refresh"))
+
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid || claims.Subject == "" {
+		return "", fmt.Errorf("invalid token")
+	}
+
+	return claims.Subject, nil
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Ключ HS256 из JWT_SECRET
+const defaultKeyID = "hs-default"
+
+var ErrNoSigningKey = errors.New("no JWT signing key configured")
+
+// SigningKey — ключ подписи/проверки JWT.
+// Для асимметричных ключей без приватной части signKey == nil: ключ только проверяет.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{} // []byte | ed25519.PrivateKey | *rsa.PrivateKey
+	verifyKey interface{} // []byte | ed25519.PublicKey | *rsa.PublicKey
+}
+
+func (k *SigningKey) canSign() bool {
+	return k.signKey != nil
+}
+
+// KeySet — активный ключ подписи и все ключи, которыми ещё принимаем токены.
+// Ротация: добавляем новый ключ, делаем его активным, старый держим до истечения выданных токенов.
+type KeySet struct {
+	mu     sync.RWMutex
+	active string
+	keys   map[string]*SigningKey
+	// HS256-ключи принимаются до этого момента, если подписываем асимметричным ключом;
+	// нулевое значение — не ограничено (подписываем HS256)
+	hsUntil time.Time
+}
+
+// KeyConfig — ключи из конфига
+type KeyConfig struct {
+	Secret    string // JWT_SECRET: HS256-ключ с kid "hs-default"
+	Keys      string // JWT_KEYS: "kid:alg:/path/key.pem;kid2:alg:/path2.pem"
+	ActiveKID string // JWT_ACTIVE_KID: каким ключом подписываем
+	// JWT_LEGACY_HS_UNTIL: после перехода на асимметричный ключ HS256-токены
+	// ещё принимаются до этого момента; нулевое — сразу перестают
+	LegacyHSUntil time.Time
+}
+
+// Пока ключи не загружены, токены не выдаются и не принимаются
+var keySet = &KeySet{keys: map[string]*SigningKey{}}
+
+// SetKeySet заменяет ключи, которыми подписываются и проверяются токены
+func SetKeySet(ks *KeySet) {
+	keySet = ks
+}
+
+// LoadKeySet собирает ключи из конфига.
+// Без JWT_SECRET запускаемся, только если подписываем асимметричным ключом из JWT_KEYS.
+func LoadKeySet(cfg KeyConfig) (*KeySet, error) {
+	if cfg.Secret == "" && cfg.ActiveKID == "" {
+		return nil, fmt.Errorf("JWT_SECRET is required")
+	}
+
+	ks := &KeySet{
+		active: defaultKeyID,
+		keys:   map[string]*SigningKey{},
+	}
+	if cfg.Secret != "" {
+		secret := []byte(cfg.Secret)
+		ks.keys[defaultKeyID] = &SigningKey{ID: defaultKeyID, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+	}
+
+	for _, spec := range strings.Split(cfg.Keys, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid JWT key spec %q, want kid:alg:path", spec)
+		}
+		key, err := loadKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", parts[0], err)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	if cfg.ActiveKID != "" {
+		key, ok := ks.keys[cfg.ActiveKID]
+		if !ok {
+			return nil, fmt.Errorf("active key %s is not configured", cfg.ActiveKID)
+		}
+		if !key.canSign() {
+			return nil, fmt.Errorf("active key %s has no private part", cfg.ActiveKID)
+		}
+		ks.active = cfg.ActiveKID
+	}
+
+	// Подписываем асимметричным ключом: общий HS-секрет больше не должен открывать вход.
+	// Старые HS256-токены принимаем только в явно заданное окно.
+	if ks.keys[ks.active].Method != jwt.SigningMethodHS256 {
+		if cfg.LegacyHSUntil.After(time.Now()) {
+			ks.hsUntil = cfg.LegacyHSUntil
+			log.Printf("⚠️ HS256 tokens are still accepted until %s", cfg.LegacyHSUntil.Format(time.RFC3339))
+		} else {
+			for kid, key := range ks.keys {
+				if key.Method == jwt.SigningMethodHS256 {
+					delete(ks.keys, kid)
+				}
+			}
+		}
+	}
+
+	return ks, nil
+}
+
+func loadKey(kid, alg, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case "HS256":
+		secret := []byte(strings.TrimSpace(string(data)))
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+	case "EdDSA", "RS256":
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: kid}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodEdDSA, k
+	case *rsa.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodRS256, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	if key.Method.Alg() != alg {
+		return nil, fmt.Errorf("key is %s, configured as %s", key.Method.Alg(), alg)
+	}
+	return key, nil
+}
+
+// sign подписывает claims активным ключом и проставляет kid
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.keys[ks.active]
+	ks.mu.RUnlock()
+	if key == nil || !key.canSign() {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// keyFunc выбирает ключ проверки по kid; токены без kid — старые, подписаны ключом по умолчанию
+// (принимаются, только пока HS-ключ участвует в проверке)
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = defaultKeyID
+	}
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	hsUntil := ks.hsUntil
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	if key.Method == jwt.SigningMethodHS256 && !hsUntil.IsZero() && time.Now().After(hsUntil) {
+		return nil, fmt.Errorf("HS256 key %s is no longer accepted", kid)
+	}
+
+	// Алгоритм задаёт ключ, а не заголовок токена
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// JWK — публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS возвращает публичные ключи для /.well-known/jwks.json.
+// HS256-секреты наружу не отдаются никогда.
+func PublicJWKS() JWKS {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range keySet.keys {
+		enc := base64.RawURLEncoding
+		switch pub := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP", Crv: "Ed25519", Kid: key.ID, Alg: key.Method.Alg(), Use: "sig",
+				X: enc.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA", Kid: key.ID, Alg: key.Method.Alg(), Use: "sig",
+				N: enc.EncodeToString(pub.N.Bytes()),
+				E: enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeEd25519Key сохраняет новый приватный Ed25519-ключ в PEM и возвращает путь
+func writeEd25519Key(t *testing.T) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ed.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// useKeySet подменяет глобальные ключи на время теста
+func useKeySet(t *testing.T, ks *KeySet) {
+	t.Helper()
+	prev := keySet
+	SetKeySet(ks)
+	t.Cleanup(func() { SetKeySet(prev) })
+}
+
+// legacyHSToken — токен, подписанный JWT_SECRET до перехода на асимметричный ключ
+func legacyHSToken(t *testing.T, secret string) string {
+	t.Helper()
+	ks, err := LoadKeySet(KeyConfig{Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+	token, _, err := GenerateToken("YUI-1", "a@example.com", "U", "sid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestLoadKeySetHSCutoff(t *testing.T) {
+	const secret = "legacy-secret"
+	edPath := writeEd25519Key(t)
+
+	tests := []struct {
+		name       string
+		legacyHS   time.Time
+		wantLegacy bool // принимается ли старый HS256-токен
+	}{
+		{"no window", time.Time{}, false},
+		{"window in the past", time.Now().Add(-time.Hour), false},
+		{"window open", time.Now().Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := legacyHSToken(t, secret)
+
+			ks, err := LoadKeySet(KeyConfig{
+				Secret:        secret,
+				Keys:          "ed1:EdDSA:" + edPath,
+				ActiveKID:     "ed1",
+				LegacyHSUntil: tt.legacyHS,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			useKeySet(t, ks)
+
+			_, err = ValidateToken(old)
+			if got := err == nil; got != tt.wantLegacy {
+				t.Fatalf("legacy HS256 token accepted = %v, want %v (err = %v)", got, tt.wantLegacy, err)
+			}
+
+			// Новые токены подписаны активным ключом и проверяются всегда
+			token, _, err := GenerateToken("YUI-1", "a@example.com", "U", "sid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &TokenClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kid := parsed.Header["kid"]; kid != "ed1" || parsed.Method.Alg() != "EdDSA" {
+				t.Fatalf("new token kid = %v, alg = %s; want ed1, EdDSA", kid, parsed.Method.Alg())
+			}
+			if _, err := ValidateToken(token); err != nil {
+				t.Fatalf("ValidateToken(new) = %v", err)
+			}
+		})
+	}
+}
+
+func TestLegacyHSWindowCloses(t *testing.T) {
+	const secret = "legacy-secret"
+	old := legacyHSToken(t, secret)
+
+	ks, err := LoadKeySet(KeyConfig{
+		Secret:        secret,
+		Keys:          "ed1:EdDSA:" + writeEd25519Key(t),
+		ActiveKID:     "ed1",
+		LegacyHSUntil: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+	if _, err := ValidateToken(old); err != nil {
+		t.Fatalf("ValidateToken inside the window = %v", err)
+	}
+
+	// Окно истекло без перезапуска: ключ ещё в наборе, но уже не принимается
+	ks.mu.Lock()
+	ks.hsUntil = time.Now().Add(-time.Second)
+	ks.mu.Unlock()
+	if _, err := ValidateToken(old); err == nil {
+		t.Fatal("HS256 token accepted after the window closed")
+	}
+}
+
+func TestKeyFuncRejectsAlgorithmSwap(t *testing.T) {
+	ks, err := LoadKeySet(KeyConfig{Keys: "ed1:EdDSA:" + writeEd25519Key(t), ActiveKID: "ed1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+
+	// HS256-токен с kid асимметричного ключа: секретом служил бы публичный ключ
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, TokenClaims{
+		YUI:              "YUI-1",
+		RegisteredClaims: jwt.RegisteredClaims{Issuer: "yep-protocol", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	forged.Header["kid"] = "ed1"
+	signed, err := forged.SignedString([]byte("anything"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(signed); err == nil {
+		t.Fatal("token with a swapped algorithm accepted")
+	}
+}
+
+func TestPublicJWKS(t *testing.T) {
+	ks, err := LoadKeySet(KeyConfig{
+		Secret:        "legacy-secret",
+		Keys:          "ed1:EdDSA:" + writeEd25519Key(t),
+		ActiveKID:     "ed1",
+		LegacyHSUntil: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+
+	// HS-ключ ещё принимается, но наружу не отдаётся
+	jwks := PublicJWKS()
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("JWKS has %d keys, want 1: %+v", len(jwks.Keys), jwks.Keys)
+	}
+	k := jwks.Keys[0]
+	if k.Kid != "ed1" || k.Kty != "OKP" || k.Crv != "Ed25519" || k.Alg != "EdDSA" || k.Use != "sig" || k.X == "" {
+		t.Fatalf("JWK = %+v", k)
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	edPath := writeEd25519Key(t)
+
+	tests := []struct {
+		name string
+		cfg  KeyConfig
+	}{
+		{"no keys", KeyConfig{}},
+		{"bad spec", KeyConfig{Secret: "s", Keys: "ed1:" + edPath}},
+		{"unknown active", KeyConfig{Secret: "s", ActiveKID: "nope"}},
+		{"alg mismatch", KeyConfig{Secret: "s", Keys: "ed1:RS256:" + edPath}},
+		{"missing file", KeyConfig{Secret: "s", Keys: "ed1:EdDSA:" + filepath.Join(t.TempDir(), "none.pem")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadKeySet(tt.cfg); err == nil {
+				t.Fatal("LoadKeySet succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"yep-protocol/internal/storage"
+)
+
+// LockedError — ключ (номер, вход, второй фактор) временно заблокирован после серии неудачных попыток
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many attempts, try again in %s", time.Until(e.Until).Round(time.Second))
+}
+
+// Счётчики неудач без активности дольше этого срока удаляются
+const lockoutsTTL = 24 * time.Hour
+
+// checkLocked возвращает LockedError, пока ключ заблокирован.
+// Ключ — phone_hash для OTP, "totp:<yui>" для второго фактора, "email:<yui>" для кода из письма, "login:..." и "login-ip:..." для входа по паролю.
+func (s *Service) checkLocked(key string) error {
+	until, err := s.db.LockedUntil(key)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return &LockedError{Until: until}
+	}
+	return nil
+}
+
+// recordFailure учитывает неудачу; если началась блокировка — возвращает LockedError
+func (s *Service) recordFailure(key string, policy storage.Lockout) error {
+	lockedUntil, err := s.db.RecordFailure(key, policy)
+	if err != nil {
+		log.Printf("Failed to record failure: %v", err)
+	}
+	if !lockedUntil.IsZero() {
+		log.Printf("🔒 Locked until %s after repeated failures", lockedUntil.Format(time.RFC3339))
+		return &LockedError{Until: lockedUntil}
+	}
+	return nil
+}
+
+func (s *Service) resetFailures(key string) {
+	if err := s.db.ResetFailures(key); err != nil {
+		log.Printf("Failed to reset failures: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Сколько хранится история входов
+const LoginHistoryRetention = 90 * 24 * time.Hour
+
+// LoginPolicy — защита входа по паролю от перебора и credential stuffing
+type LoginPolicy struct {
+	Account storage.Lockout // неудачи по одному email
+	IP      storage.Lockout // неудачи с одного адреса по любым аккаунтам
+
+	// После DelayAfter неудач подряд ответ задерживается на DelayBase, дальше вдвое дольше каждый раз
+	DelayAfter int
+	DelayBase  time.Duration
+	DelayMax   time.Duration
+}
+
+var DefaultLoginPolicy = LoginPolicy{
+	Account: storage.Lockout{
+		MaxFailures: 5,
+		Window:      15 * time.Minute,
+		Base:        time.Minute,
+		Max:         time.Hour,
+	},
+	IP: storage.Lockout{
+		MaxFailures: 30,
+		Window:      time.Hour,
+		Base:        5 * time.Minute,
+		Max:         time.Hour,
+	},
+	DelayAfter: 2,
+	DelayBase:  500 * time.Millisecond,
+	DelayMax:   8 * time.Second,
+}
+
+// Способы входа в истории
+const (
+	LoginPassword = "password"
+	LoginRegister = "register"
+	LoginTelegram = "telegram"
+	LoginRefresh  = "refresh"
+)
+
+// SignInNotifier предупреждает уже открытые сессии пользователя о новом входе
+type SignInNotifier interface {
+	NotifySignIn(yui, sessionID string, meta SessionMeta)
+}
+
+// SetSignInNotifier задаёт, кто показывает NEW_SIGN_IN остальным сессиям
+func (s *Service) SetSignInNotifier(n SignInNotifier) {
+	s.signIns = n
+}
+
+// StartSession — единая точка входа для всех способов: открывает сессию,
+// пишет вход в историю и предупреждает остальные сессии пользователя
+func (s *Service) StartSession(user *core.User, meta SessionMeta, method string) (*TokenPair, error) {
+	tokens, err := s.IssueTokens(user, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordLogin(&storage.LoginAttempt{
+		YUI:     user.YUI,
+		Email:   strings.ToLower(user.Email),
+		IP:      meta.IP,
+		Device:  meta.Device,
+		Method:  method,
+		Success: true,
+	})
+	if s.signIns != nil {
+		s.signIns.NotifySignIn(user.YUI, tokens.SessionID, meta)
+	}
+	return tokens, nil
+}
+
+// Login проверяет пароль с учётом блокировок по аккаунту и IP и пишет неудачи в историю входов.
+// Успешный вход записывается в StartSession, когда сессия действительно открыта.
+func (s *Service) Login(email, password string, meta SessionMeta) (*core.User, error) {
+	attempt := &storage.LoginAttempt{
+		Email:  strings.ToLower(strings.TrimSpace(email)),
+		IP:     meta.IP,
+		Device: meta.Device,
+		Method: LoginPassword,
+	}
+	// Ключи блокировок: email хэшируем, чтобы не хранить его в таблице блокировок
+	keys := []string{"login:" + hashToken(attempt.Email)}
+	if meta.IP != "" {
+		keys = append(keys, "login-ip:"+meta.IP)
+	}
+
+	for _, key := range keys {
+		if err := s.checkLocked(key); err != nil {
+			attempt.Reason = "locked"
+			s.recordLogin(attempt)
+			return nil, err
+		}
+	}
+
+	user, err := s.db.GetUserByEmail(email)
+	if err != nil {
+		// Хэшируем впустую: время ответа не выдаёт, существует ли email
+		s.hasher.Hash(password)
+		attempt.Reason = "unknown_user"
+		return nil, s.loginFailed(attempt, keys)
+	}
+	attempt.YUI = user.YUI
+
+	ok, err := VerifyPassword(user.PasswordHash, password)
+	if err != nil && !errors.Is(err, ErrUnknownHashFormat) {
+		log.Printf("Password check for %s failed: %v", user.YUI, err)
+	}
+	if !ok {
+		attempt.Reason = "bad_password"
+		return nil, s.loginFailed(attempt, keys)
+	}
+
+	// Счётчик по IP не сбрасываем: иначе перебор чужих паролей можно «разбавлять» входом в свой аккаунт
+	s.resetFailures(keys[0])
+
+	// Пароль известен только сейчас: переводим хэш на текущий алгоритм и параметры
+	if s.hasher.NeedsRehash(user.PasswordHash) {
+		if hash, err := s.hasher.Hash(password); err != nil {
+			log.Printf("Failed to rehash password of %s: %v", user.YUI, err)
+		} else if err := s.db.UpdatePasswordHash(user.YUI, hash); err != nil {
+			log.Printf("Failed to store rehashed password of %s: %v", user.YUI, err)
+		} else {
+			user.PasswordHash = hash
+		}
+	}
+
+	s.db.UpdateLastLogin(user.YUI)
+	return user, nil
+}
+
+// loginFailed учитывает неудачу по всем ключам и задерживает ответ, если неудачи идут подряд
+func (s *Service) loginFailed(attempt *storage.LoginAttempt, keys []string) error {
+	s.recordLogin(attempt)
+
+	var lockErr error
+	for i, key := range keys {
+		policy := s.loginPolicy.Account
+		if i > 0 {
+			policy = s.loginPolicy.IP
+		}
+		if err := s.recordFailure(key, policy); err != nil && lockErr == nil {
+			lockErr = err
+		}
+	}
+	if lockErr != nil {
+		return lockErr
+	}
+
+	n, err := s.db.RecentLoginFailures(attempt.Email, time.Now().Add(-s.loginPolicy.Account.Window))
+	if err != nil {
+		log.Printf("Failed to count login failures: %v", err)
+	}
+	time.Sleep(s.loginPolicy.delay(n))
+	return ErrInvalidCredentials
+}
+
+// delay — задержка ответа после n неудач подряд
+func (p LoginPolicy) delay(n int) time.Duration {
+	if n <= p.DelayAfter {
+		return 0
+	}
+	d := p.DelayBase
+	for i := p.DelayAfter + 1; i < n && d < p.DelayMax; i++ {
+		d *= 2
+	}
+	return min(d, p.DelayMax)
+}
+
+func (s *Service) recordLogin(attempt *storage.LoginAttempt) {
+	if err := s.db.RecordLogin(attempt); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+	if !attempt.Success {
+		log.Printf("[AUTH] login failed (%s) from %s", attempt.Reason, attempt.IP)
+	}
+}
+
+// LoginHistory — последние входы пользователя
+func (s *Service) LoginHistory(yui string, limit int) ([]*storage.LoginAttempt, error) {
+	return s.db.ListLogins(yui, limit)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"yep-protocol/internal/storage"
+)
+
+// newLoginService — пользователь a@example.com с паролем "old-password"; блокировка после трёх неудач
+func newLoginService(t *testing.T) (*Service, *fakeStore) {
+	t.Helper()
+	db := newFakeStore()
+	s := newTestService(t, db)
+	u := resetUser(t, s)
+	db.users[u.YUI] = u
+
+	lockout := storage.Lockout{MaxFailures: 3, Window: time.Hour, Base: time.Minute, Max: time.Hour}
+	s.loginPolicy = LoginPolicy{Account: lockout, IP: lockout, DelayAfter: 1000}
+	return s, db
+}
+
+func wantLocked(t *testing.T, err error) {
+	t.Helper()
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("err = %v, want LockedError", err)
+	}
+	if !locked.Until.After(time.Now()) {
+		t.Fatalf("locked until %s, want a future time", locked.Until)
+	}
+}
+
+func TestLoginAccountLockout(t *testing.T) {
+	s, db := newLoginService(t)
+
+	for i := 1; i < 3; i++ {
+		if _, err := s.Login("a@example.com", "wrong", SessionMeta{IP: "198.51.100.1"}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: err = %v, want %v", i, err, ErrInvalidCredentials)
+		}
+	}
+	_, err := s.Login("a@example.com", "wrong", SessionMeta{IP: "198.51.100.2"})
+	wantLocked(t, err)
+
+	// Аккаунт заблокирован с любого адреса, даже с верным паролем
+	_, err = s.Login("a@example.com", "old-password", SessionMeta{IP: "198.51.100.3"})
+	wantLocked(t, err)
+
+	want := []string{"bad_password", "bad_password", "bad_password", "locked"}
+	if reasons := db.loginReasons(); !reflect.DeepEqual(reasons, want) {
+		t.Fatalf("login history = %v, want %v", reasons, want)
+	}
+}
+
+func TestLoginUnknownUserCountsLikeBadPassword(t *testing.T) {
+	s, db := newLoginService(t)
+
+	// Неизвестный email отвечает так же и блокируется так же
+	for i := 1; i < 3; i++ {
+		if _, err := s.Login("nobody@example.com", "x", SessionMeta{}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: err = %v, want %v", i, err, ErrInvalidCredentials)
+		}
+	}
+	_, err := s.Login("nobody@example.com", "x", SessionMeta{})
+	wantLocked(t, err)
+
+	if reasons := db.loginReasons(); reasons[0] != "unknown_user" {
+		t.Fatalf("login history = %v, want unknown_user", reasons)
+	}
+}
+
+func TestLoginAccountKeyIgnoresCase(t *testing.T) {
+	s, _ := newLoginService(t)
+
+	for _, email := range []string{"a@example.com", " A@Example.com", "A@EXAMPLE.COM "} {
+		s.Login(email, "wrong", SessionMeta{})
+	}
+	_, err := s.Login("a@example.com", "old-password", SessionMeta{})
+	wantLocked(t, err)
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	s, _ := newLoginService(t)
+	const ip = "203.0.113.9"
+
+	// Перебор по разным аккаунтам с одного адреса
+	for i, email := range []string{"b@example.com", "c@example.com"} {
+		if _, err := s.Login(email, "x", SessionMeta{IP: ip}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: err = %v, want %v", i+1, err, ErrInvalidCredentials)
+		}
+	}
+	_, err := s.Login("d@example.com", "x", SessionMeta{IP: ip})
+	wantLocked(t, err)
+
+	// С этого адреса не войти и в чужой, ни разу не ошибавшийся аккаунт
+	_, err = s.Login("a@example.com", "old-password", SessionMeta{IP: ip})
+	wantLocked(t, err)
+
+	// С другого адреса аккаунт доступен
+	if _, err := s.Login("a@example.com", "old-password", SessionMeta{IP: "203.0.113.10"}); err != nil {
+		t.Fatalf("login from another IP = %v", err)
+	}
+}
+
+func TestLoginSuccessResetsAccountOnly(t *testing.T) {
+	s, _ := newLoginService(t)
+	s.loginPolicy.IP.MaxFailures = 5
+	meta := SessionMeta{IP: "192.0.2.4"}
+
+	for i := 0; i < 2; i++ {
+		s.Login("a@example.com", "wrong", meta)
+	}
+	if _, err := s.Login("a@example.com", "old-password", meta); err != nil {
+		t.Fatalf("login = %v", err)
+	}
+
+	// Счётчик аккаунта сброшен: две неудачи снова не блокируют
+	for i := 0; i < 2; i++ {
+		if _, err := s.Login("a@example.com", "wrong", meta); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("after success, attempt %d: err = %v, want %v", i+1, err, ErrInvalidCredentials)
+		}
+	}
+	// Счётчик адреса — нет: вход в свой аккаунт не «разбавляет» перебор
+	_, err := s.Login("e@example.com", "x", meta)
+	wantLocked(t, err)
+}
+
+func TestLoginDelay(t *testing.T) {
+	p := LoginPolicy{DelayAfter: 2, DelayBase: 500 * time.Millisecond, DelayMax: 4 * time.Second}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 500 * time.Millisecond},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{50, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+	"yep-protocol/internal/storage"
+)
+
+var (
+	ErrOTPInvalid = errors.New("invalid code")
+	ErrOTPExpired = errors.New("code expired or not found")
+)
+
+// OTPPolicy — срок жизни кодов и ограничения на перебор
+type OTPPolicy struct {
+	TTL         time.Duration // сколько живёт код
+	MaxAttempts int           // неверных попыток на один код
+	Lockout     storage.Lockout
+}
+
+var DefaultOTPPolicy = OTPPolicy{
+	TTL:         5 * time.Minute,
+	MaxAttempts: 5,
+	Lockout: storage.Lockout{
+		MaxFailures: 10,
+		Window:      time.Hour,
+		Base:        time.Minute,
+		Max:         24 * time.Hour,
+	},
+}
+
+// Длина кода подтверждения
+const otpDigits = 6
+
+// GenerateOTP создаёт случайный код из otpDigits цифр
+func GenerateOTP() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n), nil
+}
+
+// IssueOTP генерирует новый код для номера и возвращает его для доставки,
+// telegramID (если известен) привязывается к пользователю.
+// Пока номер заблокирован, новых кодов не выдаём.
+func (s *Service) IssueOTP(phoneHash string, telegramID int64) (string, error) {
+	if err := s.checkLocked(phoneHash); err != nil {
+		return "", err
+	}
+
+	code, err := GenerateOTP()
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.SaveOTP(phoneHash, otpHash(phoneHash, code), s.otpPolicy.TTL); err != nil {
+		return "", err
+	}
+
+	// Бот получил номер из контакта самого пользователя — Telegram-аккаунт подтверждён
+	if telegramID != 0 {
+		if err := s.db.LinkTelegramID(phoneHash, telegramID); err != nil {
+			log.Printf("Failed to link telegram_id: %v", err)
+		}
+	}
+	return code, nil
+}
+
+// OTPTTL — сколько живёт выданный код
+func (s *Service) OTPTTL() time.Duration {
+	return s.otpPolicy.TTL
+}
+
+// otpHash — в БД лежит только хэш кода; номер в нём, чтобы одинаковые коды разных номеров не совпадали
+func otpHash(phoneHash, code string) string {
+	return hashToken(phoneHash + ":" + code)
+}
+
+// checkOTP проверяет и гасит код с учётом блокировок
+func (s *Service) checkOTP(phoneHash, code string) error {
+	if err := s.checkLocked(phoneHash); err != nil {
+		return err
+	}
+
+	err := s.db.ConsumeOTP(phoneHash, otpHash(phoneHash, code), s.otpPolicy.MaxAttempts)
+	if err == nil {
+		s.resetFailures(phoneHash)
+		return nil
+	}
+	if !errors.Is(err, storage.ErrOTPInvalid) && !errors.Is(err, storage.ErrOTPNotFound) {
+		return err
+	}
+
+	if err := s.recordFailure(phoneHash, s.otpPolicy.Lockout); err != nil {
+		return err
+	}
+	if errors.Is(err, storage.ErrOTPNotFound) {
+		return ErrOTPExpired
+	}
+	return ErrOTPInvalid
+}
+
+// StartCleanup периодически удаляет истёкшие коды, старые счётчики неудач, токены сброса пароля и подтверждения email, старую историю входов
+func (s *Service) StartCleanup(interval time.Duration) {
+	go func() {
+		for {
+			if n, err := s.db.DeleteExpiredOTP(); err != nil {
+				log.Printf("OTP cleanup failed: %v", err)
+			} else if n > 0 {
+				log.Printf("🧹 Deleted %d expired OTP codes", n)
+			}
+			if err := s.db.DeleteStaleLockouts(lockoutsTTL); err != nil {
+				log.Printf("Lockout cleanup failed: %v", err)
+			}
+			// Записи о сбросах нужны только для лимита запросов за последний час
+			if err := s.db.DeleteExpiredPasswordResets(time.Now().Add(-24 * time.Hour)); err != nil {
+				log.Printf("Password reset cleanup failed: %v", err)
+			}
+			if err := s.db.DeleteExpiredEmailVerifications(time.Now().Add(-EmailVerificationTTL)); err != nil {
+				log.Printf("Email verification cleanup failed: %v", err)
+			}
+			if err := s.db.DeleteOldLogins(time.Now().Add(-LoginHistoryRetention)); err != nil {
+				log.Printf("Login history cleanup failed: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
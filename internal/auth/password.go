@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher хэширует пароли для хранения. Проверка не зависит от текущего хэшера:
+// VerifyPassword понимает все поддерживаемые форматы, так что алгоритм можно менять на ходу.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash — хэш сделан другим алгоритмом или более слабыми параметрами
+	NeedsRehash(encoded string) bool
+	// MaxPasswordLength — сколько байт пароля алгоритм учитывает (0 — без ограничения)
+	MaxPasswordLength() int
+}
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// Argon2Params — параметры Argon2id
+type Argon2Params struct {
+	Memory      uint32 // КиБ
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params — рекомендация OWASP: 19 МиБ, 2 прохода, 1 поток
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Пределы параметров Argon2id: хэш с большими значениями не проверяем (и не создаём),
+// чтобы подложенная в базу строка не заставила сервер выделять гигабайты на каждый вход
+const (
+	maxArgon2Memory      = 256 * 1024 // КиБ
+	maxArgon2Iterations  = 16
+	maxArgon2Parallelism = 16
+	minArgon2SaltLength  = 8
+	maxArgon2SaltLength  = 64
+	minArgon2KeyLength   = 16
+	maxArgon2KeyLength   = 64
+)
+
+func (p Argon2Params) validate() error {
+	switch {
+	case p.Parallelism == 0 || p.Parallelism > maxArgon2Parallelism:
+		return fmt.Errorf("argon2 parallelism must be between 1 and %d", maxArgon2Parallelism)
+	case p.Memory < 8*uint32(p.Parallelism) || p.Memory > maxArgon2Memory:
+		return fmt.Errorf("argon2 memory must be between %d and %d KiB", 8*uint32(p.Parallelism), maxArgon2Memory)
+	case p.Iterations == 0 || p.Iterations > maxArgon2Iterations:
+		return fmt.Errorf("argon2 iterations must be between 1 and %d", maxArgon2Iterations)
+	case p.SaltLength < minArgon2SaltLength || p.SaltLength > maxArgon2SaltLength:
+		return fmt.Errorf("argon2 salt must be %d to %d bytes", minArgon2SaltLength, maxArgon2SaltLength)
+	case p.KeyLength < minArgon2KeyLength || p.KeyLength > maxArgon2KeyLength:
+		return fmt.Errorf("argon2 key must be %d to %d bytes", minArgon2KeyLength, maxArgon2KeyLength)
+	}
+	return nil
+}
+
+// Argon2idHasher хранит хэши в формате PHC: $argon2id$v=19$m=...,t=...,p=...$<salt>$<hash>
+type Argon2idHasher struct {
+	params Argon2Params
+}
+
+func NewArgon2idHasher(params Argon2Params) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	p := h.params
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, _, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.Memory < h.params.Memory ||
+		p.Iterations < h.params.Iterations ||
+		p.Parallelism < h.params.Parallelism ||
+		p.SaltLength < h.params.SaltLength ||
+		uint32(len(key)) < h.params.KeyLength
+}
+
+func (h *Argon2idHasher) MaxPasswordLength() int {
+	return 0
+}
+
+// bcrypt учитывает только первые 72 байта пароля
+const bcryptMaxPasswordLength = 72
+
+// BcryptHasher — прежний алгоритм; оставлен для развёртываний, которым Argon2id не подходит
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost
+}
+
+func (h *BcryptHasher) MaxPasswordLength() int {
+	return bcryptMaxPasswordLength
+}
+
+// NewPasswordHasher выбирает хэшер по имени из конфигурации
+func NewPasswordHasher(name string, params Argon2Params) (PasswordHasher, error) {
+	switch name {
+	case "", "argon2id":
+		if err := params.validate(); err != nil {
+			return nil, err
+		}
+		return NewArgon2idHasher(params), nil
+	case "bcrypt":
+		return NewBcryptHasher(bcrypt.DefaultCost), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", name)
+	}
+}
+
+// VerifyPassword проверяет пароль по хэшу в любом поддерживаемом формате
+func VerifyPassword(encoded, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		// Старые хэши строились по первым 72 байтам, а CompareHashAndPassword длинный пароль отвергает.
+		// После входа хэш переводится на Argon2id уже по полному паролю.
+		if len(password) > bcryptMaxPasswordLength {
+			password = password[:bcryptMaxPasswordLength]
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+func decodeArgon2id(encoded string) (p Argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("%w: unsupported argon2 version", ErrUnknownHashFormat)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: %v", ErrUnknownHashFormat, err)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: %v", ErrUnknownHashFormat, err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: %v", ErrUnknownHashFormat, err)
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	// Защита от хэша с заведомо неподъёмными параметрами
+	if err := p.validate(); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: %v", ErrUnknownHashFormat, err)
+	}
+	return p, salt, key, nil
+}
+
+// Минимальная длина пароля по умолчанию
+const MinPasswordLength = 8
+
+// SetPasswordHasher задаёт алгоритм для новых хэшей; старые переводятся на него при входе
+func (s *Service) SetPasswordHasher(h PasswordHasher) {
+	s.hasher = h
+}
+
+// SetPasswordPolicy задаёт требования к новым паролям
+func (s *Service) SetPasswordPolicy(p *PasswordPolicy) {
+	s.passwordPolicy = p
+}
+
+// checkNewPassword — политика плюс предел алгоритма: bcrypt не принимает пароли длиннее 72 байт
+func (s *Service) checkNewPassword(password string) error {
+	if err := s.passwordPolicy.Check(password); err != nil {
+		return err
+	}
+	if max := s.hasher.MaxPasswordLength(); max > 0 && len(password) > max {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, max)
+	}
+	return nil
+}
+
+// PasswordPolicy — требования к новым паролям (регистрация и сброс)
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int                   // защита от DoS огромными паролями
+	breached  map[[20]byte]struct{} // SHA-1 утёкших паролей
+}
+
+// Самые частые пароли из публичных утечек — отклоняем даже без файла со списком
+var commonPasswords = []string{
+	"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234", "111111",
+	"1234567", "dragon", "123123", "baseball", "abc123", "football", "monkey", "letmein",
+	"696969", "shadow", "master", "666666", "qwertyuiop", "123321", "mustang", "1234567890",
+	"michael", "654321", "superman", "1qaz2wsx", "7777777", "121212", "000000", "qazwsx",
+	"123qwe", "killer", "trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh", "hunter",
+	"buster", "soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou",
+	"2000", "charlie", "robert", "thomas", "hockey", "ranger", "daniel", "starwars",
+	"klaster", "112233", "george", "computer", "michelle", "jessica", "pepper", "1111",
+	"zxcvbn", "555555", "11111111", "131313", "freedom", "777777", "pass", "maggie",
+	"159753", "aaaaaa", "ginger", "princess", "joshua", "cheese", "amanda", "summer",
+	"love", "ashley", "nicole", "chelsea", "biteme", "matthew", "access", "yankees",
+	"987654321", "dallas", "austin", "thunder", "taylor", "matrix", "password1", "password123",
+	"qwerty123", "welcome", "admin", "login", "passw0rd", "abcd1234", "yep", "yepyepyep",
+}
+
+func NewPasswordPolicy(minLength int) *PasswordPolicy {
+	p := &PasswordPolicy{
+		MinLength: minLength,
+		MaxLength: 1024,
+		breached:  make(map[[20]byte]struct{}, len(commonPasswords)),
+	}
+	for _, pw := range commonPasswords {
+		p.breached[sha1.Sum([]byte(pw))] = struct{}{}
+	}
+	return p
+}
+
+// LoadBreachedList добавляет пароли из файла: по одному в строке, открытым текстом
+// или SHA-1 в hex (формат выгрузки Have I Been Pwned, "HASH:count", тоже подходит).
+// Список целиком держится в памяти, так что рассчитан на top-N, а не на полную базу.
+func (p *PasswordPolicy) LoadBreachedList(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var sum [20]byte
+		hexPart, _, _ := strings.Cut(line, ":")
+		if len(hexPart) == 40 {
+			if _, err := hex.Decode(sum[:], []byte(hexPart)); err == nil {
+				p.breached[sum] = struct{}{}
+				n++
+				continue
+			}
+		}
+		p.breached[sha1.Sum([]byte(line))] = struct{}{}
+		n++
+	}
+	return n, sc.Err()
+}
+
+// Check возвращает ошибку, обёрнутую в ErrWeakPassword, если пароль не подходит
+func (p *PasswordPolicy) Check(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > p.MaxLength {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, p.MaxLength)
+	}
+	if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+		return fmt.Errorf("%w: it appears in a list of breached passwords, choose another one", ErrWeakPassword)
+	}
+	if _, ok := p.breached[sha1.Sum([]byte(strings.ToLower(password)))]; ok {
+		return fmt.Errorf("%w: it appears in a list of breached passwords, choose another one", ErrWeakPassword)
+	}
+	return nil
+}
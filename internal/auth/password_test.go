@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Лёгкие параметры, чтобы тесты не тратили по 19 МиБ на хэш
+var testArgon2Params = Argon2Params{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idPHCRoundTrip(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2Params)
+	encoded, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected PHC string %q", encoded)
+	}
+
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	if p != testArgon2Params || len(salt) != 16 || len(key) != 32 {
+		t.Fatalf("decoded %+v, salt %d bytes, key %d bytes", p, len(salt), len(key))
+	}
+
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"correct horse battery staple", true},
+		{"correct horse battery staplE", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		ok, err := VerifyPassword(encoded, tt.password)
+		if err != nil || ok != tt.ok {
+			t.Errorf("VerifyPassword(%q) = %v, %v; want %v", tt.password, ok, err, tt.ok)
+		}
+	}
+}
+
+func TestDecodeArgon2idRejects(t *testing.T) {
+	const salt = "c2FsdHNhbHRzYWx0c2FsdA"                     // 16 байт
+	const key = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U" // 32 байта
+
+	// Контроль: те же salt и key с нормальными параметрами разбираются
+	if _, _, _, err := decodeArgon2id("$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key); err != nil {
+		t.Fatalf("valid hash rejected: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"wrong algorithm", "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key},
+		{"wrong version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"missing part", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"garbage params", "$argon2id$v=19$m=x,t=1,p=1$" + salt + "$" + key},
+		{"memory 4 GiB", "$argon2id$v=19$m=4194304,t=1,p=1$" + salt + "$" + key},
+		{"memory above limit", "$argon2id$v=19$m=262145,t=1,p=1$" + salt + "$" + key},
+		{"zero iterations", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{"too many iterations", "$argon2id$v=19$m=64,t=17,p=1$" + salt + "$" + key},
+		{"zero parallelism", "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key},
+		{"memory below 8*p", "$argon2id$v=19$m=64,t=1,p=16$" + salt + "$" + key},
+		{"short salt", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$" + key},
+		{"short key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$a2V5"},
+		{"bad base64", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := decodeArgon2id(tt.encoded); !errors.Is(err, ErrUnknownHashFormat) {
+				t.Fatalf("err = %v, want ErrUnknownHashFormat", err)
+			}
+			if ok, err := VerifyPassword(tt.encoded, "password"); ok || err == nil {
+				t.Fatalf("VerifyPassword = %v, %v; want error", ok, err)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hashWith := func(p Argon2Params) string {
+		encoded, err := NewArgon2idHasher(p).Hash("password")
+		if err != nil {
+			t.Fatalf("Hash: %v", err)
+		}
+		return encoded
+	}
+	with := func(change func(p *Argon2Params)) Argon2Params {
+		p := testArgon2Params
+		change(&p)
+		return p
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	current := NewArgon2idHasher(testArgon2Params)
+	tests := []struct {
+		name    string
+		encoded string
+		want    bool
+	}{
+		{"same params", hashWith(testArgon2Params), false},
+		{"stronger params", hashWith(with(func(p *Argon2Params) { p.Memory, p.Iterations = 128, 2 })), false},
+		{"less memory", hashWith(with(func(p *Argon2Params) { p.Memory = 32 })), true},
+		{"more memory only", hashWith(with(func(p *Argon2Params) { p.Memory = 128 })), false},
+		{"shorter salt", hashWith(with(func(p *Argon2Params) { p.SaltLength = 8 })), true},
+		{"shorter key", hashWith(with(func(p *Argon2Params) { p.KeyLength = 16 })), true},
+		{"bcrypt", string(bcryptHash), true},
+		{"garbage", "not a hash", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := current.NeedsRehash(tt.encoded); got != tt.want {
+				t.Fatalf("NeedsRehash(%q) = %v, want %v", tt.encoded, got, tt.want)
+			}
+		})
+	}
+
+	stronger := NewArgon2idHasher(with(func(p *Argon2Params) { p.Iterations = 2 }))
+	if !stronger.NeedsRehash(hashWith(testArgon2Params)) {
+		t.Error("hash with fewer iterations than configured must be rehashed")
+	}
+}
+
+func TestBcryptLongPassword(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	// Так хэш строился до того, как bcrypt начал отвергать длинные пароли
+	legacy, err := bcrypt.GenerateFromPassword([]byte(long[:72]), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	if ok, err := VerifyPassword(string(legacy), long); !ok || err != nil {
+		t.Fatalf("VerifyPassword(legacy, 100 bytes) = %v, %v; want true", ok, err)
+	}
+	if ok, _ := VerifyPassword(string(legacy), long[:71]); ok {
+		t.Fatal("71-byte prefix must not match")
+	}
+
+	s := &Service{passwordPolicy: NewPasswordPolicy(MinPasswordLength)}
+	tests := []struct {
+		name    string
+		hasher  PasswordHasher
+		length  int
+		wantErr bool
+	}{
+		{"bcrypt 72 bytes", NewBcryptHasher(bcrypt.MinCost), 72, false},
+		{"bcrypt 73 bytes", NewBcryptHasher(bcrypt.MinCost), 73, true},
+		{"argon2id 73 bytes", NewArgon2idHasher(testArgon2Params), 73, false},
+		{"argon2id above policy", NewArgon2idHasher(testArgon2Params), 1025, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.hasher = tt.hasher
+			err := s.checkNewPassword(strings.Repeat("x1", tt.length)[:tt.length])
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrWeakPassword)) {
+				t.Fatalf("checkNewPassword(%d bytes) = %v, wantErr %v", tt.length, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewPasswordHasherValidatesParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		hasher  string
+		params  Argon2Params
+		wantErr bool
+	}{
+		{"default", "argon2id", DefaultArgon2Params, false},
+		{"empty name", "", DefaultArgon2Params, false},
+		{"bcrypt ignores params", "bcrypt", Argon2Params{}, false},
+		{"memory above limit", "argon2id", Argon2Params{Memory: maxArgon2Memory + 1, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"short salt", "argon2id", Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 4, KeyLength: 32}, true},
+		{"unknown", "scrypt", DefaultArgon2Params, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPasswordHasher(tt.hasher, tt.params); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// Повторное использование уже обменянного токена: вся цепочка отозвана
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// TokenPair — access + refresh токены, выдаются вместе
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in"` // секунд до истечения access token
+	SessionID    string `json:"session_id"`
+}
+
+// SessionMeta — откуда пришёл вход, показывается в списке сессий
+type SessionMeta struct {
+	Device string
+	IP     string
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueTokens открывает новую сессию: выдаёт пару токенов и начинает цепочку ротаций.
+// id сессии совпадает с id семейства refresh-токенов.
+func (s *Service) IssueTokens(user *core.User, meta SessionMeta) (*TokenPair, error) {
+	sessionID := newTokenID()
+
+	access, jti, err := GenerateToken(user.YUI, user.Email, user.Level, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.CreateSession(&storage.Session{
+		JTI:       jti,
+		SessionID: sessionID,
+		YUI:       user.YUI,
+		Device:    meta.Device,
+		IP:        meta.IP,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return s.issueRefresh(user, sessionID, access)
+}
+
+func (s *Service) issueRefresh(user *core.User, familyID, access string) (*TokenPair, error) {
+	refresh, err := GenerateRefreshToken(user.YUI)
+	if err != nil {
+		return nil, err
+	}
+
+	// В БД храним только хэш refresh token
+	if err := s.db.SaveRefreshToken(hashToken(refresh), familyID, user.YUI, time.Now().Add(RefreshTokenTTL)); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+		SessionID:    familyID,
+	}, nil
+}
+
+// RefreshTokens обменивает refresh token на новую пару.
+// Каждый refresh token одноразовый; повторное предъявление отзывает всю цепочку.
+func (s *Service) RefreshTokens(refreshToken string, meta SessionMeta) (*TokenPair, *core.User, error) {
+	yui, err := ValidateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	hash := hashToken(refreshToken)
+	stored, err := s.db.GetRefreshToken(hash)
+	if err != nil || stored.YUI != yui {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	if err := s.db.MarkRefreshTokenUsed(hash); err != nil {
+		if errors.Is(err, storage.ErrTokenReused) {
+			// Токен уже обменян или отозван — возможно, его украли
+			log.Printf("[AUTH] refresh token reuse for %s, revoking family %s", yui, stored.FamilyID)
+			if _, err := s.RevokeSession(yui, stored.FamilyID); err != nil {
+				log.Printf("Failed to revoke token family %s: %v", stored.FamilyID, err)
+			}
+			// id сессии совпадает с семейством: закрываем все соединения, открытые её токенами
+			if s.closer != nil {
+				s.closer.CloseAuthSession(yui, stored.FamilyID)
+			}
+			s.recordLogin(&storage.LoginAttempt{
+				YUI:    yui,
+				IP:     meta.IP,
+				Device: meta.Device,
+				Method: LoginRefresh,
+				Reason: "refresh_reused",
+			})
+			return nil, nil, ErrRefreshTokenReused
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.db.GetUserByYUI(yui)
+	if err != nil || !user.IsActive {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	// Та же сессия, новый access token: старый jti больше не валиден
+	access, jti, err := GenerateToken(user.YUI, user.Email, user.Level, stored.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.db.RotateSessionJTI(stored.FamilyID, jti, meta.IP, time.Now().Add(RefreshTokenTTL)); err != nil {
+		return nil, nil, fmt.Errorf("failed to rotate session: %w", err)
+	}
+
+	pair, err := s.issueRefresh(user, stored.FamilyID, access)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, user, nil
+}
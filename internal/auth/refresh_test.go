@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"yep-protocol/internal/core"
+)
+
+func testUser() *core.User {
+	return &core.User{YUI: "YUI-1", Email: "a@example.com", Level: "U", IsActive: true}
+}
+
+func TestRefreshTokensRotate(t *testing.T) {
+	db := newFakeStore(testUser())
+	s := newTestService(t, db)
+
+	first, err := s.IssueTokens(testUser(), SessionMeta{Device: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, user, err := s.RefreshTokens(first.RefreshToken, SessionMeta{})
+	if err != nil {
+		t.Fatalf("RefreshTokens = %v", err)
+	}
+	if user.YUI != "YUI-1" || second.SessionID != first.SessionID {
+		t.Fatalf("refresh: yui = %s, session = %s; want YUI-1, %s", user.YUI, second.SessionID, first.SessionID)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("refresh returned the same tokens")
+	}
+
+	// Старый access token перестаёт открывать сессию, новый открывает
+	if s.IsSessionActive(tokenID(t, first.AccessToken)) {
+		t.Fatal("access token from before the rotation is still active")
+	}
+	if !s.IsSessionActive(tokenID(t, second.AccessToken)) {
+		t.Fatal("rotated access token is not active")
+	}
+
+	// Следующий токен цепочки обменивается как обычно
+	if _, _, err := s.RefreshTokens(second.RefreshToken, SessionMeta{}); err != nil {
+		t.Fatalf("second rotation = %v", err)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	db := newFakeStore(testUser())
+	s := newTestService(t, db)
+	closer := &fakeCloser{}
+	s.SetSessionCloser(closer)
+
+	first, err := s.IssueTokens(testUser(), SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.RefreshTokens(first.RefreshToken, SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Украденный токен предъявлен повторно: отзываем всю цепочку
+	if _, _, err := s.RefreshTokens(first.RefreshToken, SessionMeta{IP: "203.0.113.7"}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse: err = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if want := []string{"YUI-1/" + first.SessionID}; !reflect.DeepEqual(closer.closed, want) {
+		t.Fatalf("closed = %v, want %v", closer.closed, want)
+	}
+	if reasons := db.loginReasons(); !reflect.DeepEqual(reasons, []string{"refresh_reused"}) {
+		t.Fatalf("login history = %v, want [refresh_reused]", reasons)
+	}
+
+	// Законный владелец тоже теряет сессию: новый токен цепочки и его access token не действуют
+	if _, _, err := s.RefreshTokens(second.RefreshToken, SessionMeta{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("refresh after revocation: err = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if s.IsSessionActive(tokenID(t, second.AccessToken)) {
+		t.Fatal("access token of a revoked family is still active")
+	}
+}
+
+func TestRefreshTokensInvalid(t *testing.T) {
+	inactive := &core.User{YUI: "YUI-2", Email: "b@example.com", Level: "U"}
+	db := newFakeStore(testUser(), inactive)
+	s := newTestService(t, db)
+
+	pair, err := s.IssueTokens(testUser(), SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := GenerateRefreshToken("YUI-1") // подписан, но не выдавался
+	if err != nil {
+		t.Fatal(err)
+	}
+	inactivePair, err := s.IssueTokens(inactive, SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"garbage", "not-a-token"},
+		{"access token", pair.AccessToken},
+		{"never issued", unknown},
+		{"inactive user", inactivePair.RefreshToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.RefreshTokens(tt.token, SessionMeta{}); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Fatalf("err = %v, want %v", err, ErrInvalidRefreshToken)
+			}
+		})
+	}
+}
+
+// tokenID — jti access token
+func tokenID(t *testing.T, token string) string {
+	t.Helper()
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims.ID
+}
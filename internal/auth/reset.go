@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/notify"
+	"yep-protocol/internal/storage"
+)
+
+const (
+	PasswordResetTTL = 30 * time.Minute
+
+	// Не больше стольких писем/сообщений со сбросом на аккаунт в час
+	maxResetsPerHour = 3
+)
+
+var (
+	ErrResetTokenInvalid = errors.New("invalid or expired reset token")
+	ErrWeakPassword      = errors.New("password is too weak")
+)
+
+// SetNotifier задаёт канал доставки служебных сообщений (сброс пароля и т.п.)
+func (s *Service) SetNotifier(n notify.Notifier) {
+	s.notifier = n
+}
+
+// SetPasswordResetURL — ссылка в сообщении о сбросе, к ней дописывается токен
+func (s *Service) SetPasswordResetURL(url string) {
+	s.resetURL = url
+}
+
+// RequestPasswordReset отправляет токен сброса владельцу email или номера.
+// Ответ одинаковый и мгновенный независимо от того, есть ли такой аккаунт:
+// поиск и доставка идут в фоне.
+func (s *Service) RequestPasswordReset(email, phoneHash string) {
+	go func() {
+		var user *core.User
+		var err error
+		if email != "" {
+			user, err = s.db.GetUserByEmail(email)
+		} else {
+			user, err = s.db.GetUserByPhoneHash(phoneHash)
+		}
+		if err != nil || !user.IsActive {
+			return
+		}
+
+		if err := s.sendPasswordReset(user); err != nil {
+			log.Printf("Password reset for %s failed: %v", user.YUI, err)
+		}
+	}()
+}
+
+func (s *Service) sendPasswordReset(user *core.User) error {
+	if s.notifier == nil {
+		return fmt.Errorf("no notifier configured")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	err := s.db.CreatePasswordReset(hashToken(token), user.YUI, now.Add(PasswordResetTTL), now.Add(-time.Hour), maxResetsPerHour)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Someone requested a password reset for your YEP account.\n\n"+
+		"Reset token: %s\n", token)
+	if s.resetURL != "" {
+		text += fmt.Sprintf("Or open: %s%s\n", s.resetURL, token)
+	}
+	text += fmt.Sprintf("\nThe token is valid for %d minutes and can be used once. "+
+		"If it wasn't you, ignore this message.", int(PasswordResetTTL.Minutes()))
+
+	return s.notifier.Notify(user, "YEP password reset", text)
+}
+
+// ConfirmPasswordReset гасит токен, ставит новый пароль и отзывает все сессии.
+// Возвращает YUI, чтобы вызывающий код закрыл живые соединения.
+func (s *Service) ConfirmPasswordReset(token, newPassword string) (string, error) {
+	if err := s.checkNewPassword(newPassword); err != nil {
+		return "", err
+	}
+
+	hash, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return "", err
+	}
+
+	// Токен гасится и пароль меняется одной транзакцией: не бывает погашенного токена со старым паролем
+	yui, err := s.db.ResetPassword(hashToken(token), hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrResetTokenInvalid) {
+			return "", ErrResetTokenInvalid
+		}
+		return "", err
+	}
+
+	if _, err := s.RevokeAllSessions(yui, ""); err != nil {
+		return yui, fmt.Errorf("password changed, but failed to revoke sessions: %w", err)
+	}
+
+	log.Printf("🔑 Password reset for %s, all sessions revoked", yui)
+	return yui, nil
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+var resetTokenRe = regexp.MustCompile(`Reset token: (\S+)`)
+
+// resetUser — активный пользователь с паролем "old-password"
+func resetUser(t *testing.T, s *Service) *core.User {
+	t.Helper()
+	hash, err := s.hasher.Hash("old-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := testUser()
+	u.PhoneHash = "phone-hash-1"
+	u.PasswordHash = hash
+	return u
+}
+
+// requestReset запрашивает сброс и достаёт токен из отправленного сообщения
+func requestReset(t *testing.T, s *Service, n *fakeNotifier, email string) string {
+	t.Helper()
+	s.RequestPasswordReset(email, "")
+	m := n.next(t)
+	match := resetTokenRe.FindStringSubmatch(m.text)
+	if match == nil {
+		t.Fatalf("no reset token in %q", m.text)
+	}
+	return match[1]
+}
+
+func newResetService(t *testing.T) (*Service, *fakeStore, *fakeNotifier) {
+	t.Helper()
+	db := newFakeStore()
+	s := newTestService(t, db)
+	u := resetUser(t, s)
+	db.users[u.YUI] = u
+
+	n := newFakeNotifier()
+	s.SetNotifier(n)
+	return s, db, n
+}
+
+func TestPasswordReset(t *testing.T) {
+	s, db, n := newResetService(t)
+
+	pair, err := s.IssueTokens(testUser(), SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := requestReset(t, s, n, "a@example.com")
+
+	yui, err := s.ConfirmPasswordReset(token, "new-password")
+	if err != nil || yui != "YUI-1" {
+		t.Fatalf("ConfirmPasswordReset = %q, %v; want YUI-1, nil", yui, err)
+	}
+	if ok, _ := VerifyPassword(db.user("YUI-1").PasswordHash, "new-password"); !ok {
+		t.Fatal("new password does not verify")
+	}
+
+	// Сброс выходит из всех сессий
+	if s.IsSessionActive(tokenID(t, pair.AccessToken)) {
+		t.Fatal("session survived a password reset")
+	}
+	if _, _, err := s.RefreshTokens(pair.RefreshToken, SessionMeta{}); err == nil {
+		t.Fatal("refresh token survived a password reset")
+	}
+
+	// Токен одноразовый
+	if _, err := s.ConfirmPasswordReset(token, "another-password"); !errors.Is(err, ErrResetTokenInvalid) {
+		t.Fatalf("second use: err = %v, want %v", err, ErrResetTokenInvalid)
+	}
+}
+
+func TestPasswordResetByPhone(t *testing.T) {
+	s, _, n := newResetService(t)
+
+	s.RequestPasswordReset("", "phone-hash-1")
+	if m := n.next(t); m.yui != "YUI-1" {
+		t.Fatalf("reset sent to %s, want YUI-1", m.yui)
+	}
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	s, db, n := newResetService(t)
+	token := requestReset(t, s, n, "a@example.com")
+
+	r := db.resets[hashToken(token)]
+	if ttl := time.Until(r.expiresAt); ttl <= PasswordResetTTL-time.Minute || ttl > PasswordResetTTL {
+		t.Fatalf("token lives %s, want %s", ttl, PasswordResetTTL)
+	}
+	r.expiresAt = time.Now().Add(-time.Second)
+
+	if _, err := s.ConfirmPasswordReset(token, "new-password"); !errors.Is(err, ErrResetTokenInvalid) {
+		t.Fatalf("err = %v, want %v", err, ErrResetTokenInvalid)
+	}
+}
+
+func TestPasswordResetNewTokenInvalidatesOlder(t *testing.T) {
+	s, _, n := newResetService(t)
+	first := requestReset(t, s, n, "a@example.com")
+	second := requestReset(t, s, n, "a@example.com")
+
+	if _, err := s.ConfirmPasswordReset(second, "new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ConfirmPasswordReset(first, "other-password"); !errors.Is(err, ErrResetTokenInvalid) {
+		t.Fatalf("older token: err = %v, want %v", err, ErrResetTokenInvalid)
+	}
+}
+
+func TestPasswordResetWeakPasswordKeepsToken(t *testing.T) {
+	s, _, n := newResetService(t)
+	token := requestReset(t, s, n, "a@example.com")
+
+	if _, err := s.ConfirmPasswordReset(token, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("err = %v, want %v", err, ErrWeakPassword)
+	}
+	// Отказ по паролю токен не гасит
+	if _, err := s.ConfirmPasswordReset(token, "new-password"); err != nil {
+		t.Fatalf("retry with a strong password = %v", err)
+	}
+}
+
+func TestPasswordResetUnknownToken(t *testing.T) {
+	s, _, _ := newResetService(t)
+	if _, err := s.ConfirmPasswordReset("no-such-token", "new-password"); !errors.Is(err, ErrResetTokenInvalid) {
+		t.Fatalf("err = %v, want %v", err, ErrResetTokenInvalid)
+	}
+}
+
+func TestPasswordResetRateLimit(t *testing.T) {
+	s, _, n := newResetService(t)
+	for i := 0; i < maxResetsPerHour; i++ {
+		requestReset(t, s, n, "a@example.com")
+	}
+	s.RequestPasswordReset("a@example.com", "")
+	n.none(t, 100*time.Millisecond)
+}
+
+// blockingStore задерживает поиск пользователя, пока не закрыт gate
+type blockingStore struct {
+	*fakeStore
+	gate chan struct{}
+}
+
+func (db *blockingStore) GetUserByEmail(email string) (*core.User, error) {
+	<-db.gate
+	return db.fakeStore.GetUserByEmail(email)
+}
+
+func TestRequestPasswordResetDoesNotEnumerate(t *testing.T) {
+	s, db, n := newResetService(t)
+	inactive := &core.User{YUI: "YUI-2", Email: "gone@example.com"}
+	db.users[inactive.YUI] = inactive
+
+	// Ответ не ждёт ни поиска, ни доставки
+	blocking := &blockingStore{fakeStore: db, gate: make(chan struct{})}
+	s.db = blocking
+	done := make(chan struct{})
+	go func() {
+		s.RequestPasswordReset("a@example.com", "")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RequestPasswordReset waited for the user lookup")
+	}
+	close(blocking.gate)
+	n.next(t)
+
+	// Несуществующему и отключённому аккаунту ничего не уходит
+	for _, email := range []string{"nobody@example.com", "gone@example.com"} {
+		s.RequestPasswordReset(email, "")
+	}
+	n.none(t, 100*time.Millisecond)
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+	"yep-protocol/internal/storage"
+)
+
+const (
+	// Сколько верим последней проверке отзыва: отозванный на другом узле токен
+	// продолжает работать не дольше этого
+	sessionCheckTTL = 5 * time.Second
+	// last_seen одной сессии пишем не чаще этого
+	sessionTouchInterval = time.Minute
+)
+
+type sessionState struct {
+	active    bool
+	checkedAt time.Time
+	touchedAt time.Time
+}
+
+// sessionCache помнит недавние проверки сессий по jti, чтобы не ходить в БД на каждый запрос
+type sessionCache struct {
+	mu        sync.Mutex
+	entries   map[string]*sessionState
+	lastPurge time.Time
+	resets    uint64 // растёт при reset: ответ БД, полученный до отзыва, в кэш не попадает
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{
+		entries:   make(map[string]*sessionState),
+		lastPurge: time.Now(),
+	}
+}
+
+// lookup возвращает закэшированный результат, если он ещё свежий.
+// version передаётся в store после проверки в БД.
+func (c *sessionCache) lookup(jti string, now time.Time) (active, ok bool, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[jti]
+	if !ok || now.Sub(e.checkedAt) >= sessionCheckTTL {
+		return false, false, c.resets
+	}
+	return e.active, true, c.resets
+}
+
+func (c *sessionCache) store(jti string, active bool, now time.Time, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version != c.resets {
+		// Пока шла проверка, сессии отзывали — результат мог устареть
+		return
+	}
+	e, ok := c.entries[jti]
+	if !ok {
+		e = &sessionState{}
+		c.entries[jti] = e
+	}
+	e.active = active
+	e.checkedAt = now
+
+	// Периодически забываем сессии, к которым давно не обращались
+	if now.Sub(c.lastPurge) > sessionTouchInterval {
+		for k, e := range c.entries {
+			if now.Sub(e.checkedAt) > sessionTouchInterval {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPurge = now
+	}
+}
+
+// needsTouch сообщает, пора ли обновить last_seen, и сразу отмечает обновление
+func (c *sessionCache) needsTouch(jti string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[jti]
+	if !ok || now.Sub(e.touchedAt) < sessionTouchInterval {
+		return false
+	}
+	e.touchedAt = now
+	return true
+}
+
+// reset забывает все проверки: после отзыва на этом узле он действует сразу
+func (c *sessionCache) reset() {
+	c.mu.Lock()
+	c.entries = make(map[string]*sessionState)
+	c.resets++
+	c.mu.Unlock()
+}
+
+// SessionCloser закрывает живые WebSocket-соединения отозванных сессий
+type SessionCloser interface {
+	CloseAuthSession(yui, sessionID string)
+	CloseUserSessions(yui, exceptSessionID string)
+}
+
+// SetSessionCloser задаёт, кто закрывает соединения сессий, отозванных самим сервисом
+// (например, при повторном использовании refresh token)
+func (s *Service) SetSessionCloser(c SessionCloser) {
+	s.closer = c
+}
+
+// IsSessionActive реализует SessionChecker: сессия не отозвана и не истекла.
+// Результат кэшируется на sessionCheckTTL, last_seen обновляется не чаще sessionTouchInterval.
+func (s *Service) IsSessionActive(jti string) bool {
+	now := time.Now()
+	active, ok, version := s.sessions.lookup(jti, now)
+	if !ok {
+		var err error
+		active, err = s.db.IsSessionActive(jti)
+		if err != nil {
+			log.Printf("Failed to check session %s: %v", jti, err)
+			return false
+		}
+		s.sessions.store(jti, active, now, version)
+	}
+
+	if active && s.sessions.needsTouch(jti, now) {
+		if err := s.db.TouchSession(jti, now); err != nil {
+			log.Printf("Failed to update last_seen of session %s: %v", jti, err)
+		}
+	}
+	return active
+}
+
+func (s *Service) ListSessions(yui string) ([]*storage.Session, error) {
+	return s.db.ListSessions(yui)
+}
+
+// RevokeSession отзывает сессию и все её refresh-токены
+func (s *Service) RevokeSession(yui, sessionID string) (bool, error) {
+	revoked, err := s.db.RevokeSession(yui, sessionID)
+	if err != nil {
+		return false, err
+	}
+	s.sessions.reset()
+	if err := s.db.RevokeTokenFamily(sessionID); err != nil {
+		return revoked, err
+	}
+	return revoked, nil
+}
+
+// RevokeAllSessions отзывает все сессии пользователя, кроме exceptSessionID (если задан)
+func (s *Service) RevokeAllSessions(yui, exceptSessionID string) ([]string, error) {
+	revoked, err := s.db.RevokeAllSessions(yui, exceptSessionID)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions.reset()
+	if exceptSessionID == "" {
+		// Все, включая refresh-токены без записи о сессии
+		return revoked, s.db.RevokeUserRefreshTokens(yui)
+	}
+	for _, id := range revoked {
+		if err := s.db.RevokeTokenFamily(id); err != nil {
+			return revoked, err
+		}
+	}
+	return revoked, nil
+}
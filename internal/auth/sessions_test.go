@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	c := newSessionCache()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, ok, _ := c.lookup("a", start); ok {
+		t.Fatal("empty cache returned a result")
+	}
+	_, _, version := c.lookup("a", start)
+	c.store("a", true, start, version)
+
+	tests := []struct {
+		name   string
+		at     time.Duration // от start
+		ok     bool
+		active bool
+	}{
+		{"just checked", 0, true, true},
+		{"still fresh", sessionCheckTTL - time.Millisecond, true, true},
+		{"stale", sessionCheckTTL, false, false},
+	}
+	for _, tt := range tests {
+		active, ok, _ := c.lookup("a", start.Add(tt.at))
+		if ok != tt.ok || active != tt.active {
+			t.Fatalf("%s: lookup = %v, %v; want %v, %v", tt.name, active, ok, tt.active, tt.ok)
+		}
+	}
+}
+
+func TestSessionCacheTouchThrottle(t *testing.T) {
+	c := newSessionCache()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if c.needsTouch("a", start) {
+		t.Fatal("unknown session must not be touched")
+	}
+	c.store("a", true, start, 0)
+
+	steps := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, true}, // первый запрос сессии обновляет last_seen
+		{time.Second, false},
+		{sessionTouchInterval - time.Second, false},
+		{sessionTouchInterval, true},
+		{sessionTouchInterval + time.Second, false},
+	}
+	for _, s := range steps {
+		now := start.Add(s.at)
+		c.store("a", true, now, 0)
+		if got := c.needsTouch("a", now); got != s.want {
+			t.Fatalf("needsTouch at +%s = %v, want %v", s.at, got, s.want)
+		}
+	}
+}
+
+func TestSessionCacheReset(t *testing.T) {
+	c := newSessionCache()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	_, _, version := c.lookup("a", now)
+	c.store("a", true, now, version)
+	c.reset()
+	if _, ok, _ := c.lookup("a", now); ok {
+		t.Fatal("revocation on this node must drop cached checks")
+	}
+
+	// Проверка началась до отзыва и закончилась после: её результат не кэшируем
+	_, _, version = c.lookup("b", now)
+	c.reset()
+	c.store("b", true, now, version)
+	if _, ok, _ := c.lookup("b", now); ok {
+		t.Fatal("result read before a revocation was cached")
+	}
+}
+
+func TestSessionCachePurge(t *testing.T) {
+	c := newSessionCache()
+	start := c.lastPurge
+
+	c.store("old", true, start, 0)
+	c.store("new", true, start.Add(sessionTouchInterval+time.Second), 0)
+
+	c.mu.Lock()
+	_, oldKept := c.entries["old"]
+	_, newKept := c.entries["new"]
+	c.mu.Unlock()
+	if oldKept || !newKept {
+		t.Fatalf("after purge old kept = %v, new kept = %v", oldKept, newKept)
+	}
+}
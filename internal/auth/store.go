@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+// store — то, что Service берёт из Postgres (*storage.DB); в тестах подменяется
+type store interface {
+	// Пользователи
+	CreateUser(user *core.User) error
+	GetUserByEmail(email string) (*core.User, error)
+	GetUserByPhoneHash(phoneHash string) (*core.User, error)
+	GetUserByTelegramID(telegramID int64) (*core.User, error)
+	GetUserByYUI(yui string) (*core.User, error)
+	ActivateUserByPhoneHash(phoneHash string) error
+	LinkTelegramID(phoneHash string, telegramID int64) error
+	UpdateLastLogin(yui string) error
+	UpdatePasswordHash(yui, passwordHash string) error
+
+	// Коды входа и блокировки
+	SaveOTP(phoneHash, codeHash string, ttl time.Duration) error
+	ConsumeOTP(phoneHash, codeHash string, maxAttempts int) error
+	DeleteExpiredOTP() (int64, error)
+	LockedUntil(key string) (time.Time, error)
+	RecordFailure(key string, policy storage.Lockout) (time.Time, error)
+	ResetFailures(key string) error
+	DeleteStaleLockouts(ttl time.Duration) error
+
+	// Сессии и refresh-токены
+	CreateSession(s *storage.Session) error
+	IsSessionActive(jti string) (bool, error)
+	TouchSession(jti string, at time.Time) error
+	RotateSessionJTI(sessionID, newJTI, ip string, expiresAt time.Time) error
+	ListSessions(yui string) ([]*storage.Session, error)
+	RevokeSession(yui, sessionID string) (bool, error)
+	RevokeAllSessions(yui, exceptSessionID string) ([]string, error)
+	SaveRefreshToken(tokenHash, familyID, yui string, expiresAt time.Time) error
+	GetRefreshToken(tokenHash string) (*storage.RefreshToken, error)
+	MarkRefreshTokenUsed(tokenHash string) error
+	RevokeTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(yui string) error
+
+	// История входов
+	RecordLogin(a *storage.LoginAttempt) error
+	RecentLoginFailures(email string, since time.Time) (int, error)
+	ListLogins(yui string, limit int) ([]*storage.LoginAttempt, error)
+	DeleteOldLogins(olderThan time.Time) error
+
+	// Сброс пароля
+	CreatePasswordReset(tokenHash, yui string, expiresAt, since time.Time, limit int) error
+	ResetPassword(tokenHash, passwordHash string) (string, error)
+	DeleteExpiredPasswordResets(olderThan time.Time) error
+
+	// Подтверждение email
+	CreateEmailVerification(tokenHash, codeHash, yui, email string, expiresAt time.Time) error
+	CountEmailVerifications(yui string, since time.Time) (int, error)
+	HasPendingEmailVerification(yui, email string) (bool, error)
+	ConsumeEmailToken(tokenHash string) (string, error)
+	ConsumeEmailCode(yui, codeHash string) error
+	ReleaseUnverifiedEmail(email, placeholderDomain string, createdBefore time.Time) (bool, error)
+	DeleteExpiredEmailVerifications(olderThan time.Time) error
+
+	// Второй фактор
+	GetTOTP(yui string) (*storage.TOTP, error)
+	SaveTOTPSecret(yui, secret string) (bool, error)
+	ConfirmTOTP(yui string, recoveryHashes []string) error
+	DeleteTOTP(yui string) error
+	UseTOTPStep(yui string, step int64) (bool, error)
+	UseRecoveryCode(yui, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(yui string, hashes []string) error
+	CountRecoveryCodes(yui string) (int, error)
+}
@@ -0,0 +1,441 @@
+package auth
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeStore — хранилище в памяти с той же семантикой, что у запросов *storage.DB.
+// Методы, которые тесту не нужны, достаются nil-интерфейсу и паникуют.
+type fakeStore struct {
+	store
+
+	mu       sync.Mutex
+	users    map[string]*core.User            // yui -> пользователь
+	sessions map[string]*storage.Session      // session_id -> сессия
+	revoked  map[string]bool                  // session_id отозванных сессий
+	refresh  map[string]*storage.RefreshToken // token_hash -> токен
+	logins   []*storage.LoginAttempt
+	resets   map[string]*fakeReset // token_hash -> токен сброса
+	emails   []*fakeEmailVerification
+	failures map[string]int       // ключ блокировки -> неудачи подряд
+	locks    map[string]time.Time // ключ блокировки -> конец блокировки
+}
+
+type fakeEmailVerification struct {
+	tokenHash, codeHash string
+	yui, email          string
+	createdAt           time.Time
+	expiresAt           time.Time
+	used                bool
+}
+
+type fakeReset struct {
+	yui       string
+	createdAt time.Time
+	expiresAt time.Time
+	used      bool
+}
+
+func newFakeStore(users ...*core.User) *fakeStore {
+	db := &fakeStore{
+		users:    make(map[string]*core.User),
+		sessions: make(map[string]*storage.Session),
+		revoked:  make(map[string]bool),
+		refresh:  make(map[string]*storage.RefreshToken),
+		resets:   make(map[string]*fakeReset),
+		failures: make(map[string]int),
+		locks:    make(map[string]time.Time),
+	}
+	for _, u := range users {
+		db.users[u.YUI] = u
+	}
+	return db
+}
+
+// newTestService — сервис поверх fakeStore с HS256-ключом и быстрым хэшированием паролей
+func newTestService(t *testing.T, db *fakeStore) *Service {
+	t.Helper()
+	ks, err := LoadKeySet(KeyConfig{Secret: "test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+
+	s := NewService(nil, nil)
+	s.db = db
+	s.hasher = NewBcryptHasher(bcrypt.MinCost)
+	return s
+}
+
+func (db *fakeStore) GetUserByYUI(yui string) (*core.User, error) {
+	return db.findUser(func(u *core.User) bool { return u.YUI == yui })
+}
+
+func (db *fakeStore) GetUserByEmail(email string) (*core.User, error) {
+	return db.findUser(func(u *core.User) bool { return u.Email == email && u.IsActive })
+}
+
+func (db *fakeStore) GetUserByPhoneHash(phoneHash string) (*core.User, error) {
+	return db.findUser(func(u *core.User) bool { return u.PhoneHash == phoneHash })
+}
+
+// findUser возвращает копию, как и чтение из БД
+func (db *fakeStore) findUser(match func(*core.User) bool) (*core.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, u := range db.users {
+		if match(u) {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// user — текущее состояние пользователя в хранилище
+func (db *fakeStore) user(yui string) core.User {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return *db.users[yui]
+}
+
+func (db *fakeStore) UpdateLastLogin(yui string) error { return nil }
+
+func (db *fakeStore) CreateSession(s *storage.Session) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	copied := *s
+	db.sessions[s.SessionID] = &copied
+	return nil
+}
+
+func (db *fakeStore) IsSessionActive(jti string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for id, s := range db.sessions {
+		if s.JTI == jti {
+			return !db.revoked[id] && s.ExpiresAt.After(time.Now()), nil
+		}
+	}
+	return false, nil
+}
+
+func (db *fakeStore) TouchSession(jti string, at time.Time) error { return nil }
+
+func (db *fakeStore) RotateSessionJTI(sessionID, newJTI, ip string, expiresAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if s, ok := db.sessions[sessionID]; ok && !db.revoked[sessionID] {
+		s.JTI, s.ExpiresAt = newJTI, expiresAt
+	}
+	return nil
+}
+
+func (db *fakeStore) RevokeSession(yui, sessionID string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s, ok := db.sessions[sessionID]
+	if !ok || s.YUI != yui || db.revoked[sessionID] {
+		return false, nil
+	}
+	db.revoked[sessionID] = true
+	return true, nil
+}
+
+func (db *fakeStore) RevokeAllSessions(yui, exceptSessionID string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var ids []string
+	for id, s := range db.sessions {
+		if s.YUI == yui && id != exceptSessionID && !db.revoked[id] {
+			db.revoked[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (db *fakeStore) SaveRefreshToken(tokenHash, familyID, yui string, expiresAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.refresh[tokenHash] = &storage.RefreshToken{TokenHash: tokenHash, FamilyID: familyID, YUI: yui, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (db *fakeStore) GetRefreshToken(tokenHash string) (*storage.RefreshToken, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, ok := db.refresh[tokenHash]
+	if !ok {
+		return nil, storage.ErrTokenNotFound
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (db *fakeStore) MarkRefreshTokenUsed(tokenHash string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, ok := db.refresh[tokenHash]
+	if !ok || t.UsedAt.Valid || t.RevokedAt.Valid {
+		return storage.ErrTokenReused
+	}
+	t.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (db *fakeStore) RevokeTokenFamily(familyID string) error {
+	db.revokeRefresh(func(t *storage.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (db *fakeStore) RevokeUserRefreshTokens(yui string) error {
+	db.revokeRefresh(func(t *storage.RefreshToken) bool { return t.YUI == yui })
+	return nil
+}
+
+func (db *fakeStore) revokeRefresh(match func(*storage.RefreshToken) bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, t := range db.refresh {
+		if match(t) && !t.RevokedAt.Valid {
+			t.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+}
+
+func (db *fakeStore) RecordLogin(a *storage.LoginAttempt) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	copied := *a
+	db.logins = append(db.logins, &copied)
+	return nil
+}
+
+func (db *fakeStore) RecentLoginFailures(email string, since time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for _, a := range db.logins {
+		if a.Email == email && !a.Success {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// loginReasons — причины неудачных входов по порядку
+func (db *fakeStore) loginReasons() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var reasons []string
+	for _, a := range db.logins {
+		if !a.Success {
+			reasons = append(reasons, a.Reason)
+		}
+	}
+	return reasons
+}
+
+func (db *fakeStore) CreatePasswordReset(tokenHash, yui string, expiresAt, since time.Time, limit int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for _, r := range db.resets {
+		if r.yui == yui && r.createdAt.After(since) {
+			n++
+		}
+	}
+	if n >= limit {
+		return storage.ErrResetRateLimited
+	}
+	db.resets[tokenHash] = &fakeReset{yui: yui, createdAt: time.Now(), expiresAt: expiresAt}
+	return nil
+}
+
+func (db *fakeStore) ResetPassword(tokenHash, passwordHash string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r, ok := db.resets[tokenHash]
+	if !ok || r.used || !r.expiresAt.After(time.Now()) {
+		return "", storage.ErrResetTokenInvalid
+	}
+	for _, other := range db.resets {
+		if other.yui == r.yui {
+			other.used = true
+		}
+	}
+	db.users[r.yui].PasswordHash = passwordHash
+	return r.yui, nil
+}
+
+// Блокировки упрощены: без окна и удвоения, их считает storage.Lockout
+func (db *fakeStore) LockedUntil(key string) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if until := db.locks[key]; until.After(time.Now()) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+func (db *fakeStore) RecordFailure(key string, policy storage.Lockout) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.failures[key]++
+	if db.failures[key] < policy.MaxFailures {
+		return time.Time{}, nil
+	}
+	db.failures[key] = 0
+	db.locks[key] = time.Now().Add(policy.Base)
+	return db.locks[key], nil
+}
+
+func (db *fakeStore) ResetFailures(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.failures, key)
+	delete(db.locks, key)
+	return nil
+}
+
+func (db *fakeStore) CreateEmailVerification(tokenHash, codeHash, yui, email string, expiresAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.emails = append(db.emails, &fakeEmailVerification{
+		tokenHash: tokenHash, codeHash: codeHash, yui: yui, email: email,
+		createdAt: time.Now(), expiresAt: expiresAt,
+	})
+	return nil
+}
+
+func (db *fakeStore) CountEmailVerifications(yui string, since time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for _, v := range db.emails {
+		if v.yui == yui && v.createdAt.After(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (db *fakeStore) HasPendingEmailVerification(yui, email string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, v := range db.emails {
+		if v.yui == yui && v.email == email && !v.used && v.expiresAt.After(time.Now()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *fakeStore) ConsumeEmailToken(tokenHash string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, v := range db.emails {
+		if v.tokenHash == tokenHash && !v.used && v.expiresAt.After(time.Now()) {
+			return db.verifyEmail(v)
+		}
+	}
+	return "", storage.ErrEmailTokenInvalid
+}
+
+func (db *fakeStore) ConsumeEmailCode(yui, codeHash string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// Код сверяется только с последним действующим письмом
+	var latest *fakeEmailVerification
+	for _, v := range db.emails {
+		if v.yui == yui && !v.used && v.expiresAt.After(time.Now()) {
+			latest = v
+		}
+	}
+	if latest == nil || latest.codeHash != codeHash {
+		return storage.ErrEmailTokenInvalid
+	}
+	_, err := db.verifyEmail(latest)
+	return err
+}
+
+// verifyEmail — как consumeEmailVerification: адрес подтверждается, только если не сменился
+func (db *fakeStore) verifyEmail(v *fakeEmailVerification) (string, error) {
+	u := db.users[v.yui]
+	if u == nil || u.Email != v.email {
+		return "", storage.ErrEmailTokenInvalid
+	}
+	u.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	for _, other := range db.emails {
+		if other.yui == v.yui {
+			other.used = true
+		}
+	}
+	return v.yui, nil
+}
+
+// fakeNotifier отдаёт отправленные сообщения в канал
+type fakeNotifier struct {
+	sent chan fakeMessage
+}
+
+type fakeMessage struct {
+	yui, subject, text string
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{sent: make(chan fakeMessage, 16)}
+}
+
+func (n *fakeNotifier) Notify(user *core.User, subject, text string) error {
+	n.sent <- fakeMessage{yui: user.YUI, subject: subject, text: text}
+	return nil
+}
+
+// next ждёт следующее сообщение
+func (n *fakeNotifier) next(t *testing.T) fakeMessage {
+	t.Helper()
+	select {
+	case m := <-n.sent:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message sent")
+		return fakeMessage{}
+	}
+}
+
+// none проверяет, что за wait ничего не отправлено
+func (n *fakeNotifier) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case m := <-n.sent:
+		t.Fatalf("unexpected message to %s: %q", m.yui, m.subject)
+	case <-time.After(wait):
+	}
+}
+
+// fakeCloser запоминает, какие соединения сервис просил закрыть
+type fakeCloser struct {
+	mu     sync.Mutex
+	closed []string // "yui/session_id"; пустой session_id — все сессии пользователя
+}
+
+func (c *fakeCloser) CloseAuthSession(yui, sessionID string) {
+	c.mu.Lock()
+	c.closed = append(c.closed, yui+"/"+sessionID)
+	c.mu.Unlock()
+}
+
+func (c *fakeCloser) CloseUserSessions(yui, exceptSessionID string) {
+	c.mu.Lock()
+	c.closed = append(c.closed, yui+"/")
+	c.mu.Unlock()
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+var (
+	ErrTelegramAuthInvalid = errors.New("invalid telegram login data")
+	ErrTelegramAuthExpired = errors.New("telegram login data is too old")
+)
+
+// Насколько старые данные виджета принимаем
+const TelegramAuthMaxAge = 10 * time.Minute
+
+// TelegramIdentity — проверенные данные Telegram Login Widget
+type TelegramIdentity struct {
+	ID        int64
+	FirstName string
+	Username  string
+	AuthDate  time.Time
+}
+
+// VerifyTelegramLogin проверяет данные виджета по схеме Telegram:
+// hash = hex(HMAC-SHA256(SHA256(bot_token), "k1=v1\nk2=v2..." по всем полям, кроме hash, в порядке ключей)).
+func VerifyTelegramLogin(botToken string, fields map[string]string, maxAge time.Duration) (*TelegramIdentity, error) {
+	hash := fields["hash"]
+	if hash == "" || botToken == "" {
+		return nil, ErrTelegramAuthInvalid
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != "hash" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		lines = append(lines, k+"="+fields[k])
+	}
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(strings.ToLower(hash)), []byte(expected)) {
+		return nil, ErrTelegramAuthInvalid
+	}
+
+	id, err := strconv.ParseInt(fields["id"], 10, 64)
+	if err != nil || id == 0 {
+		return nil, ErrTelegramAuthInvalid
+	}
+	authDate, err := strconv.ParseInt(fields["auth_date"], 10, 64)
+	if err != nil {
+		return nil, ErrTelegramAuthInvalid
+	}
+	issued := time.Unix(authDate, 0)
+	// Небольшой допуск на расхождение часов в будущее
+	if time.Since(issued) > maxAge || time.Until(issued) > time.Minute {
+		return nil, ErrTelegramAuthExpired
+	}
+
+	return &TelegramIdentity{
+		ID:        id,
+		FirstName: fields["first_name"],
+		Username:  fields["username"],
+		AuthDate:  issued,
+	}, nil
+}
+
+// LoginWithTelegram находит пользователя по telegram_id, при первом входе создаёт нового.
+// Пароля у такого пользователя нет, войти по email он не может, пока не задаст его.
+func (s *Service) LoginWithTelegram(identity *TelegramIdentity) (*core.User, bool, error) {
+	user, err := s.db.GetUserByTelegramID(identity.ID)
+	if err == nil {
+		if !user.IsActive {
+			return nil, false, fmt.Errorf("account is disabled")
+		}
+		s.db.UpdateLastLogin(user.YUI)
+		return user, false, nil
+	}
+
+	user = &core.User{
+		YUI:        fmt.Sprintf("yep_%d", time.Now().UnixNano()),
+		Email:      fmt.Sprintf("tg%d%s", identity.ID, core.TelegramEmailDomain), // email обязателен и уникален
+		Level:      "C",
+		IsActive:   true, // Telegram уже подтвердил аккаунт
+		TelegramID: identity.ID,
+	}
+	if err := s.db.CreateUser(user); err != nil {
+		// Параллельный первый вход уже создал пользователя
+		if existing, getErr := s.db.GetUserByTelegramID(identity.ID); getErr == nil {
+			return existing, false, nil
+		}
+		return nil, false, err
+	}
+
+	log.Printf("✅ Created user %s for Telegram account %d", user.YUI, identity.ID)
+	return user, true, nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBotToken = "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11"
+
+// signTelegram подписывает поля так же, как Telegram Login Widget
+func signTelegram(botToken string, fields map[string]string) map[string]string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		lines = append(lines, k+"="+fields[k])
+	}
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+
+	signed := map[string]string{"hash": hex.EncodeToString(mac.Sum(nil))}
+	for k, v := range fields {
+		signed[k] = v
+	}
+	return signed
+}
+
+func widgetFields(authDate time.Time) map[string]string {
+	return map[string]string{
+		"id":         "42",
+		"first_name": "Ivan",
+		"username":   "ivan",
+		"auth_date":  strconv.FormatInt(authDate.Unix(), 10),
+	}
+}
+
+func TestVerifyTelegramLoginKnownHash(t *testing.T) {
+	// Подпись посчитана независимо (Python, hmac + hashlib)
+	fields := map[string]string{
+		"id":         "42",
+		"first_name": "Ivan",
+		"username":   "ivan",
+		"auth_date":  "1700000000",
+		"hash":       "5a152b0f6bc14411afe091944e84d02f6ec7ebfe5ca0e30a891aa8e38924cc96",
+	}
+	maxAge := time.Since(time.Unix(1700000000, 0)) + time.Hour
+
+	identity, err := VerifyTelegramLogin(testBotToken, fields, maxAge)
+	if err != nil {
+		t.Fatalf("VerifyTelegramLogin: %v", err)
+	}
+	if identity.ID != 42 || identity.FirstName != "Ivan" || identity.Username != "ivan" || identity.AuthDate.Unix() != 1700000000 {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	fields["hash"] = strings.ToUpper(fields["hash"])
+	if _, err := VerifyTelegramLogin(testBotToken, fields, maxAge); err != nil {
+		t.Fatalf("upper-case hash: %v", err)
+	}
+}
+
+func TestVerifyTelegramLogin(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		botToken string
+		fields   func() map[string]string
+		want     error
+	}{
+		{
+			name:     "valid",
+			botToken: testBotToken,
+			fields:   func() map[string]string { return signTelegram(testBotToken, widgetFields(now)) },
+		},
+		{
+			name:     "optional fields omitted",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				f := widgetFields(now)
+				delete(f, "username")
+				return signTelegram(testBotToken, f)
+			},
+		},
+		{
+			name:     "signed by another bot",
+			botToken: testBotToken,
+			fields:   func() map[string]string { return signTelegram("654321:other", widgetFields(now)) },
+			want:     ErrTelegramAuthInvalid,
+		},
+		{
+			name:     "id changed after signing",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				f := signTelegram(testBotToken, widgetFields(now))
+				f["id"] = "43"
+				return f
+			},
+			want: ErrTelegramAuthInvalid,
+		},
+		{
+			name:     "field added after signing",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				f := signTelegram(testBotToken, widgetFields(now))
+				f["photo_url"] = "https://example.com/a.jpg"
+				return f
+			},
+			want: ErrTelegramAuthInvalid,
+		},
+		{
+			name:     "missing hash",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				f := signTelegram(testBotToken, widgetFields(now))
+				delete(f, "hash")
+				return f
+			},
+			want: ErrTelegramAuthInvalid,
+		},
+		{
+			name:     "bot token not configured",
+			botToken: "",
+			fields:   func() map[string]string { return signTelegram("", widgetFields(now)) },
+			want:     ErrTelegramAuthInvalid,
+		},
+		{
+			name:     "signed but no id",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				f := widgetFields(now)
+				delete(f, "id")
+				return signTelegram(testBotToken, f)
+			},
+			want: ErrTelegramAuthInvalid,
+		},
+		{
+			name:     "signed but bad auth_date",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				f := widgetFields(now)
+				f["auth_date"] = "yesterday"
+				return signTelegram(testBotToken, f)
+			},
+			want: ErrTelegramAuthInvalid,
+		},
+		{
+			name:     "just within max age",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				return signTelegram(testBotToken, widgetFields(now.Add(-TelegramAuthMaxAge+time.Minute)))
+			},
+		},
+		{
+			name:     "older than max age",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				return signTelegram(testBotToken, widgetFields(now.Add(-TelegramAuthMaxAge-time.Minute)))
+			},
+			want: ErrTelegramAuthExpired,
+		},
+		{
+			name:     "slightly in the future",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				return signTelegram(testBotToken, widgetFields(now.Add(30*time.Second)))
+			},
+		},
+		{
+			name:     "far in the future",
+			botToken: testBotToken,
+			fields: func() map[string]string {
+				return signTelegram(testBotToken, widgetFields(now.Add(time.Hour)))
+			},
+			want: ErrTelegramAuthExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := VerifyTelegramLogin(tt.botToken, tt.fields(), TelegramAuthMaxAge)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && identity.ID != 42 {
+				t.Fatalf("identity.ID = %d, want 42", identity.ID)
+			}
+		})
+	}
+}
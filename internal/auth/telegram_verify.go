@@ -1,11 +1,13 @@
 package auth
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 	"yep-protocol/internal/storage"
 )
 
@@ -48,52 +50,39 @@ func (h *TelegramVerifyHandler) HandleTelegramCheck(w http.ResponseWriter, r *ht
 	}
 }
 
-func (h *TelegramVerifyHandler) HandleSaveCode(w http.ResponseWriter, r *http.Request) {
+// HandleIssueCode: POST /api/telegram/code — бот просит код для доставки пользователю.
+// Код генерирует сервер; запрос должен быть подписан секретом бота (middleware.RequireBotSignature).
+func (h *TelegramVerifyHandler) HandleIssueCode(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		PhoneHash  string `json:"phone_hash"`
-		Code       string `json:"code"`
 		TelegramID int64  `json:"telegram_id"`
 	}
-
-	// Debug: Read and log raw request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		fmt.Printf("DEBUG: Failed to read request body: %v\n", err)
-		http.Error(w, "failed to read request", http.StatusBadRequest)
-		return
-	}
-	fmt.Printf("DEBUG: Raw request body: %s\n", string(body))
-	r.Body = io.NopCloser(bytes.NewReader(body))
-
-	// Decode JSON request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Printf("DEBUG: JSON decode error: %v\n", err)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PhoneHash == "" {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
 
-	fmt.Printf("DEBUG: Decoded - phone_hash: %s, code: %s, telegram_id: %d\n",
-		req.PhoneHash, req.Code, req.TelegramID)
-
-	// Check if user exists
-	user, err := h.db.GetUserByPhoneHash(req.PhoneHash)
-	if err != nil {
-		fmt.Printf("DEBUG: User not found for phone_hash: %s\n", req.PhoneHash)
+	if _, err := h.db.GetUserByPhoneHash(req.PhoneHash); err != nil {
 		http.Error(w, "user not found", http.StatusNotFound)
 		return
 	}
 
-	fmt.Printf("DEBUG: User found - YUI: %s\n", user.YUI)
-
-	// Store OTP in auth service and database
-	h.auth.StoreOTP(req.PhoneHash, req.Code)
-	h.db.SaveOTP(req.PhoneHash, req.Code, req.TelegramID)
-
-	// Respond with success
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
-		fmt.Printf("DEBUG: Response encode error: %v\n", err)
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	code, err := h.auth.IssueOTP(req.PhoneHash, req.TelegramID)
+	if err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+			http.Error(w, locked.Error(), http.StatusTooManyRequests)
+			return
+		}
+		log.Printf("Failed to issue OTP: %v", err)
+		http.Error(w, "failed to issue code", http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":       code,
+		"expires_in": int(h.auth.OTPTTL().Seconds()),
+	})
 }
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+// Параметры TOTP по RFC 6238 — те, что понимают все приложения-аутентификаторы
+const (
+	totpPeriod = 30 // секунд
+	totpDigits = 6
+	totpSkew   = 1 // принимаем соседние шаги: расхождение часов ±30 с
+	totpIssuer = "YEP"
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPInvalid          = errors.New("invalid two-factor code")
+	ErrTOTPNotEnrolled      = errors.New("two-factor authentication is not enabled")
+	ErrTOTPAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTOTPRequiredByPolicy = errors.New("two-factor authentication is required for this account level")
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SetTOTPRequiredLevels — уровни, для которых второй фактор обязателен (например "A" или "A,B")
+func (s *Service) SetTOTPRequiredLevels(levels string) {
+	s.totpLevels = make(map[string]bool)
+	for _, l := range strings.Split(levels, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			s.totpLevels[l] = true
+		}
+	}
+}
+
+// TOTPRequiredByPolicy — обязан ли пользователь использовать второй фактор
+func (s *Service) TOTPRequiredByPolicy(user *core.User) bool {
+	return s.totpLevels[user.Level]
+}
+
+// TOTPEnabled — подключён ли у пользователя второй фактор
+func (s *Service) TOTPEnabled(yui string) (bool, error) {
+	t, err := s.db.GetTOTP(yui)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.ConfirmedAt != nil, nil
+}
+
+// BeginTOTP создаёт новый секрет и URI для QR-кода. Секрет заработает после ConfirmTOTP.
+func (s *Service) BeginTOTP(user *core.User) (secret, uri string, err error) {
+	raw := make([]byte, 20) // 160 бит, как рекомендует RFC 4226
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret = b32.EncodeToString(raw)
+
+	saved, err := s.db.SaveTOTPSecret(user.YUI, secret)
+	if err != nil {
+		return "", "", err
+	}
+	if !saved {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+
+	return secret, provisioningURI(user.Email, secret), nil
+}
+
+func provisioningURI(account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+account) + "?" + q.Encode()
+}
+
+// ConfirmTOTP включает второй фактор по первому коду из приложения.
+// Возвращает коды восстановления — они показываются пользователю один раз.
+func (s *Service) ConfirmTOTP(yui, code string) ([]string, error) {
+	t, err := s.db.GetTOTP(yui)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.ConfirmedAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	if err := s.checkTOTPCode(t, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.ConfirmTOTP(yui, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyTOTP проверяет код из приложения или код восстановления при входе
+func (s *Service) VerifyTOTP(yui, code string) error {
+	t, err := s.db.GetTOTP(yui)
+	if errors.Is(err, storage.ErrTOTPNotFound) || (err == nil && t.ConfirmedAt == nil) {
+		return ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	return s.checkTOTPCode(t, code)
+}
+
+// DisableTOTP отключает второй фактор; нужен действующий код
+func (s *Service) DisableTOTP(user *core.User, code string) error {
+	if s.TOTPRequiredByPolicy(user) {
+		return ErrTOTPRequiredByPolicy
+	}
+	if err := s.VerifyTOTP(user.YUI, code); err != nil {
+		return err
+	}
+	return s.db.DeleteTOTP(user.YUI)
+}
+
+// RegenerateRecoveryCodes выдаёт новый набор кодов восстановления; нужен действующий код
+func (s *Service) RegenerateRecoveryCodes(yui, code string) ([]string, error) {
+	if err := s.VerifyTOTP(yui, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	return codes, s.db.ReplaceRecoveryCodes(yui, hashes)
+}
+
+// RecoveryCodesLeft — сколько неиспользованных кодов восстановления осталось
+func (s *Service) RecoveryCodesLeft(yui string) (int, error) {
+	return s.db.CountRecoveryCodes(yui)
+}
+
+// checkTOTPCode: 6 цифр — код приложения, иначе — код восстановления (только после подтверждения).
+// Неудачи считаются как у OTP, с блокировкой по ключу "totp:<yui>".
+func (s *Service) checkTOTPCode(t *storage.TOTP, code string) error {
+	key := "totp:" + t.YUI
+	if err := s.checkLocked(key); err != nil {
+		return err
+	}
+
+	ok, err := s.matchTOTPCode(t, code)
+	if err != nil {
+		return err
+	}
+	if ok {
+		s.resetFailures(key)
+		return nil
+	}
+
+	if err := s.recordFailure(key, s.otpPolicy.Lockout); err != nil {
+		return err
+	}
+	return ErrTOTPInvalid
+}
+
+func (s *Service) matchTOTPCode(t *storage.TOTP, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totpDigits && strings.Trim(code, "0123456789") == "" {
+		secret, err := b32.DecodeString(t.Secret)
+		if err != nil {
+			return false, err
+		}
+		step, ok := acceptTOTP(secret, code, t.LastStep, time.Now())
+		if !ok {
+			return false, nil
+		}
+		// Шаг фиксируем атомарно: тот же код параллельно второй раз не пройдёт
+		return s.db.UseTOTPStep(t.YUI, step)
+	}
+
+	if t.ConfirmedAt == nil {
+		return false, nil
+	}
+	return s.db.UseRecoveryCode(t.YUI, hashToken(normalizeRecoveryCode(code)))
+}
+
+// acceptTOTP — код совпал на шаге новее lastStep; уже принятые шаги (и более старые) повторно не проходят
+func acceptTOTP(secret []byte, code string, lastStep int64, now time.Time) (int64, bool) {
+	step, ok := matchTOTP(secret, code, now)
+	if !ok || step <= lastStep {
+		return 0, false
+	}
+	return step, true
+}
+
+// matchTOTP ищет шаг в окне ±totpSkew, на котором код совпадает
+func matchTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode — HOTP (RFC 4226) от номера шага
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// newRecoveryCodes создаёт коды вида "abcde-fghij" и их хэши для хранения
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		c := strings.ToLower(b32.EncodeToString(raw))[:10]
+		codes = append(codes, c[:5]+"-"+c[5:])
+		hashes = append(hashes, hashToken(c))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
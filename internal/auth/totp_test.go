@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Секрет из приложения B RFC 6238 (SHA1)
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Коды RFC — 8 цифр; у нас 6, то есть последние шесть
+	tests := []struct {
+		unix int64
+		rfc  string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		want := tt.rfc[len(tt.rfc)-totpDigits:]
+		if got := totpCode(rfc6238Secret, tt.unix/totpPeriod); got != want {
+			t.Errorf("T=%d: totpCode = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		step   int64
+		wantOK bool
+	}{
+		{"current step", current, true},
+		{"previous step", current - 1, true},
+		{"next step", current + 1, true},
+		{"two steps back", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(rfc6238Secret, totpCode(rfc6238Secret, tt.step), now)
+			if ok != tt.wantOK || (ok && step != tt.step) {
+				t.Fatalf("matchTOTP = %d, %v; want %d, %v", step, ok, tt.step, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAcceptTOTPReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	code := totpCode(rfc6238Secret, current)
+	previous := totpCode(rfc6238Secret, current-1)
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"fresh code", code, 0, current, true},
+		{"after older step", code, current - 1, current, true},
+		{"same step again", code, current, 0, false},
+		// Код прошлого шага ещё в окне, но после принятого текущего он уже не годится
+		{"older step after newer", previous, current, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := acceptTOTP(rfc6238Secret, tt.code, tt.lastStep, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("acceptTOTP = %d, %v; want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("newRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	for i, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Errorf("code %q is not in xxxxx-xxxxx format", c)
+		}
+		// Пользователь может ввести код заглавными, без дефиса или с пробелом
+		for _, typed := range []string{c, strings.ToUpper(c), strings.Replace(c, "-", "", 1), strings.Replace(c, "-", " ", 1)} {
+			if hashToken(normalizeRecoveryCode(typed)) != hashes[i] {
+				t.Errorf("typed %q does not match stored hash of %q", typed, c)
+			}
+		}
+	}
+}
@@ -1,12 +1,64 @@
 package config
 
-import "os"
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
 
 type Config struct {
 	Port     string
 	DBConn   string
 	MongoURI string // Добавь это
 	LogLevel string
+
+	TrustedProxies string // IP/CIDR прокси через запятую, которым доверяем X-Forwarded-For
+
+	WSSendQueue      int    // размер исходящей очереди на клиента
+	WSOverflowPolicy string // drop_oldest | drop_newest | disconnect
+
+	JWTSecret    string // HS256-секрет (kid "hs-default")
+	JWTKeys      string // дополнительные ключи: "kid:alg:/path/key.pem;..."
+	JWTActiveKID string // ключ, которым подписываем новые токены
+	// До какого момента принимать HS256-токены после перехода на асимметричный ключ
+	JWTLegacyHSUntil time.Time
+
+	MessageKeyProvider  string        // env | file | keyring | kms; пусто — env, если задан MESSAGE_KEK
+	MessageKEK          string        // base64 KEK для шифрования сообщений (env)
+	MessageKEKFile      string        // файл с base64 KEK (file)
+	MessageKEKID        string        // id текущего KEK (env/file)
+	MessageOldKEKs      string        // старые KEK для расшифровки: "id:base64,id2:base64"
+	MessageKeyring      string        // путь к JSON-связке ключей (keyring)
+	MessageKMSURL       string        // базовый URL KMS (kms)
+	MessageKMSToken     string        // Bearer-токен KMS
+	KeyRotationInterval time.Duration // как часто запускать перешифрование
+
+	TelegramBotSecret string // общий секрет для подписи запросов внешнего бота (HMAC)
+
+	TelegramBotToken      string // токен встроенного бота; пусто — бот не запускается
+	TelegramAPIURL        string // базовый URL Bot API (можно подставить локальный фейк)
+	TelegramWebhookURL    string // публичный URL, ведущий на /telegram/webhook; пусто — long polling
+	TelegramWebhookSecret string // secret_token для webhook
+
+	SMTPAddr     string // host:port; для разработки подойдёт MailHog (localhost:1025)
+	SMTPFrom     string
+	SMTPUsername string // пусто — без авторизации
+	SMTPPassword string
+
+	PasswordResetURL string // ссылка в сообщении о сбросе пароля, к ней дописывается токен
+
+	EmailVerifyURL       string // ссылка в письме с подтверждением email, к ней дописывается токен
+	RequireVerifiedEmail bool   // без подтверждённого email в чат не пускаем
+
+	PasswordHasher        string // argon2id (по умолчанию) или bcrypt
+	Argon2Memory          int    // КиБ
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	PasswordMinLength     int
+	BreachedPasswordsFile string // пароли или SHA-1 (hex) по одному в строке; пусто — только встроенный список
+
+	TOTPRequiredLevels string // уровни через запятую, которым второй фактор обязателен
 }
 
 func Load() *Config {
@@ -15,6 +67,53 @@ func Load() *Config {
 		DBConn:   getEnv("DATABASE_URL", ""), // пусто по умолчанию, чтобы не использовать localhost на Railway
 		MongoURI: getEnv("MONGO_URL", ""),    // пусто по умолчанию
 		LogLevel: getEnv("LOG_LEVEL", "info"),
+
+		TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
+
+		WSSendQueue:      getEnvInt("WS_SEND_QUEUE", 256),
+		WSOverflowPolicy: getEnv("WS_OVERFLOW_POLICY", "drop_oldest"),
+
+		JWTSecret:    getEnv("JWT_SECRET", ""),
+		JWTKeys:      getEnv("JWT_KEYS", ""),
+		JWTActiveKID: getEnv("JWT_ACTIVE_KID", ""),
+
+		JWTLegacyHSUntil: getEnvTime("JWT_LEGACY_HS_UNTIL"),
+
+		MessageKeyProvider:  getEnv("MESSAGE_KEY_PROVIDER", ""),
+		MessageKEK:          getEnv("MESSAGE_KEK", ""),
+		MessageKEKFile:      getEnv("MESSAGE_KEK_FILE", ""),
+		MessageKEKID:        getEnv("MESSAGE_KEK_ID", "kek-1"),
+		MessageOldKEKs:      getEnv("MESSAGE_OLD_KEKS", ""),
+		MessageKeyring:      getEnv("MESSAGE_KEYRING", "keyring.json"),
+		MessageKMSURL:       getEnv("MESSAGE_KMS_URL", ""),
+		MessageKMSToken:     getEnv("MESSAGE_KMS_TOKEN", ""),
+		KeyRotationInterval: getEnvDuration("KEY_ROTATION_INTERVAL", time.Hour),
+
+		TelegramBotSecret: getEnv("TELEGRAM_BOT_SECRET", ""),
+
+		TelegramBotToken:      getEnv("TELEGRAM_BOT_TOKEN", ""),
+		TelegramAPIURL:        getEnv("TELEGRAM_API_URL", "https://api.telegram.org"),
+		TelegramWebhookURL:    getEnv("TELEGRAM_WEBHOOK_URL", ""),
+		TelegramWebhookSecret: getEnv("TELEGRAM_WEBHOOK_SECRET", ""),
+
+		SMTPAddr:     getEnv("SMTP_ADDR", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "YEP <no-reply@yep.local>"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		PasswordResetURL: getEnv("PASSWORD_RESET_URL", ""),
+
+		EmailVerifyURL:       getEnv("EMAIL_VERIFY_URL", ""),
+		RequireVerifiedEmail: getEnvBool("REQUIRE_VERIFIED_EMAIL", false),
+
+		PasswordHasher:        getEnv("PASSWORD_HASHER", "argon2id"),
+		Argon2Memory:          getEnvInt("ARGON2_MEMORY_KIB", 19*1024),
+		Argon2Iterations:      getEnvInt("ARGON2_ITERATIONS", 2),
+		Argon2Parallelism:     getEnvInt("ARGON2_PARALLELISM", 1),
+		PasswordMinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		BreachedPasswordsFile: getEnv("BREACHED_PASSWORDS_FILE", ""),
+
+		TOTPRequiredLevels: getEnv("TOTP_REQUIRED_LEVELS", "A"),
 	}
 }
 
@@ -24,3 +123,41 @@ func getEnv(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+func getEnvInt(key string, defaultVal int) int {
+	if val := os.Getenv(key); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			return n
+		}
+	}
+	return defaultVal
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+	return defaultVal
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
+
+// getEnvTime читает время в RFC 3339; не задано или не разобралось — нулевое время
+func getEnvTime(key string) time.Time {
+	if val := os.Getenv(key); val != "" {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			return t
+		}
+		log.Printf("⚠️ %s is not an RFC 3339 time, ignored", key)
+	}
+	return time.Time{}
+}
@@ -15,16 +15,44 @@ type User struct {
 	CreatedAt    time.Time
 	LastLogin    sql.NullTime
 	IsActive     bool
+	TelegramID   int64 // 0 — Telegram не привязан
+	// Когда владелец подтвердил email; пусто — адрес не подтверждён
+	EmailVerifiedAt sql.NullTime
 }
 
 type YepMessage struct {
-	Type      string      `json:"type"`
-	Content   string      `json:"content"`
-	YUI       string      `json:"yui,omitempty"`
-	Level     string      `json:"level,omitempty"`
-	Token     string      `json:"token,omitempty"` // Добавь это
-	Data      interface{} `json:"data,omitempty"`  // Добавь это
-	Timestamp int64       `json:"timestamp"`
+	Type         string      `json:"type"`
+	Content      string      `json:"content"`
+	YUI          string      `json:"yui,omitempty"`
+	ToYUI        string      `json:"to_yui,omitempty"` // адресат для DIRECT_MESSAGE
+	Room         string      `json:"room,omitempty"`   // комната; пусто = lobby
+	MessageID    string      `json:"message_id,omitempty"`
+	ClientMsgID  string      `json:"client_msg_id,omitempty"` // от клиента, для безопасных повторов
+	Conversation string      `json:"conversation,omitempty"`  // "room:<name>" или "dm:<yui>:<yui>"
+	Seq          int64       `json:"seq,omitempty"`           // номер сообщения в conversation
+	Level        string      `json:"level,omitempty"`
+	Encrypted    bool        `json:"encrypted,omitempty"` // Content — шифротекст E2E, сервер его не читает
+	Token        string      `json:"token,omitempty"`     // Добавь это
+	RefreshToken string      `json:"refresh_token,omitempty"`
+	Data         interface{} `json:"data,omitempty"` // Добавь это
+	Timestamp    int64       `json:"timestamp"`
+}
+
+// Домен email-заглушки у пользователей, пришедших через Telegram: писем туда не шлём
+const TelegramEmailDomain = "@telegram.yep"
+
+// Домен-заглушка для адреса, освобождённого у аккаунта, который его так и не подтвердил
+const ReleasedEmailDomain = "@released.yep"
+
+// Комната по умолчанию: в ней все пользователи и старые клиенты без поля room
+const LobbyRoom = "lobby"
+
+// Комната (канал) чата
+type Room struct {
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Members   int       `json:"members"`
 }
 
 type YepAuth struct {
@@ -4,56 +4,74 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 )
 
-var encryptionKey = []byte("your-32-byte-long-key-here!!!!!") // Должен быть в ENV!
+// Размер ключа AES-256
+const KeySize = 32
 
-func EncryptMessage(plaintext string) (string, error) {
-	block, err := aes.NewCipher(encryptionKey)
-	if err != nil {
-		return "", err
+var (
+	ErrCiphertextTooShort  = errors.New("ciphertext too short")
+	ErrMalformedCiphertext = errors.New("malformed ciphertext") // KMS не разобрал запрос
+	ErrKeyNotFound         = errors.New("encryption key not found")
+	ErrInvalidKey          = errors.New("invalid encryption key")
+	// Ключ не тот или данные повреждены/подменены
+	ErrAuthFailed = errors.New("message authentication failed")
+)
+
+// GenerateKey создаёт случайный ключ AES-256
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
 	}
+	return key, nil
+}
 
-	// GCM для аутентифицированного шифрования
-	gcm, err := cipher.NewGCM(block)
+// Seal шифрует AES-GCM; результат — nonce || ciphertext.
+// aad не шифруется, но привязывается к шифротексту (например, id диалога).
+func Seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
 }
 
-func DecryptMessage(ciphertext string) (string, error) {
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
+// Open расшифровывает результат Seal
+func Open(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	block, err := aes.NewCipher(encryptionKey)
-	if err != nil {
-		return "", err
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize+gcm.Overhead() {
+		return nil, ErrCiphertextTooShort
 	}
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 
-	gcm, err := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
 	if err != nil {
-		return "", err
+		return nil, ErrAuthFailed
 	}
+	return plaintext, nil
+}
 
-	nonceSize := gcm.NonceSize()
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
 	}
 
-	return string(plaintext), nil
+	// GCM для аутентифицированного шифрования
+	return cipher.NewGCM(block)
 }
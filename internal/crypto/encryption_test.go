@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func TestSealOpen(t *testing.T) {
+	key := newTestKey(t)
+	aad := []byte("dm:alice:bob")
+
+	sealed, err := Seal(key, []byte("hello"), aad)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	again, err := Seal(key, []byte("hello"), aad)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Fatal("two seals of the same plaintext are identical: nonce reused")
+	}
+
+	flip := func(i int) []byte {
+		data := bytes.Clone(sealed)
+		data[i] ^= 1
+		return data
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		data []byte
+		aad  []byte
+		want error
+	}{
+		{"valid", key, sealed, aad, nil},
+		{"empty", key, nil, aad, ErrCiphertextTooShort},
+		{"nonce only", key, sealed[:12], aad, ErrCiphertextTooShort},
+		{"one byte short of a tag", key, sealed[:12+15], aad, ErrCiphertextTooShort},
+		{"truncated", key, sealed[:len(sealed)-1], aad, ErrAuthFailed},
+		{"flipped nonce bit", key, flip(0), aad, ErrAuthFailed},
+		{"flipped ciphertext bit", key, flip(12), aad, ErrAuthFailed},
+		{"flipped tag bit", key, flip(len(sealed) - 1), aad, ErrAuthFailed},
+		{"other aad", key, sealed, []byte("dm:alice:mallory"), ErrAuthFailed},
+		{"no aad", key, sealed, nil, ErrAuthFailed},
+		{"other key", newTestKey(t), sealed, aad, ErrAuthFailed},
+		{"short key", key[:31], sealed, aad, ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := Open(tt.key, tt.data, tt.aad)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && string(plaintext) != "hello" {
+				t.Fatalf("plaintext = %q", plaintext)
+			}
+		})
+	}
+
+	if _, err := Seal(key[:16+1], []byte("hello"), nil); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Seal with 17-byte key: err = %v, want ErrInvalidKey", err)
+	}
+}
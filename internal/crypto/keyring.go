@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Keyring — локальная связка ключей в JSON-файле:
+//
+//	{"current": "k2", "keys": {"k1": "base64...", "k2": "base64..."}}
+//
+// Ротация добавляет новый ключ и делает его текущим, старые остаются для расшифровки.
+type Keyring struct {
+	path string
+
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// OpenKeyring читает связку ключей; если файла нет — создаёт новую с одним ключом
+func OpenKeyring(path string) (*Keyring, error) {
+	kr := &Keyring{path: path, keys: make(map[string][]byte)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if _, err := kr.Rotate(); err != nil {
+			return nil, err
+		}
+		return kr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("keyring %s: %w", path, err)
+	}
+	for id, encoded := range f.Keys {
+		key, err := DecodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s, key %s: %w", path, id, err)
+		}
+		kr.keys[id] = key
+	}
+	if _, ok := kr.keys[f.Current]; !ok {
+		return nil, fmt.Errorf("keyring %s: %w: current key %s", path, ErrKeyNotFound, f.Current)
+	}
+	kr.current = f.Current
+
+	return kr, nil
+}
+
+// Rotate создаёт новый ключ, делает его текущим и сохраняет файл
+func (kr *Keyring) Rotate() (string, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	// id не должен совпасть с существующим, иначе потеряем старый ключ
+	id := fmt.Sprintf("k%d", time.Now().Unix())
+	for n := 2; kr.keys[id] != nil; n++ {
+		id = fmt.Sprintf("k%d-%d", time.Now().Unix(), n)
+	}
+
+	kr.keys[id] = key
+	prev := kr.current
+	kr.current = id
+	if err := kr.save(); err != nil {
+		delete(kr.keys, id)
+		kr.current = prev
+		return "", err
+	}
+	return id, nil
+}
+
+// save пишет файл атомарно: во временный, затем rename
+func (kr *Keyring) save() error {
+	f := keyringFile{Current: kr.current, Keys: make(map[string]string, len(kr.keys))}
+	for id, key := range kr.keys {
+		f.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := kr.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.path)
+}
+
+func (kr *Keyring) CurrentKeyID() string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.current
+}
+
+func (kr *Keyring) Wrap(keyID string, plaintext, aad []byte) ([]byte, error) {
+	key, err := kr.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(key, plaintext, aad)
+}
+
+func (kr *Keyring) Unwrap(keyID string, ciphertext, aad []byte) ([]byte, error) {
+	key, err := kr.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return Open(key, ciphertext, aad)
+}
+
+func (kr *Keyring) key(id string) ([]byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
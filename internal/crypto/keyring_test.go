@@ -0,0 +1,113 @@
+package crypto
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyringRotateAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+
+	kr, err := OpenKeyring(path)
+	if err != nil {
+		t.Fatalf("OpenKeyring: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("keyring file not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("keyring file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	first := kr.CurrentKeyID()
+	old, err := kr.Wrap(first, []byte("old data key"), []byte("aad"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	second, err := kr.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if second == first || kr.CurrentKeyID() != second {
+		t.Fatalf("after Rotate current = %q (was %q, Rotate returned %q)", kr.CurrentKeyID(), first, second)
+	}
+	fresh, err := kr.Wrap(second, []byte("new data key"), []byte("aad"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	reopened, err := OpenKeyring(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if reopened.CurrentKeyID() != second {
+		t.Fatalf("reopened current = %q, want %q", reopened.CurrentKeyID(), second)
+	}
+	for _, c := range []struct {
+		keyID, want string
+		data        []byte
+	}{{first, "old data key", old}, {second, "new data key", fresh}} {
+		got, err := reopened.Unwrap(c.keyID, c.data, []byte("aad"))
+		if err != nil || string(got) != c.want {
+			t.Fatalf("Unwrap(%s) after reopen = %q, %v", c.keyID, got, err)
+		}
+	}
+
+	if _, err := reopened.Unwrap("k0", old, []byte("aad")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Unwrap with unknown key: err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestKeyringRotateFailureKeepsCurrent(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	kr, err := OpenKeyring(filepath.Join(dir, "keyring.json"))
+	if err != nil {
+		t.Fatalf("OpenKeyring: %v", err)
+	}
+	current := kr.CurrentKeyID()
+
+	// Сохранить некуда — новый ключ не должен стать текущим
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	if _, err := kr.Rotate(); err == nil {
+		t.Fatal("Rotate without a writable file: want error")
+	}
+	if kr.CurrentKeyID() != current {
+		t.Fatalf("current = %q after failed Rotate, want %q", kr.CurrentKeyID(), current)
+	}
+}
+
+func TestOpenKeyringRejects(t *testing.T) {
+	key := encodeKey(newTestKey(t))
+
+	tests := []struct {
+		name    string
+		content string
+		want    error // nil — любая ошибка
+	}{
+		{"not json", "{", nil},
+		{"current key missing", `{"current": "k2", "keys": {"k1": "` + key + `"}}`, ErrKeyNotFound},
+		{"no keys", `{"current": "k1", "keys": {}}`, ErrKeyNotFound},
+		{"short key", `{"current": "k1", "keys": {"k1": "c2hvcnQ="}}`, ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keyring.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			_, err := OpenKeyring(path)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
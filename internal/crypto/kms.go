@@ -0,0 +1,233 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Протокол KMS (JSON поверх HTTP, []byte — base64):
+//
+//	GET  /v1/keys/current -> {"key_id": "..."}
+//	POST /v1/wrap   {"key_id", "plaintext", "aad"}  -> {"key_id", "ciphertext"}
+//	POST /v1/unwrap {"key_id", "ciphertext", "aad"} -> {"plaintext"}
+//
+// Ошибки: {"error": "key_not_found" | "auth_failed" | ...}.
+// Ключи не покидают KMS; локально его заменяет KMSHandler поверх любого KeyProvider.
+
+type kmsRequest struct {
+	KeyID      string `json:"key_id"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	AAD        []byte `json:"aad,omitempty"`
+}
+
+type kmsResponse struct {
+	KeyID      string `json:"key_id,omitempty"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Коды ошибок протокола <-> типизированные ошибки пакета
+var kmsErrors = map[string]error{
+	"key_not_found":     ErrKeyNotFound,
+	"auth_failed":       ErrAuthFailed,
+	"ciphertext_short":  ErrCiphertextTooShort,
+	"malformed_request": ErrMalformedCiphertext,
+}
+
+func kmsErrorCode(err error) string {
+	for code, target := range kmsErrors {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return "internal"
+}
+
+// KMSClient — KeyProvider поверх HTTP KMS
+type KMSClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+
+	mu        sync.Mutex
+	current   string
+	fetchedAt time.Time
+}
+
+// Как долго доверяем закэшированному id текущего ключа
+const kmsCurrentKeyTTL = time.Minute
+
+func NewKMSClient(baseURL, token string) (*KMSClient, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("KMS URL is not configured")
+	}
+	c := &KMSClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+	// Проверяем доступность сразу, чтобы не упасть на первом сообщении
+	if _, err := c.fetchCurrent(); err != nil {
+		return nil, fmt.Errorf("KMS is unavailable: %w", err)
+	}
+	return c, nil
+}
+
+// CurrentKeyID возвращает id текущего ключа; при недоступности KMS — последний известный
+func (c *KMSClient) CurrentKeyID() string {
+	c.mu.Lock()
+	fresh := time.Since(c.fetchedAt) < kmsCurrentKeyTTL
+	current := c.current
+	c.mu.Unlock()
+	if fresh {
+		return current
+	}
+
+	id, err := c.fetchCurrent()
+	if err != nil {
+		return current
+	}
+	return id
+}
+
+func (c *KMSClient) fetchCurrent() (string, error) {
+	var resp kmsResponse
+	if err := c.call(http.MethodGet, "/v1/keys/current", nil, &resp); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.current = resp.KeyID
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+	return resp.KeyID, nil
+}
+
+func (c *KMSClient) Wrap(keyID string, plaintext, aad []byte) ([]byte, error) {
+	var resp kmsResponse
+	err := c.call(http.MethodPost, "/v1/wrap", &kmsRequest{KeyID: keyID, Plaintext: plaintext, AAD: aad}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (c *KMSClient) Unwrap(keyID string, ciphertext, aad []byte) ([]byte, error) {
+	var resp kmsResponse
+	err := c.call(http.MethodPost, "/v1/unwrap", &kmsRequest{KeyID: keyID, Ciphertext: ciphertext, AAD: aad}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (c *KMSClient) call(method, path string, body *kmsRequest, out *kmsResponse) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("KMS %s: status %d: %w", path, resp.StatusCode, err)
+	}
+	if out.Error != "" {
+		if target, ok := kmsErrors[out.Error]; ok {
+			return fmt.Errorf("KMS %s: %w", path, target)
+		}
+		return fmt.Errorf("KMS %s: %s", path, out.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("KMS %s: status %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// KMSHandler — локальная замена KMS: отдаёт протокол поверх любого KeyProvider.
+// token пустой — без авторизации (только для разработки).
+func KMSHandler(p KeyProvider, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/keys/current", func(w http.ResponseWriter, r *http.Request) {
+		writeKMS(w, http.StatusOK, kmsResponse{KeyID: p.CurrentKeyID()})
+	})
+
+	mux.HandleFunc("POST /v1/wrap", func(w http.ResponseWriter, r *http.Request) {
+		var req kmsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeKMS(w, http.StatusBadRequest, kmsResponse{Error: "malformed_request"})
+			return
+		}
+		ciphertext, err := p.Wrap(req.KeyID, req.Plaintext, req.AAD)
+		if err != nil {
+			writeKMSError(w, err)
+			return
+		}
+		writeKMS(w, http.StatusOK, kmsResponse{KeyID: req.KeyID, Ciphertext: ciphertext})
+	})
+
+	mux.HandleFunc("POST /v1/unwrap", func(w http.ResponseWriter, r *http.Request) {
+		var req kmsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeKMS(w, http.StatusBadRequest, kmsResponse{Error: "malformed_request"})
+			return
+		}
+		plaintext, err := p.Unwrap(req.KeyID, req.Ciphertext, req.AAD)
+		if err != nil {
+			writeKMSError(w, err)
+			return
+		}
+		writeKMS(w, http.StatusOK, kmsResponse{KeyID: req.KeyID, Plaintext: plaintext})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeKMS(w, http.StatusUnauthorized, kmsResponse{Error: "unauthorized"})
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeKMSError(w http.ResponseWriter, err error) {
+	code := kmsErrorCode(err)
+	status := http.StatusBadRequest
+	if code == "internal" {
+		status = http.StatusInternalServerError
+	}
+	writeKMS(w, status, kmsResponse{Error: code})
+}
+
+func writeKMS(w http.ResponseWriter, status int, resp kmsResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testKMSToken = "kms-token"
+
+func newTestKMS(t *testing.T) (*StaticKeys, *httptest.Server, *KMSClient) {
+	t.Helper()
+	backend, err := NewStaticKeys("k2", map[string][]byte{"k1": newTestKey(t), "k2": newTestKey(t)})
+	if err != nil {
+		t.Fatalf("NewStaticKeys: %v", err)
+	}
+	srv := httptest.NewServer(KMSHandler(backend, testKMSToken))
+	t.Cleanup(srv.Close)
+
+	client, err := NewKMSClient(srv.URL+"/", testKMSToken)
+	if err != nil {
+		t.Fatalf("NewKMSClient: %v", err)
+	}
+	return backend, srv, client
+}
+
+func TestKMSClientRoundTrip(t *testing.T) {
+	backend, _, client := newTestKMS(t)
+
+	if client.CurrentKeyID() != "k2" {
+		t.Fatalf("current key = %q, want k2", client.CurrentKeyID())
+	}
+
+	wrapped, err := client.Wrap("k2", []byte("data key"), []byte("dm:alice:bob#1"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	// Обёртка та же, что у провайдера за KMS
+	if got, err := backend.Unwrap("k2", wrapped, []byte("dm:alice:bob#1")); err != nil || string(got) != "data key" {
+		t.Fatalf("backend Unwrap = %q, %v", got, err)
+	}
+	if got, err := client.Unwrap("k2", wrapped, []byte("dm:alice:bob#1")); err != nil || string(got) != "data key" {
+		t.Fatalf("Unwrap = %q, %v", got, err)
+	}
+
+	// Старым ключом KMS по-прежнему расшифровывает
+	old, err := backend.Wrap("k1", []byte("old data key"), nil)
+	if err != nil {
+		t.Fatalf("backend Wrap: %v", err)
+	}
+	if got, err := client.Unwrap("k1", old, nil); err != nil || string(got) != "old data key" {
+		t.Fatalf("Unwrap with old key = %q, %v", got, err)
+	}
+}
+
+func TestKMSClientErrors(t *testing.T) {
+	_, _, client := newTestKMS(t)
+	wrapped, err := client.Wrap("k2", []byte("data key"), []byte("aad"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	tampered := append([]byte(nil), wrapped...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name       string
+		keyID      string
+		ciphertext []byte
+		aad        []byte
+		want       error
+	}{
+		{"unknown key", "k0", wrapped, []byte("aad"), ErrKeyNotFound},
+		{"tampered", "k2", tampered, []byte("aad"), ErrAuthFailed},
+		{"other aad", "k2", wrapped, []byte("other"), ErrAuthFailed},
+		{"wrong key", "k1", wrapped, []byte("aad"), ErrAuthFailed},
+		{"short", "k2", wrapped[:10], []byte("aad"), ErrCiphertextTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.Unwrap(tt.keyID, tt.ciphertext, tt.aad); !errors.Is(err, tt.want) {
+				t.Fatalf("Unwrap: err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := client.Wrap("k0", []byte("data key"), nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Wrap with unknown key: err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestNewKMSClientRejects(t *testing.T) {
+	_, srv, _ := newTestKMS(t)
+
+	tests := []struct {
+		name  string
+		url   string
+		token string
+	}{
+		{"no URL", "", testKMSToken},
+		{"wrong token", srv.URL, "other"},
+		{"no token", srv.URL, ""},
+		{"not a KMS", srv.URL + "/nothing", testKMSToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKMSClient(tt.url, tt.token); err == nil {
+				t.Fatal("want error")
+			}
+		})
+	}
+}
+
+func TestKMSClientKeepsLastKnownCurrentKey(t *testing.T) {
+	_, srv, client := newTestKMS(t)
+	srv.Close()
+
+	// Кэш устарел, KMS недоступен — пишем последним известным ключом, а не пустым id
+	client.mu.Lock()
+	client.fetchedAt = time.Now().Add(-2 * kmsCurrentKeyTTL)
+	client.mu.Unlock()
+
+	if got := client.CurrentKeyID(); got != "k2" {
+		t.Fatalf("current key with KMS down = %q, want k2", got)
+	}
+	if _, err := client.Wrap("k2", []byte("data key"), nil); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Wrap with KMS down: err = %v, want a transport error", err)
+	}
+}
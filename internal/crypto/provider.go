@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyProvider шифрует небольшие секреты (ключи данных сообщений) ключами,
+// которые могут и не покидать провайдер (KMS). Ключ выбирается по id, новые данные
+// шифруются текущим ключом, старые id остаются доступны для расшифровки.
+type KeyProvider interface {
+	CurrentKeyID() string
+	Wrap(keyID string, plaintext, aad []byte) ([]byte, error)
+	Unwrap(keyID string, ciphertext, aad []byte) ([]byte, error)
+}
+
+// StaticKeys — набор ключей, известных процессу (из ENV или файла)
+type StaticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeys: keys должен содержать current
+func NewStaticKeys(current string, keys map[string][]byte) (*StaticKeys, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: current key %s is not configured", ErrKeyNotFound, current)
+	}
+	for id, key := range keys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("%w: key %s must be %d bytes", ErrInvalidKey, id, KeySize)
+		}
+	}
+	return &StaticKeys{current: current, keys: keys}, nil
+}
+
+func (s *StaticKeys) CurrentKeyID() string {
+	return s.current
+}
+
+func (s *StaticKeys) Wrap(keyID string, plaintext, aad []byte) ([]byte, error) {
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	return Seal(key, plaintext, aad)
+}
+
+func (s *StaticKeys) Unwrap(keyID string, ciphertext, aad []byte) ([]byte, error) {
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	return Open(key, ciphertext, aad)
+}
+
+// ProviderConfig — откуда брать ключи
+type ProviderConfig struct {
+	Type string // env | file | keyring | kms; пусто — env, если задан Key
+
+	KeyID   string // id текущего ключа (env/file)
+	Key     string // base64-ключ (env)
+	KeyFile string // файл с base64-ключом (file)
+	OldKeys string // старые ключи "id:base64,id2:base64" (env/file)
+
+	KeyringPath string // JSON-файл связки ключей (keyring)
+
+	KMSURL   string // базовый URL KMS (kms)
+	KMSToken string // Bearer-токен для KMS
+}
+
+// NewKeyProvider собирает провайдер по конфигу. nil без ошибки — шифрование не настроено.
+func NewKeyProvider(cfg ProviderConfig) (KeyProvider, error) {
+	typ := cfg.Type
+	if typ == "" && cfg.Key != "" {
+		typ = "env"
+	}
+
+	switch typ {
+	case "":
+		return nil, nil
+	case "env", "file":
+		keys, err := ParseKeyList(cfg.OldKeys)
+		if err != nil {
+			return nil, err
+		}
+		encoded := cfg.Key
+		if typ == "file" {
+			data, err := os.ReadFile(cfg.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			encoded = string(data)
+		}
+		if keys[cfg.KeyID], err = DecodeKey(encoded); err != nil {
+			return nil, fmt.Errorf("key %s: %w", cfg.KeyID, err)
+		}
+		return NewStaticKeys(cfg.KeyID, keys)
+	case "keyring":
+		return OpenKeyring(cfg.KeyringPath)
+	case "kms":
+		return NewKMSClient(cfg.KMSURL, cfg.KMSToken)
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", typ)
+	}
+}
+
+// ParseKeyList разбирает список ключей "id:base64,id2:base64"
+func ParseKeyList(list string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key entry %q, want id:base64", item)
+		}
+		key, err := DecodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// DecodeKey декодирует base64-ключ и проверяет размер
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%w: must be %d bytes, got %d", ErrInvalidKey, KeySize, len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestNewKeyProvider(t *testing.T) {
+	k1, k2 := newTestKey(t), newTestKey(t)
+	// Старое значение, зашифрованное ключом k1 до ротации
+	wrapped, err := Seal(k1, []byte("data key"), []byte("aad"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "kek")
+	if err := os.WriteFile(keyFile, []byte(encodeKey(k2)+"\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		cfg    ProviderConfig
+		want   error // nil — провайдер должен собраться
+		anyErr bool  // ошибка без типизированной причины
+	}{
+		{"env", ProviderConfig{Type: "env", KeyID: "k2", Key: encodeKey(k2), OldKeys: "k1:" + encodeKey(k1)}, nil, false},
+		{"env implied by key", ProviderConfig{KeyID: "k2", Key: encodeKey(k2), OldKeys: " k1:" + encodeKey(k1) + " ,"}, nil, false},
+		{"file", ProviderConfig{Type: "file", KeyID: "k2", KeyFile: keyFile, OldKeys: "k1:" + encodeKey(k1)}, nil, false},
+		{"env key not base64", ProviderConfig{Type: "env", KeyID: "k2", Key: "not base64!"}, ErrInvalidKey, false},
+		{"env key too short", ProviderConfig{Type: "env", KeyID: "k2", Key: encodeKey(k2[:16])}, ErrInvalidKey, false},
+		{"old key too short", ProviderConfig{Type: "env", KeyID: "k2", Key: encodeKey(k2), OldKeys: "k1:" + encodeKey(k1[:16])}, ErrInvalidKey, false},
+		{"old key without id", ProviderConfig{Type: "env", KeyID: "k2", Key: encodeKey(k2), OldKeys: encodeKey(k1)}, nil, true},
+		{"missing key file", ProviderConfig{Type: "file", KeyID: "k2", KeyFile: keyFile + ".missing"}, nil, true},
+		{"unknown type", ProviderConfig{Type: "vault"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewKeyProvider(tt.cfg)
+			if tt.want != nil || tt.anyErr {
+				if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+					t.Fatalf("err = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewKeyProvider: %v", err)
+			}
+
+			if p.CurrentKeyID() != "k2" {
+				t.Fatalf("current key = %q, want k2", p.CurrentKeyID())
+			}
+			if got, err := p.Unwrap("k1", wrapped, []byte("aad")); err != nil || string(got) != "data key" {
+				t.Fatalf("Unwrap with old key = %q, %v", got, err)
+			}
+			sealed, err := p.Wrap(p.CurrentKeyID(), []byte("new data key"), nil)
+			if err != nil {
+				t.Fatalf("Wrap: %v", err)
+			}
+			if got, err := Open(k2, sealed, nil); err != nil || string(got) != "new data key" {
+				t.Fatalf("Wrap did not use the current key: %q, %v", got, err)
+			}
+			if _, err := p.Unwrap("k0", wrapped, []byte("aad")); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("Unwrap with unknown key: err = %v, want ErrKeyNotFound", err)
+			}
+		})
+	}
+
+	if p, err := NewKeyProvider(ProviderConfig{}); p != nil || err != nil {
+		t.Fatalf("empty config = %v, %v; want encryption disabled", p, err)
+	}
+}
+
+func TestNewStaticKeys(t *testing.T) {
+	if _, err := NewStaticKeys("k2", map[string][]byte{"k1": newTestKey(t)}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("current key missing: err = %v, want ErrKeyNotFound", err)
+	}
+	if _, err := NewStaticKeys("k1", map[string][]byte{"k1": newTestKey(t)[:16]}); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("short key: err = %v, want ErrInvalidKey", err)
+	}
+}
@@ -0,0 +1,386 @@
+// Package e2e — клиентская часть сквозного шифрования личных сообщений.
+//
+// У каждого устройства есть Ed25519-ключ подписи и подписанные им X25519
+// prekey. X25519 identity-ключ не независимый: он получен из того же seed,
+// что и ключ подписи (как crypto_sign_ed25519_*_to_curve25519 в libsodium),
+// поэтому VerifyBundle выводит identity из signing_key и сверяет. Подменить
+// ключ подписи, оставив чужой identity, нельзя — сверять с собеседником
+// достаточно identity-ключа. Публичные части загружаются в каталог ключей
+// (PUT /api/keys), бандлы собеседника берутся из GET /api/keys/{yui}.
+//
+// Согласование ключа — упрощённый X3DH без одноразовых prekey: на каждое
+// сообщение отправитель создаёт эфемерный ключ EK и для каждого устройства
+// получателя считает
+//
+//	DH1 = DH(IK_a, SPK_b), DH2 = DH(EK_a, IK_b), DH3 = DH(EK_a, SPK_b)
+//	key = HKDF-SHA256(DH1 || DH2 || DH3, info = "yep-e2e-v1")
+//
+// Результат — base64 JSON-конверт, который сервер хранит и пересылает как
+// есть в Content сообщения E2E_MESSAGE.
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"yep-protocol/internal/crypto"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	envelopeVersion = 1
+	kdfInfo         = "yep-e2e-v1"
+)
+
+var (
+	ErrInvalidBundle    = errors.New("invalid key bundle")
+	ErrBadSignature     = errors.New("signed prekey signature is invalid")
+	ErrIdentityMismatch = errors.New("identity key does not match signing key")
+	ErrNotForThisDevice = errors.New("message is not addressed to this device")
+	ErrUnknownPrekey    = errors.New("signed prekey is unknown or already deleted")
+	ErrMalformed        = errors.New("malformed encrypted message")
+)
+
+// SignedPrekey — публичная часть подписанного prekey (JSON совпадает с API)
+type SignedPrekey struct {
+	KeyID     int64  `json:"key_id"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+// Bundle — публичные ключи устройства, как их отдаёт и принимает /api/keys
+type Bundle struct {
+	YUI          string        `json:"yui,omitempty"`
+	DeviceID     string        `json:"device_id"`
+	IdentityKey  []byte        `json:"identity_key"`
+	SigningKey   []byte        `json:"signing_key"`
+	SignedPrekey *SignedPrekey `json:"signed_prekey"`
+}
+
+// VerifyBundle проверяет размеры ключей, связь identity с ключом подписи и подпись prekey
+func VerifyBundle(b *Bundle) error {
+	if b == nil || b.SignedPrekey == nil {
+		return ErrInvalidBundle
+	}
+	if len(b.IdentityKey) != 32 || len(b.SignedPrekey.PublicKey) != 32 || len(b.SigningKey) != ed25519.PublicKeySize {
+		return ErrInvalidBundle
+	}
+	identity, err := identityFromSigningKey(b.SigningKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(identity, b.IdentityKey) {
+		return ErrIdentityMismatch
+	}
+	if !ed25519.Verify(b.SigningKey, b.SignedPrekey.PublicKey, b.SignedPrekey.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Device — ключи одного устройства вместе с приватными частями
+type Device struct {
+	YUI      string
+	DeviceID string
+
+	identity *ecdh.PrivateKey
+	signing  ed25519.PrivateKey
+	prekeyID int64
+	prekeys  map[int64]*ecdh.PrivateKey // текущий и старые, для сообщений в пути
+}
+
+// NewDevice создаёт ключи нового устройства с первым подписанным prekey
+func NewDevice(yui, deviceID string) (*Device, error) {
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	// Скаляр Ed25519 — первая половина SHA-512(seed); X25519 сам его «зажимает»
+	h := sha512.Sum512(signing.Seed())
+	identity, err := ecdh.X25519().NewPrivateKey(h[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Device{
+		YUI:      yui,
+		DeviceID: deviceID,
+		identity: identity,
+		signing:  signing,
+		prekeys:  make(map[int64]*ecdh.PrivateKey),
+	}
+	if err := d.RotatePrekey(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// RotatePrekey создаёт новый подписанный prekey; старые остаются для расшифровки
+func (d *Device) RotatePrekey() error {
+	prekey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	id := time.Now().UnixNano()
+	if id <= d.prekeyID {
+		id = d.prekeyID + 1
+	}
+	d.prekeyID = id
+	d.prekeys[id] = prekey
+	return nil
+}
+
+// DeletePrekey удаляет старый prekey: сообщения на него больше не расшифровать
+func (d *Device) DeletePrekey(id int64) {
+	if id != d.prekeyID {
+		delete(d.prekeys, id)
+	}
+}
+
+// Bundle возвращает публичные ключи для загрузки в каталог
+func (d *Device) Bundle() *Bundle {
+	pub := d.prekeys[d.prekeyID].PublicKey().Bytes()
+	return &Bundle{
+		YUI:         d.YUI,
+		DeviceID:    d.DeviceID,
+		IdentityKey: d.identity.PublicKey().Bytes(),
+		SigningKey:  d.signing.Public().(ed25519.PublicKey),
+		SignedPrekey: &SignedPrekey{
+			KeyID:     d.prekeyID,
+			PublicKey: pub,
+			Signature: ed25519.Sign(d.signing, pub),
+		},
+	}
+}
+
+type envelope struct {
+	Version    int            `json:"v"`
+	FromYUI    string         `json:"from"`
+	FromDevice string         `json:"from_device"`
+	SenderKey  []byte         `json:"sender_key"` // X25519 identity отправителя
+	Ephemeral  []byte         `json:"ephemeral"`
+	Recipients []recipientBox `json:"recipients"`
+}
+
+type recipientBox struct {
+	YUI        string `json:"yui"`
+	DeviceID   string `json:"device_id"`
+	PrekeyID   int64  `json:"prekey_id"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encrypt шифрует plaintext для каждого устройства из recipients.
+// Чтобы сообщение видели и другие свои устройства, добавьте их бандлы в recipients.
+func (d *Device) Encrypt(recipients []*Bundle, plaintext []byte) (string, error) {
+	if len(recipients) == 0 {
+		return "", fmt.Errorf("%w: no recipients", ErrInvalidBundle)
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	env := envelope{
+		Version:    envelopeVersion,
+		FromYUI:    d.YUI,
+		FromDevice: d.DeviceID,
+		SenderKey:  d.identity.PublicKey().Bytes(),
+		Ephemeral:  ephemeral.PublicKey().Bytes(),
+	}
+
+	for _, b := range recipients {
+		if err := VerifyBundle(b); err != nil {
+			return "", fmt.Errorf("device %s/%s: %w", b.YUI, b.DeviceID, err)
+		}
+		identity, err := ecdh.X25519().NewPublicKey(b.IdentityKey)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		prekey, err := ecdh.X25519().NewPublicKey(b.SignedPrekey.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		key, err := deriveKey(
+			dh(d.identity, prekey),
+			dh(ephemeral, identity),
+			dh(ephemeral, prekey),
+		)
+		if err != nil {
+			return "", err
+		}
+
+		ciphertext, err := crypto.Seal(key, plaintext, associatedData(env.SenderKey, b.IdentityKey))
+		if err != nil {
+			return "", err
+		}
+		env.Recipients = append(env.Recipients, recipientBox{
+			YUI:        b.YUI,
+			DeviceID:   b.DeviceID,
+			PrekeyID:   b.SignedPrekey.KeyID,
+			Ciphertext: ciphertext,
+		})
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Message — расшифрованное сообщение
+type Message struct {
+	FromYUI    string
+	FromDevice string
+	SenderKey  []byte // identity отправителя; сверяйте с каталогом через VerifySender
+	Plaintext  []byte
+}
+
+// VerifySender проверяет, что сообщение подписано identity-ключом
+// устройства отправителя из каталога (бандлы взяты из GET /api/keys/{yui})
+func (m *Message) VerifySender(bundles []*Bundle) bool {
+	for _, b := range bundles {
+		if b.DeviceID == m.FromDevice && string(b.IdentityKey) == string(m.SenderKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decrypt расшифровывает Content сообщения E2E_MESSAGE
+func (d *Device) Decrypt(content string) (*Message, error) {
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if env.Version != envelopeVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMalformed, env.Version)
+	}
+
+	var box *recipientBox
+	for i := range env.Recipients {
+		r := &env.Recipients[i]
+		if r.YUI == d.YUI && r.DeviceID == d.DeviceID {
+			box = r
+			break
+		}
+	}
+	if box == nil {
+		return nil, ErrNotForThisDevice
+	}
+
+	prekey, ok := d.prekeys[box.PrekeyID]
+	if !ok {
+		return nil, ErrUnknownPrekey
+	}
+	sender, err := ecdh.X25519().NewPublicKey(env.SenderKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(env.Ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	key, err := deriveKey(
+		dh(prekey, sender),
+		dh(d.identity, ephemeral),
+		dh(prekey, ephemeral),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := crypto.Open(key, box.Ciphertext, associatedData(env.SenderKey, d.identity.PublicKey().Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		FromYUI:    env.FromYUI,
+		FromDevice: env.FromDevice,
+		SenderKey:  env.SenderKey,
+		Plaintext:  plaintext,
+	}, nil
+}
+
+// dh возвращает общий секрет; ошибка (нулевая точка) превращается в nil и ловится в deriveKey
+func dh(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) []byte {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil
+	}
+	return secret
+}
+
+func deriveKey(secrets ...[]byte) ([]byte, error) {
+	var ikm []byte
+	for _, s := range secrets {
+		if s == nil {
+			return nil, fmt.Errorf("%w: low-order public key", ErrInvalidBundle)
+		}
+		ikm = append(ikm, s...)
+	}
+
+	key := make([]byte, crypto.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, nil, []byte(kdfInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// associatedData привязывает шифротекст к паре identity-ключей
+func associatedData(senderKey, recipientKey []byte) []byte {
+	return append(append([]byte{}, senderKey...), recipientKey...)
+}
+
+// Порядок поля Curve25519: p = 2^255 - 19
+var fieldP = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// identityFromSigningKey переводит Ed25519-ключ в X25519: u = (1 + y) / (1 - y) mod p
+func identityFromSigningKey(pub []byte) ([]byte, error) {
+	// y — little-endian, старший бит хранит знак x
+	le := make([]byte, 32)
+	for i := range pub {
+		le[31-i] = pub[i]
+	}
+	le[0] &= 0x7f
+	y := new(big.Int).SetBytes(le)
+	if y.Cmp(fieldP) >= 0 {
+		return nil, ErrInvalidBundle
+	}
+
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, fieldP)
+	if den.Sign() == 0 {
+		return nil, ErrInvalidBundle
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, den.ModInverse(den, fieldP))
+	u.Mod(u, fieldP)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	for i, j := 0, 31; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
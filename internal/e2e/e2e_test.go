@@ -0,0 +1,181 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func newTestDevice(t *testing.T, yui, deviceID string) *Device {
+	t.Helper()
+	d, err := NewDevice(yui, deviceID)
+	if err != nil {
+		t.Fatalf("NewDevice(%s, %s): %v", yui, deviceID, err)
+	}
+	return d
+}
+
+func TestRoundTrip(t *testing.T) {
+	alice := newTestDevice(t, "alice", "phone")
+	bob := newTestDevice(t, "bob", "laptop")
+	bobTablet := newTestDevice(t, "bob", "tablet")
+
+	content, err := alice.Encrypt([]*Bundle{bob.Bundle(), bobTablet.Bundle()}, []byte("hi bob"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	for _, d := range []*Device{bob, bobTablet} {
+		msg, err := d.Decrypt(content)
+		if err != nil {
+			t.Fatalf("%s/%s: Decrypt: %v", d.YUI, d.DeviceID, err)
+		}
+		if string(msg.Plaintext) != "hi bob" {
+			t.Errorf("%s/%s: plaintext = %q", d.YUI, d.DeviceID, msg.Plaintext)
+		}
+		if !msg.VerifySender([]*Bundle{alice.Bundle()}) {
+			t.Errorf("%s/%s: sender not verified", d.YUI, d.DeviceID)
+		}
+	}
+
+	if _, err := alice.Decrypt(content); !errors.Is(err, ErrNotForThisDevice) {
+		t.Errorf("sender decrypts own message: err = %v, want ErrNotForThisDevice", err)
+	}
+}
+
+func TestIdentityDerivedFromSigningKey(t *testing.T) {
+	d := newTestDevice(t, "alice", "phone")
+	b := d.Bundle()
+
+	identity, err := identityFromSigningKey(b.SigningKey)
+	if err != nil {
+		t.Fatalf("identityFromSigningKey: %v", err)
+	}
+	if !bytes.Equal(identity, b.IdentityKey) {
+		t.Fatalf("identity %x does not match signing key %x", b.IdentityKey, b.SigningKey)
+	}
+}
+
+func TestTamperedBundle(t *testing.T) {
+	alice := newTestDevice(t, "alice", "phone")
+	mallory := newTestDevice(t, "mallory", "phone")
+
+	tests := []struct {
+		name   string
+		tamper func(b *Bundle)
+		want   error
+	}{
+		{
+			name:   "foreign identity key",
+			tamper: func(b *Bundle) { b.IdentityKey = mallory.Bundle().IdentityKey },
+			want:   ErrIdentityMismatch,
+		},
+		{
+			// Сервер подставляет свой ключ подписи и prekey, оставляя identity жертвы
+			name: "foreign signing key and prekey",
+			tamper: func(b *Bundle) {
+				m := mallory.Bundle()
+				b.SigningKey = m.SigningKey
+				b.SignedPrekey = m.SignedPrekey
+			},
+			want: ErrIdentityMismatch,
+		},
+		{
+			name:   "foreign prekey",
+			tamper: func(b *Bundle) { b.SignedPrekey.PublicKey = mallory.Bundle().SignedPrekey.PublicKey },
+			want:   ErrBadSignature,
+		},
+		{
+			name:   "flipped signature bit",
+			tamper: func(b *Bundle) { b.SignedPrekey.Signature[0] ^= 1 },
+			want:   ErrBadSignature,
+		},
+		{
+			name:   "short identity key",
+			tamper: func(b *Bundle) { b.IdentityKey = b.IdentityKey[:31] },
+			want:   ErrInvalidBundle,
+		},
+		{
+			name:   "short signing key",
+			tamper: func(b *Bundle) { b.SigningKey = ed25519.PublicKey(b.SigningKey[:31]) },
+			want:   ErrInvalidBundle,
+		},
+		{
+			name:   "missing prekey",
+			tamper: func(b *Bundle) { b.SignedPrekey = nil },
+			want:   ErrInvalidBundle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bob := newTestDevice(t, "bob", "laptop")
+			b := bob.Bundle()
+			tt.tamper(b)
+
+			if err := VerifyBundle(b); !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyBundle: err = %v, want %v", err, tt.want)
+			}
+			if _, err := alice.Encrypt([]*Bundle{b}, []byte("secret")); !errors.Is(err, tt.want) {
+				t.Fatalf("Encrypt: err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTamperedMessage(t *testing.T) {
+	alice := newTestDevice(t, "alice", "phone")
+	bob := newTestDevice(t, "bob", "laptop")
+
+	content, err := alice.Encrypt([]*Bundle{bob.Bundle()}, []byte("hi bob"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	// Чужое устройство представляется alice/phone: расшифровать можно, но identity не из каталога
+	impostor := newTestDevice(t, "alice", "phone")
+	forged, err := impostor.Encrypt([]*Bundle{bob.Bundle()}, []byte("hi bob"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// Подмена identity отправителя в конверте ломает и ключ, и associated data
+	var env envelope
+	data, _ := base64.StdEncoding.DecodeString(content)
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	env.SenderKey = impostor.Bundle().IdentityKey
+	data, _ = json.Marshal(env)
+	swapped := base64.StdEncoding.EncodeToString(data)
+
+	env.SenderKey = alice.Bundle().IdentityKey
+	env.Recipients[0].Ciphertext[len(env.Recipients[0].Ciphertext)-1] ^= 1
+	data, _ = json.Marshal(env)
+	flipped := base64.StdEncoding.EncodeToString(data)
+
+	tests := []struct {
+		name    string
+		content string
+		verify  bool // сообщение расшифровано и отправитель сверен с каталогом alice
+	}{
+		{"original", content, true},
+		{"not base64", "%%%", false},
+		{"truncated", content[:len(content)/2], false},
+		{"forged sender", forged, false},
+		{"swapped sender key", swapped, false},
+		{"flipped ciphertext bit", flipped, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := bob.Decrypt(tt.content)
+			ok := err == nil && msg.VerifySender([]*Bundle{alice.Bundle()})
+			if ok != tt.verify {
+				t.Fatalf("decrypted and verified = %v (err %v), want %v", ok, err, tt.verify)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"yep-protocol/internal/auth"
+)
+
+type contextKey int
+
+const claimsKey contextKey = iota
+
+// ErrorResponse — единый формат ошибок API
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// WriteError отдаёт ошибку в JSON
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: code, Message: message})
+}
+
+// RequireAuth пропускает запрос только с валидным "Authorization: Bearer <jwt>"
+// и кладёт TokenClaims в контекст запроса
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			WriteError(w, http.StatusUnauthorized, "unauthorized", "missing bearer token")
+			return
+		}
+
+		claims, err := auth.ValidateToken(strings.TrimSpace(token))
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, "invalid_token", "invalid or expired token")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClaimsFromContext достаёт claims, положенные RequireAuth
+func ClaimsFromContext(ctx context.Context) (*auth.TokenClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*auth.TokenClaims)
+	return claims, ok
+}
+
+// OwnerYUI возвращает YUI, к ресурсам которого обращается запрос.
+// Пустой параметр означает "свои", чужой YUI — false (доступ запрещён).
+func OwnerYUI(r *http.Request, requested string) (string, bool) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		return "", false
+	}
+	if requested == "" || requested == claims.YUI {
+		return claims.YUI, true
+	}
+	return "", false
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Заголовки подписи запросов бота
+const (
+	BotTimestampHeader = "X-Yep-Timestamp"
+	BotNonceHeader     = "X-Yep-Nonce"
+	BotSignatureHeader = "X-Yep-Signature"
+)
+
+// Допустимое расхождение часов; nonce помним столько же
+const botSignatureSkew = 5 * time.Minute
+
+// botSignature = hex(HMAC-SHA256(secret, ts \n nonce \n METHOD \n path \n sha256hex(body)))
+func botSignature(secret, timestamp, nonce, method, path string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + method + "\n" + path + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignBotRequest подписывает запрос бота к YEP; body — то же тело, что уйдёт в запросе
+func SignBotRequest(r *http.Request, body []byte, secret string) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	r.Header.Set(BotTimestampHeader, timestamp)
+	r.Header.Set(BotNonceHeader, nonce)
+	r.Header.Set(BotSignatureHeader, botSignature(secret, timestamp, nonce, r.Method, r.URL.Path, body))
+}
+
+// nonceCache помнит использованные nonce, пока их timestamp ещё принимается
+type nonceCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// use возвращает false, если nonce уже был
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.pruned) > time.Minute {
+		for n, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.pruned = now
+	}
+
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	// Запрос с этим nonce примут максимум до ts+skew, с запасом помним 2*skew
+	c.seen[nonce] = now.Add(2 * botSignatureSkew)
+	return true
+}
+
+// RequireBotSignature пропускает только подписанные секретом бота запросы.
+// Повтор запроса (тот же nonce) и старые timestamp отклоняются.
+// Пустой secret — API бота выключен.
+func RequireBotSignature(secret string, next http.Handler) http.Handler {
+	nonces := &nonceCache{seen: make(map[string]time.Time)}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" {
+			WriteError(w, http.StatusServiceUnavailable, "not_configured", "bot API is not configured")
+			return
+		}
+
+		timestamp := r.Header.Get(BotTimestampHeader)
+		nonce := r.Header.Get(BotNonceHeader)
+		signature := r.Header.Get(BotSignatureHeader)
+		if timestamp == "" || nonce == "" || signature == "" {
+			WriteError(w, http.StatusUnauthorized, "unauthorized", "missing request signature")
+			return
+		}
+
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		now := time.Now()
+		if err != nil || now.Sub(time.Unix(ts, 0)).Abs() > botSignatureSkew {
+			WriteError(w, http.StatusUnauthorized, "invalid_signature", "request timestamp is out of range")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid_request", "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := botSignature(secret, timestamp, nonce, r.Method, r.URL.Path, body)
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			WriteError(w, http.StatusUnauthorized, "invalid_signature", "invalid request signature")
+			return
+		}
+
+		// Nonce учитываем только после проверки подписи, иначе чужие запросы засоряли бы кэш
+		if !nonces.use(nonce, now) {
+			WriteError(w, http.StatusUnauthorized, "replayed_request", "request was already processed")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBotSecret = "bot-secret"
+
+// echoHandler отвечает телом запроса: так видно, что после проверки подписи тело не потеряно
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.Copy(w, r.Body)
+})
+
+func signedBotRequest(method, path, body, secret string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	SignBotRequest(r, []byte(body), secret)
+	return r
+}
+
+// signAt подписывает запрос с заданными timestamp и nonce
+func signAt(r *http.Request, body, secret string, ts time.Time, nonce string) {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	r.Header.Set(BotTimestampHeader, timestamp)
+	r.Header.Set(BotNonceHeader, nonce)
+	r.Header.Set(BotSignatureHeader, botSignature(secret, timestamp, nonce, r.Method, r.URL.Path, []byte(body)))
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	return resp.Error
+}
+
+func TestRequireBotSignature(t *testing.T) {
+	const body = `{"phone_hash":"abc"}`
+
+	tests := []struct {
+		name       string
+		secret     string // секрет сервера
+		request    func() *http.Request
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "valid",
+			secret:     testBotSecret,
+			request:    func() *http.Request { return signedBotRequest("POST", "/api/telegram/code", body, testBotSecret) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "wrong secret",
+			secret:     testBotSecret,
+			request:    func() *http.Request { return signedBotRequest("POST", "/api/telegram/code", body, "other") },
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_signature",
+		},
+		{
+			name:   "body changed after signing",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/api/telegram/code", strings.NewReader(`{"phone_hash":"xyz"}`))
+				SignBotRequest(r, []byte(body), testBotSecret)
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_signature",
+		},
+		{
+			name:   "path changed after signing",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := signedBotRequest("POST", "/api/telegram/code", body, testBotSecret)
+				r.URL.Path = "/api/telegram/check"
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_signature",
+		},
+		{
+			name:   "method changed after signing",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := signedBotRequest("POST", "/api/telegram/code", body, testBotSecret)
+				r.Method = "PUT"
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_signature",
+		},
+		{
+			name:   "nonce changed after signing",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := signedBotRequest("POST", "/api/telegram/code", body, testBotSecret)
+				r.Header.Set(BotNonceHeader, "other-nonce")
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_signature",
+		},
+		{
+			name:   "stale timestamp",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/api/telegram/code", strings.NewReader(body))
+				signAt(r, body, testBotSecret, time.Now().Add(-botSignatureSkew-time.Minute), "n-stale")
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_signature",
+		},
+		{
+			name:   "future timestamp",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/api/telegram/code", strings.NewReader(body))
+				signAt(r, body, testBotSecret, time.Now().Add(botSignatureSkew+time.Minute), "n-future")
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "invalid_signature",
+		},
+		{
+			name:   "timestamp within skew",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/api/telegram/code", strings.NewReader(body))
+				signAt(r, body, testBotSecret, time.Now().Add(-botSignatureSkew+time.Minute), "n-skew")
+				return r
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "missing signature",
+			secret: testBotSecret,
+			request: func() *http.Request {
+				r := signedBotRequest("POST", "/api/telegram/code", body, testBotSecret)
+				r.Header.Del(BotSignatureHeader)
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name:       "bot API not configured",
+			secret:     "",
+			request:    func() *http.Request { return signedBotRequest("POST", "/api/telegram/code", body, "") },
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   "not_configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			RequireBotSignature(tt.secret, echoHandler).ServeHTTP(rec, tt.request())
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, rec); code != tt.wantCode {
+					t.Fatalf("error = %q, want %q", code, tt.wantCode)
+				}
+			} else if rec.Body.String() != body {
+				t.Fatalf("handler got body %q, want %q", rec.Body, body)
+			}
+		})
+	}
+}
+
+func TestRequireBotSignatureReplay(t *testing.T) {
+	const body = `{"phone_hash":"abc"}`
+	handler := RequireBotSignature(testBotSecret, echoHandler)
+
+	first := signedBotRequest("POST", "/api/telegram/code", body, testBotSecret)
+	replay := httptest.NewRequest("POST", "/api/telegram/code", strings.NewReader(body))
+	replay.Header = first.Header.Clone()
+
+	// Неверная подпись с тем же nonce не должна «сжечь» nonce настоящего запроса
+	forged := httptest.NewRequest("POST", "/api/telegram/code", strings.NewReader(body))
+	forged.Header = first.Header.Clone()
+	forged.Header.Set(BotSignatureHeader, strings.Repeat("0", 64))
+
+	steps := []struct {
+		name       string
+		request    *http.Request
+		wantStatus int
+		wantCode   string
+	}{
+		{"forged signature, same nonce", forged, http.StatusUnauthorized, "invalid_signature"},
+		{"original", first, http.StatusOK, ""},
+		{"replay", replay, http.StatusUnauthorized, "replayed_request"},
+		{"fresh nonce", signedBotRequest("POST", "/api/telegram/code", body, testBotSecret), http.StatusOK, ""},
+	}
+	for _, s := range steps {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, s.request)
+		if rec.Code != s.wantStatus {
+			t.Fatalf("%s: status = %d, want %d (%s)", s.name, rec.Code, s.wantStatus, rec.Body)
+		}
+		if s.wantCode != "" {
+			if code := errorCode(t, rec); code != s.wantCode {
+				t.Fatalf("%s: error = %q, want %q", s.name, code, s.wantCode)
+			}
+		}
+	}
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	c := &nonceCache{seen: make(map[string]time.Time)}
+	start := time.Now()
+
+	tests := []struct {
+		name  string
+		nonce string
+		at    time.Duration // от start
+		want  bool
+	}{
+		{"first use", "a", 0, true},
+		{"reuse", "a", time.Second, false},
+		{"reuse while timestamp still accepted", "a", botSignatureSkew, false},
+		{"other nonce", "b", time.Second, true},
+		{"reuse after it can no longer be accepted", "a", 2*botSignatureSkew + time.Minute + time.Second, true},
+	}
+	for _, tt := range tests {
+		if got := c.use(tt.nonce, start.Add(tt.at)); got != tt.want {
+			t.Fatalf("%s: use(%q) = %v, want %v", tt.name, tt.nonce, got, tt.want)
+		}
+	}
+}
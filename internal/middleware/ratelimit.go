@@ -1,25 +1,44 @@
 package middleware
 
 import (
-	"golang.org/x/time/rate"
+	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
-	_ "time"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
 type IPRateLimiter struct {
-	ips map[string]*rate.Limiter
-	mu  *sync.RWMutex
-	r   rate.Limit
-	b   int
+	ips       map[string]*ipLimiter
+	mu        *sync.RWMutex
+	r         rate.Limit
+	b         int
+	ttl       time.Duration // простаивающий дольше лимитер уже полностью восстановился — его можно удалить
+	lastSweep time.Time
+}
+
+type ipLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
 }
 
 func NewIPRateLimiter(r rate.Limit, b int) *IPRateLimiter {
+	// Время полного восстановления burst: удаление после него ничего не меняет для клиента
+	ttl := time.Minute
+	if r > 0 && r != rate.Inf {
+		if refill := time.Duration(float64(b) / float64(r) * float64(time.Second)); refill > ttl {
+			ttl = refill
+		}
+	}
 	return &IPRateLimiter{
-		ips: make(map[string]*rate.Limiter),
+		ips: make(map[string]*ipLimiter),
 		mu:  &sync.RWMutex{},
 		r:   r,
 		b:   b,
+		ttl: ttl,
 	}
 }
 
@@ -27,20 +46,31 @@ func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
+	now := time.Now()
+	// Чистим простаивающие записи не чаще раза в минуту
+	if now.Sub(i.lastSweep) > time.Minute {
+		for key, l := range i.ips {
+			if now.Sub(l.lastSeen) > i.ttl {
+				delete(i.ips, key)
+			}
+		}
+		i.lastSweep = now
+	}
+
 	limiter, exists := i.ips[ip]
 	if !exists {
-		// 10 запросов в секунду максимум
-		limiter = rate.NewLimiter(i.r, i.b)
+		limiter = &ipLimiter{Limiter: rate.NewLimiter(i.r, i.b)}
 		i.ips[ip] = limiter
 	}
+	limiter.lastSeen = now
 
-	return limiter
+	return limiter.Limiter
 }
 
 func RateLimitMiddleware(limiter *IPRateLimiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			limiter := limiter.GetLimiter(r.RemoteAddr)
+			limiter := limiter.GetLimiter(ClientIP(r))
 
 			if !limiter.Allow() {
 				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
@@ -51,3 +81,78 @@ func RateLimitMiddleware(limiter *IPRateLimiter) func(http.Handler) http.Handler
 		})
 	}
 }
+
+// Сети прокси, которым разрешено сообщать адрес клиента в X-Forwarded-For
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies задаёт доверенные прокси: IP или CIDR через запятую.
+// Пусто — X-Forwarded-For игнорируется, адрес клиента берётся из соединения.
+func SetTrustedProxies(list string) error {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP — адрес клиента. X-Forwarded-For учитывается, только если соединение пришло от
+// доверенного прокси: берём самый правый адрес, который не принадлежит доверенным прокси.
+// Всегда возвращает нормализованный IP (или пустую строку), а не произвольный текст из заголовка.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil {
+		return ""
+	}
+	if !isTrustedProxy(remote) {
+		return remote.String()
+	}
+
+	client := remote
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Мусор в цепочке: левее доверять нечему
+			break
+		}
+		client = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client.String()
+}
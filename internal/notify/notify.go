@@ -0,0 +1,98 @@
+// Package notify доставляет пользователю служебные сообщения
+// (сброс пароля, подтверждение email) через Telegram, почту или лог.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+// ErrNoChannel — у пользователя нет канала, через который этот нотификатор может доставить сообщение
+var ErrNoChannel = errors.New("no delivery channel for user")
+
+// Notifier доставляет сообщение пользователю
+type Notifier interface {
+	Notify(user *core.User, subject, text string) error
+}
+
+// Chain пробует нотификаторы по порядку, пока один не доставит
+type Chain []Notifier
+
+func (c Chain) Notify(user *core.User, subject, text string) error {
+	err := ErrNoChannel
+	for _, n := range c {
+		if err = n.Notify(user, subject, text); err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrNoChannel) {
+			log.Printf("Notifier %T failed: %v", n, err)
+		}
+	}
+	return err
+}
+
+// Log пишет сообщение в лог сервера — для разработки, когда других каналов нет
+type Log struct{}
+
+func (Log) Notify(user *core.User, subject, text string) error {
+	log.Printf("📨 [%s] %s: %s", user.YUI, subject, text)
+	return nil
+}
+
+// SMTP отправляет письма; без логина/пароля подходит для MailHog и подобных локальных серверов
+type SMTP struct {
+	addr     string // host:port
+	from     string
+	username string
+	password string
+}
+
+func NewSMTP(addr, from, username, password string) *SMTP {
+	return &SMTP{addr: addr, from: from, username: username, password: password}
+}
+
+func (s *SMTP) Notify(user *core.User, subject, text string) error {
+	if user.Email == "" || strings.HasSuffix(user.Email, core.TelegramEmailDomain) ||
+		strings.HasSuffix(user.Email, core.ReleasedEmailDomain) {
+		return ErrNoChannel
+	}
+	return s.Send(user.Email, subject, text)
+}
+
+// Send отправляет письмо на произвольный адрес
+func (s *SMTP) Send(to, subject, text string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		text,
+	}, "\r\n")
+
+	// В MAIL FROM — голый адрес, в заголовке From может быть "Имя <адрес>"
+	sender, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP from address: %w", err)
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, _ := net.SplitHostPort(s.addr)
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	return smtp.SendMail(s.addr, auth, sender.Address, []string{to}, []byte(msg))
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrEmailTokenInvalid = errors.New("invalid or expired email verification token")
+
+// CreateEmailVerification сохраняет хэши ссылки и кода подтверждения адреса
+func (db *DB) CreateEmailVerification(tokenHash, codeHash, yui, email string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(`
+        INSERT INTO email_verifications (token_hash, code_hash, yui, email, expires_at)
+        VALUES ($1, $2, $3, $4, $5)`,
+		tokenHash, codeHash, yui, email, expiresAt,
+	)
+	return err
+}
+
+// CountEmailVerifications — сколько писем с подтверждением ушло пользователю с момента since
+func (db *DB) CountEmailVerifications(yui string, since time.Time) (int, error) {
+	var n int
+	err := db.conn.QueryRow(
+		`SELECT COUNT(*) FROM email_verifications WHERE yui = $1 AND created_at > $2`,
+		yui, since,
+	).Scan(&n)
+	return n, err
+}
+
+// HasPendingEmailVerification — есть ли неиспользованное и не истёкшее письмо на текущий адрес
+func (db *DB) HasPendingEmailVerification(yui, email string) (bool, error) {
+	var exists bool
+	err := db.conn.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM email_verifications
+            WHERE yui = $1 AND email = $2 AND used_at IS NULL AND expires_at > $3
+        )`,
+		yui, email, time.Now(),
+	).Scan(&exists)
+	return exists, err
+}
+
+// ConsumeEmailToken подтверждает адрес по токену из ссылки. Возвращает YUI владельца.
+func (db *DB) ConsumeEmailToken(tokenHash string) (string, error) {
+	now := time.Now()
+	return db.consumeEmailVerification(now, `
+        UPDATE email_verifications SET used_at = $2
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+        RETURNING yui, email`,
+		tokenHash, now,
+	)
+}
+
+// ConsumeEmailCode подтверждает адрес пользователя по коду из последнего письма
+func (db *DB) ConsumeEmailCode(yui, codeHash string) error {
+	now := time.Now()
+	_, err := db.consumeEmailVerification(now, `
+        UPDATE email_verifications SET used_at = $3
+        WHERE token_hash = (
+            SELECT token_hash FROM email_verifications
+            WHERE yui = $1 AND used_at IS NULL AND expires_at > $3
+            ORDER BY created_at DESC LIMIT 1
+        ) AND code_hash = $2
+        RETURNING yui, email`,
+		yui, codeHash, now,
+	)
+	return err
+}
+
+// consumeEmailVerification гасит запись (query ... RETURNING yui, email) и остальные запросы пользователя,
+// отмечает email подтверждённым — только если адрес в аккаунте с тех пор не сменился
+func (db *DB) consumeEmailVerification(now time.Time, query string, args ...interface{}) (string, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var yui, email string
+	err = tx.QueryRow(query, args...).Scan(&yui, &email)
+	if err == sql.ErrNoRows {
+		return "", ErrEmailTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	res, err := tx.Exec(
+		`UPDATE users SET email_verified_at = $3 WHERE yui = $1 AND email = $2`,
+		yui, email, now,
+	)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrEmailTokenInvalid
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE email_verifications SET used_at = $2 WHERE yui = $1 AND used_at IS NULL`,
+		yui, now,
+	); err != nil {
+		return "", err
+	}
+
+	return yui, tx.Commit()
+}
+
+// ReleaseUnverifiedEmail освобождает адрес, занятый аккаунтом, который так и не подтвердил его
+// и не активирован до createdBefore: чужой адрес нельзя «занять» навсегда.
+// Адрес в таком аккаунте заменяется заглушкой <yui><placeholderDomain>.
+func (db *DB) ReleaseUnverifiedEmail(email, placeholderDomain string, createdBefore time.Time) (bool, error) {
+	res, err := db.conn.Exec(`
+        UPDATE users SET email = yui || $2
+        WHERE email = $1 AND email_verified_at IS NULL AND is_active = false AND created_at < $3`,
+		email, placeholderDomain, createdBefore,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteExpiredEmailVerifications чистит старые записи (нужны только для лимита писем)
+func (db *DB) DeleteExpiredEmailVerifications(olderThan time.Time) error {
+	_, err := db.conn.Exec(`DELETE FROM email_verifications WHERE created_at < $1`, olderThan)
+	return err
+}
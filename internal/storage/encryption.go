@@ -0,0 +1,377 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"yep-protocol/internal/crypto"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Сколько сообщений перешифровываем за один проход фоновой задачи
+const reencryptBatchSize = 500
+
+// ErrUndecryptable — сообщение повреждено или его ключ данных удалён; повтор не поможет
+var ErrUndecryptable = errors.New("message cannot be decrypted")
+
+// UndecryptableContent отдаётся вместо Content, который не удалось расшифровать
+const UndecryptableContent = "[message could not be decrypted]"
+
+// DataKey — ключ данных диалога, хранится зашифрованным KEK'ом
+type DataKey struct {
+	ID           string    `bson:"_id"`
+	Conversation string    `bson:"conversation"`
+	KEKID        string    `bson:"kek_id"`
+	WrappedKey   []byte    `bson:"wrapped_key"`
+	Active       bool      `bson:"active"`
+	CreatedAt    time.Time `bson:"created_at"`
+}
+
+// MessageCipher шифрует содержимое сообщений в MongoDB конвертным шифрованием:
+// у каждого диалога свой ключ данных, ключи данных зашифрованы KEK'ом провайдера ключей.
+type MessageCipher struct {
+	dataKeys *mongo.Collection
+	messages *mongo.Collection
+
+	keys crypto.KeyProvider // KEK: текущим шифруются новые ключи данных, старые — для расшифровки
+
+	mu    sync.Mutex
+	cache map[string][]byte // id ключа данных -> открытый ключ
+}
+
+// NewMessageCipher включает шифрование сообщений ключами провайдера
+func (m *MongoDB) NewMessageCipher(keys crypto.KeyProvider) (*MessageCipher, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dataKeys := m.database.Collection("data_keys")
+	_, err := dataKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// Не больше одного активного ключа на диалог
+			Keys:    bson.D{{Key: "conversation", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"active": true}),
+		},
+		{Keys: bson.D{{Key: "kek_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data key indexes: %w", err)
+	}
+
+	_, err = m.messages.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "encrypted", Value: 1}, {Key: "key_id", Value: 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key_id index: %w", err)
+	}
+
+	return &MessageCipher{
+		dataKeys: dataKeys,
+		messages: m.messages,
+		keys:     keys,
+		cache:    make(map[string][]byte),
+	}, nil
+}
+
+// SetCipher включает прозрачное шифрование при записи и расшифровку при чтении
+func (m *MongoDB) SetCipher(c *MessageCipher) {
+	m.cipher = c
+}
+
+// keyScope — область ключа данных: диалог, для старых сообщений без диалога — общий ключ
+func keyScope(msg *MongoMessage) string {
+	if msg.Conversation == "" {
+		return "global"
+	}
+	return msg.Conversation
+}
+
+// encrypt шифрует Content активным ключом диалога
+func (c *MessageCipher) encrypt(ctx context.Context, msg *MongoMessage) error {
+	keyID, dek, err := c.activeKey(ctx, keyScope(msg))
+	if err != nil {
+		return err
+	}
+
+	sealed, err := crypto.Seal(dek, []byte(msg.Content), []byte(msg.Conversation))
+	if err != nil {
+		return err
+	}
+
+	msg.Content = base64.StdEncoding.EncodeToString(sealed)
+	msg.Encrypted = true
+	msg.KeyID = keyID
+	return nil
+}
+
+// decrypt расшифровывает Content, если сообщение зашифровано
+func (c *MessageCipher) decrypt(ctx context.Context, msg *MongoMessage) error {
+	if !msg.Encrypted {
+		return nil
+	}
+
+	dek, err := c.key(ctx, msg.KeyID)
+	if err != nil {
+		return fmt.Errorf("message %s: %w", msg.ID.Hex(), err)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(msg.Content)
+	if err != nil {
+		return fmt.Errorf("message %s: %w: %v", msg.ID.Hex(), ErrUndecryptable, err)
+	}
+
+	plaintext, err := crypto.Open(dek, sealed, []byte(msg.Conversation))
+	if err != nil {
+		return fmt.Errorf("message %s: %w: %v", msg.ID.Hex(), ErrUndecryptable, err)
+	}
+
+	msg.Content = string(plaintext)
+	msg.Encrypted = false
+	msg.KeyID = ""
+	return nil
+}
+
+// activeKey возвращает активный ключ данных диалога, создавая его при необходимости
+func (c *MessageCipher) activeKey(ctx context.Context, scope string) (string, []byte, error) {
+	var dk DataKey
+	err := c.dataKeys.FindOne(ctx, bson.M{"conversation": scope, "active": true}).Decode(&dk)
+	if err == nil && dk.KEKID == c.keys.CurrentKeyID() {
+		dek, err := c.key(ctx, dk.ID)
+		return dk.ID, dek, err
+	}
+	if err == nil {
+		// Ключ под старым KEK: пишем уже новым, старые сообщения перешифрует RotateKeys
+		if err := c.retire(ctx, dk.ID); err != nil {
+			return "", nil, err
+		}
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil, err
+	}
+
+	return c.createKey(ctx, scope)
+}
+
+func (c *MessageCipher) createKey(ctx context.Context, scope string) (string, []byte, error) {
+	dek, err := crypto.GenerateKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyID := fmt.Sprintf("%s#%d", scope, time.Now().UnixNano())
+	kekID := c.keys.CurrentKeyID()
+	// id ключа данных — AAD: обёртку нельзя переставить на другой ключ
+	wrapped, err := c.keys.Wrap(kekID, dek, []byte(keyID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, err = c.dataKeys.InsertOne(ctx, DataKey{
+		ID:           keyID,
+		Conversation: scope,
+		KEKID:        kekID,
+		WrappedKey:   wrapped,
+		Active:       true,
+		CreatedAt:    time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// Параллельно уже создали активный ключ — используем его
+		var dk DataKey
+		if err := c.dataKeys.FindOne(ctx, bson.M{"conversation": scope, "active": true}).Decode(&dk); err != nil {
+			return "", nil, err
+		}
+		dek, err := c.key(ctx, dk.ID)
+		return dk.ID, dek, err
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	c.mu.Lock()
+	c.cache[keyID] = dek
+	c.mu.Unlock()
+
+	log.Printf("🔑 Created data key %s (KEK %s)", keyID, kekID)
+	return keyID, dek, nil
+}
+
+// key возвращает открытый ключ данных по id.
+// Удалённый ключ данных, удалённый KEK и повреждённая обёртка — ErrUndecryptable:
+// повтор не поможет. Остальные ошибки (база, KMS недоступен) — временные.
+func (c *MessageCipher) key(ctx context.Context, keyID string) ([]byte, error) {
+	c.mu.Lock()
+	dek, ok := c.cache[keyID]
+	c.mu.Unlock()
+	if ok {
+		return dek, nil
+	}
+
+	var dk DataKey
+	err := c.dataKeys.FindOne(ctx, bson.M{"_id": keyID}).Decode(&dk)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("data key %s: %w: %v", keyID, ErrUndecryptable, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("data key %s: %w", keyID, err)
+	}
+
+	dek, err = c.keys.Unwrap(dk.KEKID, dk.WrappedKey, []byte(dk.ID))
+	if errors.Is(err, crypto.ErrKeyNotFound) || errors.Is(err, crypto.ErrAuthFailed) || errors.Is(err, crypto.ErrCiphertextTooShort) {
+		return nil, fmt.Errorf("data key %s (KEK %s): %w: %v", keyID, dk.KEKID, ErrUndecryptable, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("data key %s (KEK %s): %w", keyID, dk.KEKID, err)
+	}
+
+	c.mu.Lock()
+	c.cache[keyID] = dek
+	c.mu.Unlock()
+	return dek, nil
+}
+
+func (c *MessageCipher) retire(ctx context.Context, keyID string) error {
+	_, err := c.dataKeys.UpdateOne(ctx, bson.M{"_id": keyID}, bson.M{"$set": bson.M{"active": false}})
+	return err
+}
+
+// RotateKeys — один проход фоновой ротации:
+//  1. активные ключи данных под старым KEK выводятся из оборота;
+//  2. сообщения на неактивных ключах и открытые сообщения шифруются активным ключом диалога;
+//  3. неактивные ключи без сообщений удаляются.
+//
+// Нерасшифровываемые сообщения помечаются rotation_error и дальше не участвуют:
+// иначе они занимали бы каждую пачку и держали старый ключ. Ошибки KMS и базы
+// прерывают проход — его повторит следующий запуск.
+//
+// Возвращает число перешифрованных сообщений.
+func (c *MessageCipher) RotateKeys(ctx context.Context) (int, error) {
+	// 1. Ключи под старым KEK
+	if _, err := c.dataKeys.UpdateMany(ctx,
+		bson.M{"active": true, "kek_id": bson.M{"$ne": c.keys.CurrentKeyID()}},
+		bson.M{"$set": bson.M{"active": false}},
+	); err != nil {
+		return 0, err
+	}
+
+	retired, err := c.inactiveKeyIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// 2. Сообщения, которые надо (пере)шифровать
+	filter := bson.M{
+		"rotation_error": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"encrypted": false},
+			{"encrypted": true, "key_id": bson.M{"$in": retired}},
+		},
+	}
+	cursor, err := c.messages.Find(ctx, filter, options.Find().SetLimit(reencryptBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	var batch []*MongoMessage
+	if err := cursor.All(ctx, &batch); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, msg := range batch {
+		oldKeyID, oldEncrypted := msg.KeyID, msg.Encrypted
+		if err := c.decrypt(ctx, msg); err != nil {
+			if !errors.Is(err, ErrUndecryptable) {
+				return done, err
+			}
+			log.Printf("⚠️ Skipping %s in rotation: %v", msg.ID.Hex(), err)
+			if _, err := c.messages.UpdateOne(ctx, bson.M{"_id": msg.ID}, bson.M{"$set": bson.M{
+				"rotation_error": err.Error(),
+			}}); err != nil {
+				return done, err
+			}
+			continue
+		}
+		if err := c.encrypt(ctx, msg); err != nil {
+			return done, err
+		}
+
+		// Обновляем, только если сообщение не изменилось с момента чтения
+		match := bson.M{"_id": msg.ID, "encrypted": oldEncrypted}
+		if oldEncrypted {
+			match["key_id"] = oldKeyID
+		}
+		if _, err := c.messages.UpdateOne(ctx, match, bson.M{"$set": bson.M{
+			"content":   msg.Content,
+			"encrypted": true,
+			"key_id":    msg.KeyID,
+		}}); err != nil {
+			return done, err
+		}
+		done++
+	}
+
+	// 3. Неактивные ключи, на которые больше не ссылаются сообщения (кроме помеченных rotation_error)
+	for _, keyID := range retired {
+		n, err := c.messages.CountDocuments(ctx,
+			bson.M{"key_id": keyID, "rotation_error": bson.M{"$exists": false}},
+			options.Count().SetLimit(1),
+		)
+		if err != nil {
+			return done, err
+		}
+		if n == 0 {
+			if _, err := c.dataKeys.DeleteOne(ctx, bson.M{"_id": keyID, "active": false}); err != nil {
+				return done, err
+			}
+			c.mu.Lock()
+			delete(c.cache, keyID)
+			c.mu.Unlock()
+		}
+	}
+
+	return done, nil
+}
+
+func (c *MessageCipher) inactiveKeyIDs(ctx context.Context) ([]string, error) {
+	cursor, err := c.dataKeys.Find(ctx, bson.M{"active": false})
+	if err != nil {
+		return nil, err
+	}
+	var keys []DataKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return ids, nil
+}
+
+// StartRotation запускает фоновую ротацию с заданным интервалом.
+// Пока есть работа, проходы идут подряд, без ожидания интервала.
+func (c *MessageCipher) StartRotation(interval time.Duration) {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := c.RotateKeys(ctx)
+			cancel()
+
+			if err != nil {
+				log.Printf("Key rotation failed: %v", err)
+			} else if n > 0 {
+				log.Printf("🔑 Re-encrypted %d messages", n)
+			}
+
+			if err != nil || n < reencryptBatchSize {
+				time.Sleep(interval)
+			}
+		}
+	}()
+}
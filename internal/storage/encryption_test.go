@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"yep-protocol/internal/crypto"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Тесты MessageCipher идут против mock-развёртывания mtest: сервер не нужен,
+// ответы базы задаются по порядку команд.
+
+func testKEKs(t *testing.T, current string, ids ...string) *crypto.StaticKeys {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		keys[id] = key
+	}
+	p, err := crypto.NewStaticKeys(current, keys)
+	if err != nil {
+		t.Fatalf("NewStaticKeys: %v", err)
+	}
+	return p
+}
+
+func newTestCipher(mt *mtest.T, keys crypto.KeyProvider) *MessageCipher {
+	return &MessageCipher{
+		dataKeys: mt.DB.Collection("data_keys"),
+		messages: mt.DB.Collection("messages"),
+		keys:     keys,
+		cache:    make(map[string][]byte),
+	}
+}
+
+// newDataKey создаёт ключ данных под KEK kekID так же, как createKey
+func newDataKey(t *testing.T, keys crypto.KeyProvider, kekID, id, conversation string, active bool) (DataKey, []byte) {
+	t.Helper()
+	dek, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	wrapped, err := keys.Wrap(kekID, dek, []byte(id))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	return DataKey{
+		ID:           id,
+		Conversation: conversation,
+		KEKID:        kekID,
+		WrappedKey:   wrapped,
+		Active:       active,
+		CreatedAt:    time.Now(),
+	}, dek
+}
+
+func toDoc(t *testing.T, v interface{}) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatalf("bson.Marshal: %v", err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("bson.Unmarshal: %v", err)
+	}
+	return doc
+}
+
+// found — ответ на FindOne/Find; без документов — «ничего не найдено»
+func found(t *testing.T, coll string, docs ...interface{}) bson.D {
+	t.Helper()
+	batch := make([]bson.D, 0, len(docs))
+	for _, d := range docs {
+		batch = append(batch, toDoc(t, d))
+	}
+	return mtest.CreateCursorResponse(0, "yep_hub."+coll, mtest.FirstBatch, batch...)
+}
+
+func written() bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+}
+
+func countCommands(mt *mtest.T, name string) int {
+	n := 0
+	for _, e := range mt.GetAllStartedEvents() {
+		if e.CommandName == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMessageCipherRoundTrip(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("encrypt and decrypt", func(mt *mtest.T) {
+		keys := testKEKs(t, "k1", "k1")
+		c := newTestCipher(mt, keys)
+		dk, _ := newDataKey(t, keys, "k1", "dm:alice:bob#1", "dm:alice:bob", true)
+		mt.AddMockResponses(
+			found(t, "data_keys", dk), // activeKey
+			found(t, "data_keys", dk), // key: ключ ещё не в кэше
+		)
+
+		msg := &MongoMessage{ID: primitive.NewObjectID(), Conversation: "dm:alice:bob", Content: "hello bob"}
+		if err := c.encrypt(context.Background(), msg); err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if !msg.Encrypted || msg.KeyID != dk.ID || msg.Content == "hello bob" {
+			t.Fatalf("encrypted message = %+v", msg)
+		}
+
+		// Тот же шифротекст в другом диалоге не расшифровывается: диалог — AAD
+		moved := *msg
+		moved.Conversation = "dm:alice:mallory"
+		if err := c.decrypt(context.Background(), &moved); !errors.Is(err, ErrUndecryptable) {
+			t.Fatalf("decrypt in another conversation: err = %v, want ErrUndecryptable", err)
+		}
+
+		if err := c.decrypt(context.Background(), msg); err != nil {
+			t.Fatalf("decrypt: %v", err)
+		}
+		if msg.Content != "hello bob" || msg.Encrypted || msg.KeyID != "" {
+			t.Fatalf("decrypted message = %+v", msg)
+		}
+	})
+
+	mt.Run("new key under current KEK", func(mt *mtest.T) {
+		keys := testKEKs(t, "k2", "k1", "k2")
+		c := newTestCipher(mt, keys)
+		old, _ := newDataKey(t, keys, "k1", "room:general#1", "room:general", true)
+		mt.AddMockResponses(
+			found(t, "data_keys", old), // activeKey: активный ключ под старым KEK
+			written(),                  // retire
+			written(),                  // insert нового ключа
+		)
+
+		msg := &MongoMessage{ID: primitive.NewObjectID(), Conversation: "room:general", Content: "hi all"}
+		if err := c.encrypt(context.Background(), msg); err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		if msg.KeyID == old.ID {
+			t.Fatal("message encrypted with a data key under the retired KEK")
+		}
+		// Новый ключ уже в кэше: расшифровка не идёт в базу
+		if err := c.decrypt(context.Background(), msg); err != nil || msg.Content != "hi all" {
+			t.Fatalf("decrypt = %q, %v", msg.Content, err)
+		}
+	})
+}
+
+func TestMessageCipherCachesDataKeys(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("one lookup per key", func(mt *mtest.T) {
+		keys := testKEKs(t, "k1", "k1")
+		c := newTestCipher(mt, keys)
+		dk, dek := newDataKey(t, keys, "k1", "dm:alice:bob#1", "dm:alice:bob", true)
+		// Ответ один: повторное обращение к базе упадёт с "no responses remaining"
+		mt.AddMockResponses(found(t, "data_keys", dk))
+
+		for i := 0; i < 3; i++ {
+			got, err := c.key(context.Background(), dk.ID)
+			if err != nil {
+				t.Fatalf("key #%d: %v", i+1, err)
+			}
+			if string(got) != string(dek) {
+				t.Fatalf("key #%d returned another key", i+1)
+			}
+		}
+		if n := countCommands(mt, "find"); n != 1 {
+			t.Fatalf("find sent %d times, want 1", n)
+		}
+	})
+}
+
+// failingKeys — провайдер, у которого не работает Unwrap (например, KMS недоступен)
+type failingKeys struct {
+	crypto.KeyProvider
+	err error
+}
+
+func (f failingKeys) Unwrap(string, []byte, []byte) ([]byte, error) {
+	return nil, f.err
+}
+
+func TestMessageCipherKeyErrors(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	keys := testKEKs(t, "k2", "k1", "k2")
+
+	tests := []struct {
+		name          string
+		keys          crypto.KeyProvider
+		response      func(dk DataKey) bson.D
+		undecryptable bool
+	}{
+		{
+			name:          "data key deleted",
+			keys:          keys,
+			response:      func(DataKey) bson.D { return found(t, "data_keys") },
+			undecryptable: true,
+		},
+		{
+			name: "KEK removed",
+			keys: keys,
+			response: func(dk DataKey) bson.D {
+				dk.KEKID = "k0"
+				return found(t, "data_keys", dk)
+			},
+			undecryptable: true,
+		},
+		{
+			name: "wrapped key tampered",
+			keys: keys,
+			response: func(dk DataKey) bson.D {
+				dk.WrappedKey[len(dk.WrappedKey)-1] ^= 1
+				return found(t, "data_keys", dk)
+			},
+			undecryptable: true,
+		},
+		{
+			name: "wrapped key truncated",
+			keys: keys,
+			response: func(dk DataKey) bson.D {
+				dk.WrappedKey = dk.WrappedKey[:8]
+				return found(t, "data_keys", dk)
+			},
+			undecryptable: true,
+		},
+		{
+			name: "wrapped under another key id",
+			keys: keys,
+			response: func(dk DataKey) bson.D {
+				dk.ID = "dm:alice:bob#2"
+				return found(t, "data_keys", dk)
+			},
+			undecryptable: true,
+		},
+		{
+			name: "database error",
+			keys: keys,
+			response: func(DataKey) bson.D {
+				return mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Name: "ShutdownInProgress", Message: "shutting down"})
+			},
+		},
+		{
+			name:     "KMS unavailable",
+			keys:     failingKeys{KeyProvider: keys, err: errors.New("KMS /v1/unwrap: status 503")},
+			response: func(dk DataKey) bson.D { return found(t, "data_keys", dk) },
+		},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			dk, _ := newDataKey(t, keys, "k1", "dm:alice:bob#1", "dm:alice:bob", false)
+			mt.AddMockResponses(tt.response(dk))
+
+			_, err := newTestCipher(mt, tt.keys).key(context.Background(), "dm:alice:bob#1")
+			if err == nil {
+				t.Fatal("key: want error")
+			}
+			if errors.Is(err, ErrUndecryptable) != tt.undecryptable {
+				t.Fatalf("key: err = %v, undecryptable = %v", err, tt.undecryptable)
+			}
+		})
+	}
+}
+
+// update — одна команда update: фильтр и $set
+type update struct {
+	filter bson.Raw
+	set    bson.Raw
+}
+
+func updates(mt *mtest.T) []update {
+	var out []update
+	for _, e := range mt.GetAllStartedEvents() {
+		if e.CommandName != "update" {
+			continue
+		}
+		values, _ := e.Command.Lookup("updates").Array().Values()
+		for _, v := range values {
+			doc := v.Document()
+			out = append(out, update{filter: doc.Lookup("q").Document(), set: doc.Lookup("u", "$set").Document()})
+		}
+	}
+	return out
+}
+
+func TestRotateKeys(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("retired, unencrypted and undecryptable messages", func(mt *mtest.T) {
+		keys := testKEKs(t, "k2", "k1", "k2")
+		c := newTestCipher(mt, keys)
+		const conv = "dm:alice:bob"
+
+		retired, retiredDEK := newDataKey(t, keys, "k1", conv+"#1", conv, false)
+		lost, _ := newDataKey(t, keys, "k1", conv+"#0", conv, false)
+		lost.KEKID = "k0" // KEK удалён из конфигурации
+		active, activeDEK := newDataKey(t, keys, "k2", conv+"#2", conv, true)
+		c.cache[active.ID] = activeDEK
+
+		sealed, err := crypto.Seal(retiredDEK, []byte("old secret"), []byte(conv))
+		if err != nil {
+			t.Fatalf("Seal: %v", err)
+		}
+		onRetired := MongoMessage{ID: primitive.NewObjectID(), Conversation: conv, Encrypted: true, KeyID: retired.ID,
+			Content: base64.StdEncoding.EncodeToString(sealed)}
+		plain := MongoMessage{ID: primitive.NewObjectID(), Conversation: conv, Content: "never encrypted"}
+		onLost := MongoMessage{ID: primitive.NewObjectID(), Conversation: conv, Encrypted: true, KeyID: lost.ID, Content: "AAAA"}
+
+		mt.AddMockResponses(
+			written(), // 1. вывод ключей под старым KEK
+			found(t, "data_keys", retired, lost),
+			// 2. пачка сообщений
+			found(t, "messages", onRetired, plain, onLost),
+			found(t, "data_keys", retired), // onRetired: ключ для расшифровки
+			found(t, "data_keys", active),  // onRetired: активный ключ диалога
+			written(),
+			found(t, "data_keys", active), // plain: активный ключ диалога
+			written(),
+			found(t, "data_keys", lost), // onLost: ключ есть, его KEK — нет
+			written(),                   // onLost: rotation_error
+			// 3. на выведенные ключи больше никто не ссылается
+			found(t, "messages"),
+			written(),
+			found(t, "messages"),
+			written(),
+		)
+
+		done, err := c.RotateKeys(context.Background())
+		if err != nil {
+			t.Fatalf("RotateKeys: %v", err)
+		}
+		if done != 2 {
+			t.Fatalf("RotateKeys re-encrypted %d messages, want 2", done)
+		}
+
+		got := updates(mt)
+		if len(got) != 4 {
+			t.Fatalf("%d updates, want 4 (retire + 3 messages)", len(got))
+		}
+		want := map[primitive.ObjectID]string{onRetired.ID: "old secret", plain.ID: "never encrypted"}
+		for _, u := range got[1:] {
+			id := u.filter.Lookup("_id").ObjectID()
+			if id == onLost.ID {
+				if _, ok := u.set.Lookup("rotation_error").StringValueOK(); !ok {
+					t.Fatalf("undecryptable message updated with %s, want rotation_error", u.set)
+				}
+				continue
+			}
+
+			// Перешифрованное сообщение лежит под активным ключом и расшифровывается в исходный текст
+			msg := &MongoMessage{ID: id, Conversation: conv, Encrypted: true,
+				KeyID: u.set.Lookup("key_id").StringValue(), Content: u.set.Lookup("content").StringValue()}
+			if msg.KeyID != active.ID {
+				t.Fatalf("message %s re-encrypted with %s, want %s", id.Hex(), msg.KeyID, active.ID)
+			}
+			if err := c.decrypt(context.Background(), msg); err != nil || msg.Content != want[id] {
+				t.Fatalf("message %s decrypts to %q, %v; want %q", id.Hex(), msg.Content, err, want[id])
+			}
+			delete(want, id)
+		}
+		if len(want) != 0 {
+			t.Fatalf("messages not re-encrypted: %v", want)
+		}
+
+		if n := countCommands(mt, "delete"); n != 2 {
+			t.Fatalf("%d retired keys deleted, want 2", n)
+		}
+	})
+
+	mt.Run("database error stops the pass", func(mt *mtest.T) {
+		keys := testKEKs(t, "k2", "k1", "k2")
+		c := newTestCipher(mt, keys)
+		const conv = "dm:alice:bob"
+		retired, _ := newDataKey(t, keys, "k1", conv+"#1", conv, false)
+		msg := MongoMessage{ID: primitive.NewObjectID(), Conversation: conv, Encrypted: true, KeyID: retired.ID, Content: "AAAA"}
+
+		mt.AddMockResponses(
+			written(),
+			found(t, "data_keys", retired),
+			found(t, "messages", msg),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Name: "ShutdownInProgress", Message: "shutting down"}),
+		)
+
+		if _, err := c.RotateKeys(context.Background()); err == nil || errors.Is(err, ErrUndecryptable) {
+			t.Fatalf("RotateKeys: err = %v, want a retryable error", err)
+		}
+		for _, u := range updates(mt) {
+			if _, ok := u.set.Lookup("rotation_error").StringValueOK(); ok {
+				t.Fatal("message marked with rotation_error on a temporary failure")
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"time"
+)
+
+// Сколько подписанных prekey храним на устройство: старые нужны клиенту,
+// пока до него доходят сообщения, зашифрованные на них
+const keepSignedPrekeys = 5
+
+// SignedPrekey — X25519-ключ устройства, подписанный его Ed25519-ключом
+type SignedPrekey struct {
+	KeyID     int64     `json:"key_id"`
+	PublicKey []byte    `json:"public_key"`
+	Signature []byte    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeviceKeys — публичные ключи устройства для E2E: сервер их только хранит и раздаёт
+type DeviceKeys struct {
+	YUI          string        `json:"yui"`
+	DeviceID     string        `json:"device_id"`
+	IdentityKey  []byte        `json:"identity_key"` // X25519
+	SigningKey   []byte        `json:"signing_key"`  // Ed25519, подписывает prekey
+	SignedPrekey *SignedPrekey `json:"signed_prekey"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// SaveDeviceKeys загружает ключи устройства и новый подписанный prekey.
+// Смена identity-ключа (переустановка) удаляет старые prekey устройства.
+func (db *DB) SaveDeviceKeys(k *DeviceKeys) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`
+        DELETE FROM signed_prekeys p
+        USING device_keys d
+        WHERE p.yui = d.yui AND p.device_id = d.device_id
+          AND d.yui = $1 AND d.device_id = $2 AND d.identity_key <> $3`,
+		k.YUI, k.DeviceID, k.IdentityKey,
+	); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+        INSERT INTO device_keys (yui, device_id, identity_key, signing_key)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (yui, device_id) DO UPDATE
+        SET identity_key = EXCLUDED.identity_key, signing_key = EXCLUDED.signing_key, updated_at = CURRENT_TIMESTAMP
+        RETURNING updated_at`,
+		k.YUI, k.DeviceID, k.IdentityKey, k.SigningKey,
+	).Scan(&k.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	p := k.SignedPrekey
+	err = tx.QueryRow(`
+        INSERT INTO signed_prekeys (yui, device_id, key_id, public_key, signature)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (yui, device_id, key_id) DO UPDATE
+        SET public_key = EXCLUDED.public_key, signature = EXCLUDED.signature
+        RETURNING created_at`,
+		k.YUI, k.DeviceID, p.KeyID, p.PublicKey, p.Signature,
+	).Scan(&p.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`
+        DELETE FROM signed_prekeys
+        WHERE yui = $1 AND device_id = $2 AND key_id NOT IN (
+            SELECT key_id FROM signed_prekeys
+            WHERE yui = $1 AND device_id = $2
+            ORDER BY created_at DESC, key_id DESC
+            LIMIT $3
+        )`,
+		k.YUI, k.DeviceID, keepSignedPrekeys,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetKeyBundles возвращает ключи всех устройств пользователя с последним подписанным prekey
+func (db *DB) GetKeyBundles(yui string) ([]*DeviceKeys, error) {
+	rows, err := db.conn.Query(`
+        SELECT d.yui, d.device_id, d.identity_key, d.signing_key, d.updated_at,
+               p.key_id, p.public_key, p.signature, p.created_at
+        FROM device_keys d
+        JOIN LATERAL (
+            SELECT key_id, public_key, signature, created_at
+            FROM signed_prekeys
+            WHERE yui = d.yui AND device_id = d.device_id
+            ORDER BY created_at DESC, key_id DESC
+            LIMIT 1
+        ) p ON true
+        WHERE d.yui = $1
+        ORDER BY d.device_id`,
+		yui,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bundles []*DeviceKeys
+	for rows.Next() {
+		k := &DeviceKeys{SignedPrekey: &SignedPrekey{}}
+		p := k.SignedPrekey
+		if err := rows.Scan(&k.YUI, &k.DeviceID, &k.IdentityKey, &k.SigningKey, &k.UpdatedAt,
+			&p.KeyID, &p.PublicKey, &p.Signature, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, k)
+	}
+	return bundles, rows.Err()
+}
+
+// DeleteDeviceKeys убирает устройство из каталога ключей
+func (db *DB) DeleteDeviceKeys(yui, deviceID string) (bool, error) {
+	res, err := db.conn.Exec(`DELETE FROM device_keys WHERE yui = $1 AND device_id = $2`, yui, deviceID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
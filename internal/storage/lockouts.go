@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Lockout — параметры блокировки ключа после серии неудачных попыток.
+// Ключ — что угодно, что перебирают: номер, аккаунт, адрес, второй фактор.
+type Lockout struct {
+	MaxFailures int           // неудач подряд до блокировки
+	Window      time.Duration // неудачи старше окна не считаются
+	Base        time.Duration // первая блокировка; каждая следующая вдвое дольше
+	Max         time.Duration
+}
+
+// LockedUntil возвращает время окончания блокировки ключа (нулевое — не заблокирован)
+func (db *DB) LockedUntil(key string) (time.Time, error) {
+	var until sql.NullTime
+	err := db.conn.QueryRow(
+		`SELECT locked_until FROM lockouts WHERE key = $1 AND locked_until > $2`,
+		key, time.Now(),
+	).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return until.Time, err
+}
+
+// next считает счётчики после ещё одной неудачи в момент now.
+// Возвращает конец блокировки, если она началась (иначе нулевое время).
+func (p Lockout) next(failures, lockouts int, lastFailure, now time.Time) (int, int, time.Time) {
+	if !lastFailure.IsZero() && now.Sub(lastFailure) > p.Window {
+		failures = 0
+	}
+	failures++
+	if failures < p.MaxFailures {
+		return failures, lockouts, time.Time{}
+	}
+
+	// Сдвигаем, только пока результат не превышает Max: иначе после ~30 блокировок сдвиг переполнится
+	d := p.Max
+	if p.Base > 0 && lockouts < 63 && p.Base <= p.Max>>lockouts {
+		d = p.Base << lockouts
+	}
+	return 0, lockouts + 1, now.Add(d)
+}
+
+// RecordFailure учитывает неудачную попытку и при превышении лимита блокирует ключ.
+// Длительность блокировки растёт вдвое с каждой следующей. Возвращает конец блокировки, если она началась.
+func (db *DB) RecordFailure(key string, policy Lockout) (time.Time, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`
+        INSERT INTO lockouts (key) VALUES ($1)
+        ON CONFLICT (key) DO NOTHING`,
+		key,
+	); err != nil {
+		return time.Time{}, err
+	}
+
+	var failures, lockouts int
+	var lastFailure sql.NullTime
+	if err := tx.QueryRow(`
+        SELECT failures, lockouts, last_failure FROM lockouts
+        WHERE key = $1
+        FOR UPDATE`,
+		key,
+	).Scan(&failures, &lockouts, &lastFailure); err != nil {
+		return time.Time{}, err
+	}
+
+	failures, lockouts, lockedUntil := policy.next(failures, lockouts, lastFailure.Time, now)
+
+	if _, err := tx.Exec(`
+        UPDATE lockouts
+        SET failures = $2, lockouts = $3, last_failure = $4,
+            locked_until = CASE WHEN $5 THEN $6 ELSE locked_until END
+        WHERE key = $1`,
+		key, failures, lockouts, now, !lockedUntil.IsZero(), lockedUntil,
+	); err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil, tx.Commit()
+}
+
+// ResetFailures сбрасывает счётчики после успешной проверки
+func (db *DB) ResetFailures(key string) error {
+	_, err := db.conn.Exec(`DELETE FROM lockouts WHERE key = $1`, key)
+	return err
+}
+
+// DeleteStaleLockouts удаляет снятые блокировки и счётчики без неудач дольше ttl
+func (db *DB) DeleteStaleLockouts(ttl time.Duration) error {
+	now := time.Now()
+	_, err := db.conn.Exec(`
+        DELETE FROM lockouts
+        WHERE (locked_until IS NULL OR locked_until < $1) AND last_failure < $2`,
+		now, now.Add(-ttl),
+	)
+	return err
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// Та же политика, что у OTP по умолчанию
+var testLockout = Lockout{
+	MaxFailures: 10,
+	Window:      time.Hour,
+	Base:        time.Minute,
+	Max:         24 * time.Hour,
+}
+
+func TestLockoutNext(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		policy       Lockout
+		failures     int
+		lockouts     int
+		lastFailure  time.Time
+		wantFailures int
+		wantLockouts int
+		wantLocked   time.Duration // 0 — блокировка не началась
+	}{
+		{"first failure", testLockout, 0, 0, time.Time{}, 1, 0, 0},
+		{"below limit", testLockout, 7, 0, now.Add(-time.Minute), 8, 0, 0},
+		{"limit reached", testLockout, 9, 0, now.Add(-time.Minute), 0, 1, time.Minute},
+		{"second lockout doubles", testLockout, 9, 1, now.Add(-time.Minute), 0, 2, 2 * time.Minute},
+		{"fifth lockout", testLockout, 9, 4, now.Add(-time.Minute), 0, 5, 16 * time.Minute},
+		{"capped at max", testLockout, 9, 11, now.Add(-time.Minute), 0, 12, 24 * time.Hour},
+		{"no overflow after many lockouts", testLockout, 9, 40, now.Add(-time.Minute), 0, 41, 24 * time.Hour},
+		{"no overflow past 64 lockouts", testLockout, 9, 70, now.Add(-time.Minute), 0, 71, 24 * time.Hour},
+		{"old failures forgotten", testLockout, 9, 0, now.Add(-2 * time.Hour), 1, 0, 0},
+		{"failure exactly at window edge still counts", testLockout, 9, 0, now.Add(-time.Hour), 0, 1, time.Minute},
+		{"lockout count survives window reset", testLockout, 9, 3, now.Add(-2 * time.Hour), 1, 3, 0},
+		{"zero base locks for max", Lockout{MaxFailures: 1, Window: time.Hour, Max: time.Hour}, 0, 0, time.Time{}, 0, 1, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures, lockouts, until := tt.policy.next(tt.failures, tt.lockouts, tt.lastFailure, now)
+			if failures != tt.wantFailures || lockouts != tt.wantLockouts {
+				t.Fatalf("counters = %d failures, %d lockouts; want %d, %d", failures, lockouts, tt.wantFailures, tt.wantLockouts)
+			}
+			var locked time.Duration
+			if !until.IsZero() {
+				locked = until.Sub(now)
+			}
+			if locked != tt.wantLocked {
+				t.Fatalf("locked for %s, want %s", locked, tt.wantLocked)
+			}
+		})
+	}
+}
+
+// TestLockoutSequence прогоняет серию неудач подряд, как их видит RecordFailure
+func TestLockoutSequence(t *testing.T) {
+	policy := Lockout{MaxFailures: 3, Window: time.Hour, Base: time.Minute, Max: 5 * time.Minute}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var failures, lockouts int
+	var last time.Time
+	var got []time.Duration
+	for i := 0; i < 15; i++ {
+		var until time.Time
+		failures, lockouts, until = policy.next(failures, lockouts, last, now)
+		if !until.IsZero() {
+			got = append(got, until.Sub(now))
+		}
+		last = now
+		now = now.Add(time.Second)
+	}
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	if len(got) != len(want) {
+		t.Fatalf("lockouts = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("lockouts = %v, want %v", got, want)
+		}
+	}
+}
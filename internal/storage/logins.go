@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"time"
+)
+
+// LoginAttempt — запись истории входов. YUI пуст, если аккаунт с таким email не найден.
+type LoginAttempt struct {
+	YUI       string    `json:"-"`
+	Email     string    `json:"-"`
+	IP        string    `json:"ip"`
+	Device    string    `json:"device"`
+	Method    string    `json:"method,omitempty"` // password, register, telegram, refresh
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"` // почему отказали: bad_password, locked, ...
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) RecordLogin(a *LoginAttempt) error {
+	_, err := db.conn.Exec(`
+        INSERT INTO login_history (yui, email, ip, device, method, success, reason)
+        VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, NULLIF($5, ''), $6, NULLIF($7, ''))`,
+		a.YUI, a.Email, a.IP, a.Device, a.Method, a.Success, a.Reason,
+	)
+	return err
+}
+
+// RecentLoginFailures — неудачные входы по паролю для email с момента since, но не раньше последнего успешного
+func (db *DB) RecentLoginFailures(email string, since time.Time) (int, error) {
+	var n int
+	err := db.conn.QueryRow(`
+        SELECT COUNT(*) FROM login_history
+        WHERE email = $1 AND NOT success AND COALESCE(method, 'password') = 'password' AND created_at > GREATEST($2, (
+            SELECT COALESCE(MAX(created_at), $2) FROM login_history WHERE email = $1 AND success
+        ))`,
+		email, since,
+	).Scan(&n)
+	return n, err
+}
+
+// ListLogins — последние входы пользователя, новые первыми
+func (db *DB) ListLogins(yui string, limit int) ([]*LoginAttempt, error) {
+	rows, err := db.conn.Query(`
+        SELECT yui, COALESCE(email, ''), COALESCE(ip, ''), COALESCE(device, ''), COALESCE(method, ''), success, COALESCE(reason, ''), created_at
+        FROM login_history
+        WHERE yui = $1
+        ORDER BY created_at DESC
+        LIMIT $2`,
+		yui, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logins []*LoginAttempt
+	for rows.Next() {
+		a := &LoginAttempt{}
+		if err := rows.Scan(&a.YUI, &a.Email, &a.IP, &a.Device, &a.Method, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		logins = append(logins, a)
+	}
+	return logins, rows.Err()
+}
+
+// DeleteOldLogins удаляет историю входов старше olderThan
+func (db *DB) DeleteOldLogins(olderThan time.Time) error {
+	_, err := db.conn.Exec(`DELETE FROM login_history WHERE created_at < $1`, olderThan)
+	return err
+}
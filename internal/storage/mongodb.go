@@ -2,20 +2,35 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"yep-protocol/internal/core"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Сообщение с таким client_msg_id от этого отправителя уже сохранено
+var ErrDuplicateMessage = errors.New("duplicate message")
+
+// Имена уникальных индексов: по ним различаем, какое ограничение нарушила вставка.
+// Совпадают с именами, которые MongoDB даёт по умолчанию, так что уже созданные индексы подходят.
+const (
+	clientMsgIDIndex = "from_yui_1_client_msg_id_1"
+	seqIndex         = "conversation_1_seq_1"
+)
+
 type MongoDB struct {
 	client   *mongo.Client
 	database *mongo.Database
 	messages *mongo.Collection
+	cipher   *MessageCipher // шифрование содержимого; nil — храним открытым текстом
 }
 
 // Message структура для MongoDB
@@ -23,11 +38,46 @@ type MongoMessage struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	FromYUI   string             `bson:"from_yui"`
 	ToYUI     string             `bson:"to_yui,omitempty"`
+	Room      string             `bson:"room,omitempty"`
 	Content   string             `bson:"content"`
 	Level     string             `bson:"level"`
 	Encrypted bool               `bson:"encrypted"`
+	KeyID     string             `bson:"key_id,omitempty"` // ключ данных, которым зашифрован Content
+	E2E       bool               `bson:"e2e,omitempty"`    // Content зашифрован клиентом, сервер его не трогает
 	CreatedAt time.Time          `bson:"created_at"`
 	IsRead    bool               `bson:"is_read"`
+
+	ClientMsgID string `bson:"client_msg_id,omitempty"` // идентификатор от клиента для идемпотентных повторов
+
+	Conversation string     `bson:"conversation,omitempty"` // "room:<name>" или "dm:<yui>:<yui>"
+	Seq          int64      `bson:"seq,omitempty"`          // строго возрастает внутри conversation
+	DeliveredAt  *time.Time `bson:"delivered_at,omitempty"`
+	ReadAt       *time.Time `bson:"read_at,omitempty"`
+}
+
+// ToYepMessage — сообщение в формате протокола: так его получают история, досылка и офлайн-доставка
+func (m *MongoMessage) ToYepMessage() core.YepMessage {
+	msg := core.YepMessage{
+		Type:         "MESSAGE",
+		Content:      m.Content,
+		YUI:          m.FromYUI,
+		ToYUI:        m.ToYUI,
+		Room:         m.Room,
+		Level:        m.Level,
+		MessageID:    m.ID.Hex(),
+		ClientMsgID:  m.ClientMsgID,
+		Conversation: m.Conversation,
+		Seq:          m.Seq,
+		Timestamp:    m.CreatedAt.Unix(),
+	}
+	if m.ToYUI != "" {
+		msg.Type = "DIRECT_MESSAGE"
+	}
+	if m.E2E {
+		msg.Type = "E2E_MESSAGE"
+		msg.Encrypted = true
+	}
+	return msg
 }
 
 // Подключение к MongoDB
@@ -52,10 +102,31 @@ func NewMongoDB(uri string) (*MongoDB, error) {
 
 	// Создаём индексы для быстрого поиска
 	_, err = messages.Indexes().CreateMany(ctx, []mongo.IndexModel{
-		{Keys: bson.D{{"from_yui", 1}}},
-		{Keys: bson.D{{"to_yui", 1}}},
-		{Keys: bson.D{{"created_at", -1}}},
+		{Keys: bson.D{{Key: "from_yui", Value: 1}}},
+		{Keys: bson.D{{Key: "to_yui", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "room", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			// Один client_msg_id на отправителя: повторная отправка не создаёт дубль
+			Keys: bson.D{{Key: "from_yui", Value: 1}, {Key: "client_msg_id", Value: 1}},
+			Options: options.Index().
+				SetName(clientMsgIDIndex).
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"client_msg_id": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{{Key: "conversation", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().
+				SetName(seqIndex).
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"seq": bson.M{"$gt": 0}}),
+		},
 	})
+	if err != nil {
+		// Без уникальных индексов дедупликация и seq молча перестают работать
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to create MongoDB indexes: %w", err)
+	}
 
 	log.Println("✅ Connected to MongoDB")
 
@@ -66,6 +137,9 @@ func NewMongoDB(uri string) (*MongoDB, error) {
 	}, nil
 }
 
+// Сколько раз пробуем занять следующий seq, если его одновременно занял другой
+const seqInsertAttempts = 20
+
 // Сохранить сообщение
 func (m *MongoDB) SaveMessage(msg *MongoMessage) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -73,13 +147,124 @@ func (m *MongoDB) SaveMessage(msg *MongoMessage) error {
 
 	msg.CreatedAt = time.Now()
 
-	result, err := m.messages.InsertOne(ctx, msg)
+	// Шифруем копию: вызывающий код продолжает работать с открытым текстом.
+	// E2E-сообщения уже зашифрованы клиентом и хранятся как есть.
+	doc := *msg
+	if m.cipher != nil && !doc.E2E {
+		if err := m.cipher.encrypt(ctx, &doc); err != nil {
+			return fmt.Errorf("failed to encrypt message: %w", err)
+		}
+	}
+
+	// seq = последний сохранённый + 1, вставка вместе с ним — один атомарный шаг:
+	// уникальный индекс (conversation, seq) не даст двум сообщениям один номер,
+	// а номер N+1 появляется только после того, как N уже сохранён. Дыр не остаётся,
+	// в том числе от дублей client_msg_id.
+	for attempt := 0; ; attempt++ {
+		if doc.Conversation != "" {
+			last, err := m.lastSeq(ctx, doc.Conversation)
+			if err != nil {
+				return fmt.Errorf("failed to allocate seq: %w", err)
+			}
+			doc.Seq = last + 1
+		}
+
+		result, err := m.messages.InsertOne(ctx, &doc)
+		if err == nil {
+			if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+				msg.ID = id
+			}
+			msg.Seq = doc.Seq
+			log.Printf("📝 Message saved with ID: %v", result.InsertedID)
+			return nil
+		}
+
+		if duplicateKeyIn(err, clientMsgIDIndex) {
+			return ErrDuplicateMessage
+		}
+		if !duplicateKeyIn(err, seqIndex) {
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+		// seq занял параллельный отправитель — берём следующий
+		if attempt+1 >= seqInsertAttempts {
+			return fmt.Errorf("failed to allocate seq in %s: too much contention", doc.Conversation)
+		}
+	}
+}
+
+// duplicateKeyIn сообщает, что вставку отверг уникальный индекс с именем index.
+// Имя индекса сервер передаёт только в тексте ошибки: "... index: <name> dup key: ...".
+func duplicateKeyIn(err error, index string) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == 11000 && strings.Contains(e.Message, "index: "+index+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// lastSeq — наибольший сохранённый seq диалога (0, если сообщений нет)
+func (m *MongoDB) lastSeq(ctx context.Context, conversation string) (int64, error) {
+	var last struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := m.messages.FindOne(ctx,
+		bson.M{"conversation": conversation, "seq": bson.M{"$gt": 0}},
+		options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}}).SetProjection(bson.M{"seq": 1}),
+	).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Seq, err
+}
+
+// Сообщения диалога после afterSeq по возрастанию seq
+func (m *MongoDB) GetConversationSince(conversation string, afterSeq, limit int64) ([]*MongoMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"conversation": conversation,
+		"seq":          bson.M{"$gt": afterSeq},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "seq", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := m.messages.Find(ctx, filter, opts)
 	if err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	log.Printf("📝 Message saved with ID: %v", result.InsertedID)
-	return nil
+	var messages []*MongoMessage
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	m.decryptAll(ctx, messages...)
+	return messages, nil
+}
+
+// Найти сообщение отправителя по client_msg_id
+func (m *MongoDB) GetMessageByClientID(fromYUI, clientMsgID string) (*MongoMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var msg MongoMessage
+	err := m.messages.FindOne(ctx, bson.M{
+		"from_yui":      fromYUI,
+		"client_msg_id": clientMsgID,
+	}).Decode(&msg)
+	if err != nil {
+		return nil, err
+	}
+	m.decryptAll(ctx, &msg)
+	return &msg, nil
 }
 
 // Получить историю сообщений
@@ -97,7 +282,41 @@ func (m *MongoDB) GetMessageHistory(yui string, limit int64) ([]*MongoMessage, e
 
 	// Опции: сортировка по времени, лимит
 	opts := options.Find().
-		SetSort(bson.D{{"created_at", -1}}).
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := m.messages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*MongoMessage
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	m.decryptAll(ctx, messages...)
+	return messages, nil
+}
+
+// Получить историю комнаты. Старые сообщения без комнаты и адресата считаются сообщениями lobby.
+func (m *MongoDB) GetRoomHistory(room string, limit int64) ([]*MongoMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"room": room}
+	if room == core.LobbyRoom {
+		filter = bson.M{
+			"$or": []bson.M{
+				{"room": core.LobbyRoom},
+				{"room": bson.M{"$exists": false}, "to_yui": bson.M{"$exists": false}},
+			},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
 		SetLimit(limit)
 
 	cursor, err := m.messages.Find(ctx, filter, opts)
@@ -111,20 +330,121 @@ func (m *MongoDB) GetMessageHistory(yui string, limit int64) ([]*MongoMessage, e
 		return nil, err
 	}
 
+	m.decryptAll(ctx, messages...)
 	return messages, nil
 }
 
-// Получить непрочитанные сообщения
-func (m *MongoDB) GetUnreadMessages(yui string) ([]*MongoMessage, error) {
+// HistoryQuery — параметры постраничной выборки истории
+type HistoryQuery struct {
+	YUI  string // чья история: сообщения от или для пользователя
+	Peer string // только личный диалог с этим пользователем
+	Room string // только сообщения комнаты
+
+	// Курсоры: ObjectID или seq (seq имеет смысл только внутри Peer/Room)
+	BeforeID  primitive.ObjectID
+	AfterID   primitive.ObjectID
+	BeforeSeq int64
+	AfterSeq  int64
+
+	Since time.Time // created_at >= Since
+	Until time.Time // created_at < Until
+
+	Limit   int64
+	Forward bool // true — от старых к новым, иначе от новых к старым
+	BySeq   bool // сортировать и листать по seq, а не по _id
+}
+
+// Постраничная история по курсорам
+func (m *MongoDB) QueryHistory(q HistoryQuery) ([]*MongoMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var and []bson.M
+	switch {
+	case q.Room == core.LobbyRoom:
+		and = append(and, bson.M{"$or": []bson.M{
+			{"room": core.LobbyRoom},
+			{"room": bson.M{"$exists": false}, "to_yui": bson.M{"$exists": false}},
+		}})
+	case q.Room != "":
+		and = append(and, bson.M{"room": q.Room})
+	case q.Peer != "":
+		and = append(and, bson.M{"$or": []bson.M{
+			{"from_yui": q.YUI, "to_yui": q.Peer},
+			{"from_yui": q.Peer, "to_yui": q.YUI},
+		}})
+	default:
+		and = append(and, bson.M{"$or": []bson.M{
+			{"from_yui": q.YUI},
+			{"to_yui": q.YUI},
+		}})
+	}
+
+	if !q.BeforeID.IsZero() {
+		and = append(and, bson.M{"_id": bson.M{"$lt": q.BeforeID}})
+	}
+	if !q.AfterID.IsZero() {
+		and = append(and, bson.M{"_id": bson.M{"$gt": q.AfterID}})
+	}
+	if q.BeforeSeq > 0 {
+		and = append(and, bson.M{"seq": bson.M{"$lt": q.BeforeSeq}})
+	}
+	if q.AfterSeq > 0 {
+		and = append(and, bson.M{"seq": bson.M{"$gt": q.AfterSeq}})
+	}
+	if q.BySeq {
+		and = append(and, bson.M{"seq": bson.M{"$gt": 0}})
+	}
+	if !q.Since.IsZero() {
+		and = append(and, bson.M{"created_at": bson.M{"$gte": q.Since}})
+	}
+	if !q.Until.IsZero() {
+		and = append(and, bson.M{"created_at": bson.M{"$lt": q.Until}})
+	}
+
+	order := -1
+	if q.Forward {
+		order = 1
+	}
+	sortKey := "_id"
+	if q.BySeq {
+		sortKey = "seq"
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: sortKey, Value: order}}).
+		SetLimit(q.Limit)
+
+	cursor, err := m.messages.Find(ctx, bson.M{"$and": and}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*MongoMessage
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	m.decryptAll(ctx, messages...)
+	return messages, nil
+}
+
+// Получить личные сообщения, которые ещё не были доставлены адресату
+func (m *MongoDB) GetUndeliveredMessages(yui string) ([]*MongoMessage, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	filter := bson.M{
-		"to_yui":  yui,
-		"is_read": false,
+		"to_yui":       yui,
+		"is_read":      false,
+		"delivered_at": bson.M{"$exists": false},
 	}
 
-	cursor, err := m.messages.Find(ctx, filter)
+	// Старые первыми, чтобы доставлять в исходном порядке
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := m.messages.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -135,24 +455,65 @@ func (m *MongoDB) GetUnreadMessages(yui string) ([]*MongoMessage, error) {
 		return nil, err
 	}
 
+	m.decryptAll(ctx, messages...)
 	return messages, nil
 }
 
-// Пометить как прочитанное
-func (m *MongoDB) MarkAsRead(messageID string) error {
+// Пометить как доставленное; пометить может только адресат.
+// Возвращает сообщение, если статус изменился (повторная отметка вернёт mongo.ErrNoDocuments).
+func (m *MongoDB) MarkDelivered(messageID, yui string) (*MongoMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":          objID,
+		"to_yui":       yui,
+		"delivered_at": bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{"delivered_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var msg MongoMessage
+	if err := m.messages.FindOneAndUpdate(ctx, filter, update, opts).Decode(&msg); err != nil {
+		return nil, err
+	}
+	m.decryptAll(ctx, &msg)
+	return &msg, nil
+}
+
+// Пометить как прочитанное; пометить может только адресат.
+// Прочитанное считается и доставленным. Повторная отметка вернёт mongo.ErrNoDocuments.
+func (m *MongoDB) MarkAsRead(messageID, yui string) (*MongoMessage, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	objID, err := primitive.ObjectIDFromHex(messageID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": bson.M{"is_read": true}}
+	now := time.Now()
+	filter := bson.M{"_id": objID, "to_yui": yui, "is_read": false}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"is_read":      true,
+			"read_at":      now,
+			"delivered_at": bson.M{"$ifNull": bson.A{"$delivered_at", now}},
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	_, err = m.messages.UpdateOne(ctx, filter, update)
-	return err
+	var msg MongoMessage
+	if err := m.messages.FindOneAndUpdate(ctx, filter, update, opts).Decode(&msg); err != nil {
+		return nil, err
+	}
+	m.decryptAll(ctx, &msg)
+	return &msg, nil
 }
 
 // Статистика сообщений
@@ -185,6 +546,27 @@ func (m *MongoDB) GetMessageStats(yui string) (map[string]interface{}, error) {
 	}, nil
 }
 
+// decryptAll прозрачно расшифровывает сообщения с флагом Encrypted.
+// Если расшифровать не удалось, сообщение остаётся с Encrypted = true,
+// а Content заменяется на UndecryptableContent: шифротекст клиентам не отдаём.
+// E2E-сообщения отдаются клиенту зашифрованными.
+func (m *MongoDB) decryptAll(ctx context.Context, messages ...*MongoMessage) {
+	for _, msg := range messages {
+		if !msg.Encrypted || msg.E2E {
+			continue
+		}
+		if m.cipher == nil {
+			log.Printf("⚠️ Message %s is encrypted, but encryption is not configured", msg.ID.Hex())
+			msg.Content = UndecryptableContent
+			continue
+		}
+		if err := m.cipher.decrypt(ctx, msg); err != nil {
+			log.Printf("⚠️ Failed to decrypt message: %v", err)
+			msg.Content = UndecryptableContent
+		}
+	}
+}
+
 // Закрыть подключение
 func (m *MongoDB) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
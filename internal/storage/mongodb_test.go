@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestDuplicateKeyIn(t *testing.T) {
+	dup := func(index string) error {
+		return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+			Code:    11000,
+			Message: "E11000 duplicate key error collection: yep_hub.messages index: " + index + " dup key: { conversation: \"dm:a:b\", seq: 7 }",
+		}}}
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		clientID bool
+		seq      bool
+	}{
+		{"client_msg_id", dup(clientMsgIDIndex), true, false},
+		{"seq", dup(seqIndex), false, true},
+		{"wrapped seq", fmt.Errorf("insert: %w", dup(seqIndex)), false, true},
+		// Значение client_msg_id в тексте ошибки не должно сбивать с толку
+		{"seq with client_msg_id in value", mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+			Code:    11000,
+			Message: "E11000 duplicate key error index: " + seqIndex + " dup key: { conversation: \"client_msg_id\" }",
+		}}}, false, true},
+		{"other index", dup("email_1"), false, false},
+		{"other write error", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121, Message: "index: " + seqIndex + " "}}}, false, false},
+		{"not a write error", errors.New("index: " + seqIndex + " dup key"), false, false},
+		{"nil", nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := duplicateKeyIn(tt.err, clientMsgIDIndex); got != tt.clientID {
+				t.Errorf("client_msg_id index = %v, want %v", got, tt.clientID)
+			}
+			if got := duplicateKeyIn(tt.err, seqIndex); got != tt.seq {
+				t.Errorf("seq index = %v, want %v", got, tt.seq)
+			}
+		})
+	}
+}
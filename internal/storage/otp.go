@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrOTPNotFound = errors.New("no code found") // кода нет, он истёк или исчерпал попытки
+	ErrOTPInvalid  = errors.New("invalid code")
+)
+
+// SaveOTP сохраняет хэш кода для номера; новый код заменяет старый и сбрасывает его попытки
+func (db *DB) SaveOTP(phoneHash, codeHash string, ttl time.Duration) error {
+	_, err := db.conn.Exec(`
+        INSERT INTO otp_codes (phone_hash, code_hash, expires_at, attempts, created_at)
+        VALUES ($1, $2, $3, 0, $4)
+        ON CONFLICT (phone_hash) DO UPDATE
+        SET code_hash = $2, expires_at = $3, attempts = 0, created_at = $4
+    `, phoneHash, codeHash, time.Now().Add(ttl), time.Now())
+	return err
+}
+
+// ConsumeOTP сверяет хэш кода и удаляет код при совпадении.
+// Неверная попытка увеличивает счётчик; после maxAttempts код удаляется.
+func (db *DB) ConsumeOTP(phoneHash, codeHash string, maxAttempts int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored string
+	var attempts int
+	var expiresAt time.Time
+	err = tx.QueryRow(`
+        SELECT code_hash, attempts, expires_at FROM otp_codes
+        WHERE phone_hash = $1
+        FOR UPDATE`,
+		phoneHash,
+	).Scan(&stored, &attempts, &expiresAt)
+	if err == sql.ErrNoRows {
+		return ErrOTPNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Now().After(expiresAt) || attempts >= maxAttempts {
+		if _, err := tx.Exec(`DELETE FROM otp_codes WHERE phone_hash = $1`, phoneHash); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrOTPNotFound
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(codeHash)) == 1 {
+		if _, err := tx.Exec(`DELETE FROM otp_codes WHERE phone_hash = $1`, phoneHash); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if attempts+1 >= maxAttempts {
+		_, err = tx.Exec(`DELETE FROM otp_codes WHERE phone_hash = $1`, phoneHash)
+	} else {
+		_, err = tx.Exec(`UPDATE otp_codes SET attempts = attempts + 1 WHERE phone_hash = $1`, phoneHash)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return ErrOTPInvalid
+}
+
+// DeleteExpiredOTP удаляет истёкшие коды
+func (db *DB) DeleteExpiredOTP() (int64, error) {
+	res, err := db.conn.Exec(`DELETE FROM otp_codes WHERE expires_at < $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
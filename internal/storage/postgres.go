@@ -11,7 +11,8 @@ import (
 )
 
 type DB struct {
-	conn *sql.DB
+	conn  *sql.DB
+	rooms *roomCache // участники комнат
 }
 
 func NewDB(connStr string) (*DB, error) {
@@ -24,7 +25,7 @@ func NewDB(connStr string) (*DB, error) {
 		return nil, err
 	}
 
-	db := &DB{conn: conn}
+	db := &DB{conn: conn, rooms: newRoomCache()}
 	if err = db.createTables(); err != nil {
 		return nil, err
 	}
@@ -54,11 +55,173 @@ func (db *DB) createTables() error {
         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
 
+    CREATE TABLE IF NOT EXISTS rooms (
+        name VARCHAR(64) PRIMARY KEY,
+        created_by VARCHAR(50) NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS room_members (
+        room VARCHAR(64) REFERENCES rooms(name) ON DELETE CASCADE,
+        yui VARCHAR(50) NOT NULL,
+        joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (room, yui)
+    );
+
+    CREATE TABLE IF NOT EXISTS refresh_tokens (
+        token_hash VARCHAR(64) PRIMARY KEY,
+        family_id VARCHAR(64) NOT NULL,
+        yui VARCHAR(50) NOT NULL,
+        expires_at TIMESTAMP NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        used_at TIMESTAMP,
+        revoked_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id);
+
+    CREATE TABLE IF NOT EXISTS sessions (
+        jti VARCHAR(64) PRIMARY KEY,
+        session_id VARCHAR(64) UNIQUE NOT NULL,
+        yui VARCHAR(50) NOT NULL,
+        device VARCHAR(255),
+        ip VARCHAR(64),
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        expires_at TIMESTAMP NOT NULL,
+        revoked_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS sessions_yui_idx ON sessions (yui);
+
+    CREATE TABLE IF NOT EXISTS device_keys (
+        yui VARCHAR(50) NOT NULL,
+        device_id VARCHAR(64) NOT NULL,
+        identity_key BYTEA NOT NULL,
+        signing_key BYTEA NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (yui, device_id)
+    );
+
+    CREATE TABLE IF NOT EXISTS signed_prekeys (
+        yui VARCHAR(50) NOT NULL,
+        device_id VARCHAR(64) NOT NULL,
+        key_id BIGINT NOT NULL,
+        public_key BYTEA NOT NULL,
+        signature BYTEA NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (yui, device_id, key_id),
+        FOREIGN KEY (yui, device_id) REFERENCES device_keys (yui, device_id) ON DELETE CASCADE
+    );
+
     CREATE TABLE IF NOT EXISTS otp_codes (
         phone_hash VARCHAR(64) PRIMARY KEY,
-        code VARCHAR(6) NOT NULL,
+        code_hash VARCHAR(64) NOT NULL,
+        expires_at TIMESTAMP NOT NULL
+    );
+    ALTER TABLE otp_codes ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+    ALTER TABLE otp_codes ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+    -- Коды раньше хранились открытым текстом: живут минуты, поэтому выданные просто сбрасываем
+    DO $$
+    BEGIN
+        IF EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_name = 'otp_codes' AND column_name = 'code') THEN
+            DELETE FROM otp_codes;
+            ALTER TABLE otp_codes DROP COLUMN code;
+            ALTER TABLE otp_codes ADD COLUMN code_hash VARCHAR(64) NOT NULL;
+        END IF;
+    END $$;
+
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS telegram_id BIGINT;
+    CREATE UNIQUE INDEX IF NOT EXISTS users_telegram_id_idx ON users (telegram_id) WHERE telegram_id IS NOT NULL;
+
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
+    CREATE TABLE IF NOT EXISTS email_verifications (
+        token_hash VARCHAR(64) PRIMARY KEY,
+        yui VARCHAR(50) NOT NULL,
+        email VARCHAR(255) NOT NULL,
+        code_hash VARCHAR(64) NOT NULL,
         expires_at TIMESTAMP NOT NULL,
-        telegram_id BIGINT
+        used_at TIMESTAMP,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS email_verifications_yui_idx ON email_verifications (yui, created_at);
+
+    CREATE TABLE IF NOT EXISTS login_history (
+        id BIGSERIAL PRIMARY KEY,
+        yui VARCHAR(50),
+        email VARCHAR(255) NOT NULL,
+        ip VARCHAR(64),
+        device TEXT,
+        success BOOLEAN NOT NULL,
+        reason VARCHAR(32),
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS login_history_yui_idx ON login_history (yui, created_at);
+    CREATE INDEX IF NOT EXISTS login_history_email_idx ON login_history (email, created_at);
+    ALTER TABLE login_history ADD COLUMN IF NOT EXISTS method VARCHAR(16);
+    ALTER TABLE login_history ALTER COLUMN email DROP NOT NULL;
+
+    -- telegram_id раньше хранился в otp_codes: переносим в users один раз
+    DO $$
+    BEGIN
+        IF EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_name = 'otp_codes' AND column_name = 'telegram_id') THEN
+            UPDATE users u SET telegram_id = o.telegram_id
+            FROM (
+                SELECT DISTINCT ON (telegram_id) phone_hash, telegram_id
+                FROM otp_codes
+                WHERE telegram_id IS NOT NULL AND telegram_id <> 0
+                ORDER BY telegram_id, expires_at DESC
+            ) o
+            WHERE u.phone_hash = o.phone_hash AND u.telegram_id IS NULL
+              AND NOT EXISTS (SELECT 1 FROM users x WHERE x.telegram_id = o.telegram_id);
+            ALTER TABLE otp_codes DROP COLUMN telegram_id;
+        END IF;
+    END $$;
+
+    CREATE TABLE IF NOT EXISTS password_resets (
+        token_hash VARCHAR(64) PRIMARY KEY,
+        yui VARCHAR(50) NOT NULL,
+        expires_at TIMESTAMP NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        used_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS password_resets_yui_idx ON password_resets (yui, created_at);
+
+    CREATE TABLE IF NOT EXISTS user_totp (
+        yui VARCHAR(50) PRIMARY KEY,
+        secret VARCHAR(64) NOT NULL,
+        confirmed_at TIMESTAMP,
+        last_step BIGINT NOT NULL DEFAULT 0,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+    CREATE TABLE IF NOT EXISTS recovery_codes (
+        yui VARCHAR(50) NOT NULL,
+        code_hash VARCHAR(64) NOT NULL,
+        used_at TIMESTAMP,
+        PRIMARY KEY (yui, code_hash)
+    );
+
+    -- счётчики неудач раньше были только у OTP: otp_failures(phone_hash) -> lockouts(key)
+    DO $$
+    BEGIN
+        IF to_regclass('otp_failures') IS NOT NULL AND to_regclass('lockouts') IS NULL THEN
+            ALTER TABLE otp_failures RENAME TO lockouts;
+            ALTER TABLE lockouts RENAME COLUMN phone_hash TO key;
+            ALTER TABLE lockouts ALTER COLUMN key TYPE VARCHAR(255);
+            ALTER INDEX otp_failures_pkey RENAME TO lockouts_pkey;
+        END IF;
+    END $$;
+
+    CREATE TABLE IF NOT EXISTS lockouts (
+        key VARCHAR(255) PRIMARY KEY,
+        failures INT NOT NULL DEFAULT 0,
+        lockouts INT NOT NULL DEFAULT 0,
+        locked_until TIMESTAMP,
+        last_failure TIMESTAMP
     );
     `
 	_, err := db.conn.Exec(query)
@@ -67,27 +230,47 @@ func (db *DB) createTables() error {
 
 func (db *DB) CreateUser(user *core.User) error {
 	query := `
-        INSERT INTO users (yui, email, phone, phone_hash, password_hash, level, is_active)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        INSERT INTO users (yui, email, phone, phone_hash, password_hash, level, is_active, telegram_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))
         RETURNING created_at`
 
 	return db.conn.QueryRow(
 		query,
 		user.YUI, user.Email, user.Phone, user.PhoneHash,
-		user.PasswordHash, user.Level, user.IsActive,
+		user.PasswordHash, user.Level, user.IsActive, user.TelegramID,
 	).Scan(&user.CreatedAt)
 }
 func (db *DB) GetUserByEmail(email string) (*core.User, error) {
 	user := &core.User{}
 	query := `
-        SELECT yui, email, phone, password_hash, level, created_at, last_login, is_active
+        SELECT yui, email, phone, password_hash, level, created_at, last_login, is_active, COALESCE(telegram_id, 0), email_verified_at
         FROM users
         WHERE email = $1 AND is_active = true`
 
 	err := db.conn.QueryRow(query, email).Scan(
 		&user.YUI, &user.Email, &user.Phone,
 		&user.PasswordHash, &user.Level,
-		&user.CreatedAt, &user.LastLogin, &user.IsActive,
+		&user.CreatedAt, &user.LastLogin, &user.IsActive, &user.TelegramID, &user.EmailVerifiedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return user, err
+}
+
+func (db *DB) GetUserByYUI(yui string) (*core.User, error) {
+	user := &core.User{}
+	query := `
+        SELECT yui, email, phone, password_hash, level, created_at, last_login, is_active, COALESCE(telegram_id, 0), email_verified_at
+        FROM users
+        WHERE yui = $1`
+
+	err := db.conn.QueryRow(query, yui).Scan(
+		&user.YUI, &user.Email, &user.Phone,
+		&user.PasswordHash, &user.Level,
+		&user.CreatedAt, &user.LastLogin, &user.IsActive, &user.TelegramID, &user.EmailVerifiedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -117,36 +300,6 @@ func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
-func (db *DB) SaveOTP(phoneHash, code string, telegramID int64) error {
-	_, err := db.conn.Exec(`
-        INSERT INTO otp_codes (phone_hash, code, expires_at, telegram_id)
-        VALUES ($1, $2, $3, $4)
-        ON CONFLICT (phone_hash) DO UPDATE
-        SET code = $2, expires_at = $3, telegram_id = $4
-    `, phoneHash, code, time.Now().Add(5*time.Minute), telegramID)
-	return err
-}
-
-// CheckOTPCode проверяет, совпадает ли код и не истёк ли он
-func (db *DB) CheckOTPCode(phoneHash, code string) bool {
-	var storedCode string
-	var expiresAt time.Time
-
-	err := db.conn.QueryRow(
-		"SELECT code, expires_at FROM otp_codes WHERE phone_hash = $1",
-		phoneHash,
-	).Scan(&storedCode, &expiresAt)
-
-	if err != nil {
-		return false
-	}
-
-	if time.Now().After(expiresAt) {
-		return false
-	}
-
-	return storedCode == code
-}
 func (db *DB) ActivateUserByPhoneHash(phoneHash string) error {
 	_, err := db.conn.Exec(
 		"UPDATE users SET is_active = true WHERE phone_hash = $1",
@@ -157,14 +310,14 @@ func (db *DB) ActivateUserByPhoneHash(phoneHash string) error {
 func (db *DB) GetUserByPhoneHash(phoneHash string) (*core.User, error) {
 	user := &core.User{}
 	query := `
-        SELECT yui, email, phone, phone_hash, password_hash, level, created_at, last_login, is_active
+        SELECT yui, email, phone, phone_hash, password_hash, level, created_at, last_login, is_active, COALESCE(telegram_id, 0), email_verified_at
         FROM users
         WHERE phone_hash = $1`
 
 	err := db.conn.QueryRow(query, phoneHash).Scan(
 		&user.YUI, &user.Email, &user.Phone, &user.PhoneHash,
 		&user.PasswordHash, &user.Level,
-		&user.CreatedAt, &user.LastLogin, &user.IsActive,
+		&user.CreatedAt, &user.LastLogin, &user.IsActive, &user.TelegramID, &user.EmailVerifiedAt,
 	)
 
 	if err == sql.ErrNoRows {
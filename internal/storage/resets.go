@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrResetTokenInvalid = errors.New("invalid or expired reset token")
+	ErrResetRateLimited  = errors.New("too many password resets")
+)
+
+// CreatePasswordReset сохраняет хэш одноразового токена сброса пароля, если с момента since
+// пользователь запросил меньше limit сбросов. Проверка и вставка идут под блокировкой строки
+// пользователя, поэтому параллельные запросы не обходят лимит.
+func (db *DB) CreatePasswordReset(tokenHash, yui string, expiresAt, since time.Time, limit int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM users WHERE yui = $1 FOR UPDATE`, yui); err != nil {
+		return err
+	}
+
+	var n int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM password_resets WHERE yui = $1 AND created_at > $2`,
+		yui, since,
+	).Scan(&n); err != nil {
+		return err
+	}
+	if n >= limit {
+		return ErrResetRateLimited
+	}
+
+	if _, err := tx.Exec(`
+        INSERT INTO password_resets (token_hash, yui, expires_at)
+        VALUES ($1, $2, $3)`,
+		tokenHash, yui, expiresAt,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetPassword гасит токен и все остальные неиспользованные токены пользователя
+// и ставит новый хэш пароля — всё в одной транзакции. Возвращает YUI владельца.
+func (db *DB) ResetPassword(tokenHash, passwordHash string) (string, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var yui string
+	err = tx.QueryRow(`
+        UPDATE password_resets SET used_at = $2
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+        RETURNING yui`,
+		tokenHash, now,
+	).Scan(&yui)
+	if err == sql.ErrNoRows {
+		return "", ErrResetTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE password_resets SET used_at = $2 WHERE yui = $1 AND used_at IS NULL`,
+		yui, now,
+	); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE users SET password_hash = $1 WHERE yui = $2`,
+		passwordHash, yui,
+	); err != nil {
+		return "", err
+	}
+
+	return yui, tx.Commit()
+}
+
+// DeleteExpiredPasswordResets чистит старые записи (нужны только для лимита запросов)
+func (db *DB) DeleteExpiredPasswordResets(olderThan time.Time) error {
+	_, err := db.conn.Exec(`DELETE FROM password_resets WHERE created_at < $1`, olderThan)
+	return err
+}
+
+func (db *DB) UpdatePasswordHash(yui, passwordHash string) error {
+	_, err := db.conn.Exec(`UPDATE users SET password_hash = $1 WHERE yui = $2`, passwordHash, yui)
+	return err
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"yep-protocol/internal/core"
+
+	"github.com/lib/pq"
+)
+
+var ErrRoomExists = errors.New("room already exists")
+
+// roomCache держит участников комнат в памяти, чтобы не ходить в Postgres на каждое сообщение.
+// Источник правды — таблица room_members: кэш заполняется лениво, а CreateRoom,
+// AddRoomMember и RemoveRoomMember обновляют его после записи в БД.
+type roomCache struct {
+	mu      sync.Mutex
+	members map[string]map[string]bool // room -> set of YUI
+	changes map[string]uint64          // room -> число изменений членства, по нему загрузка узнаёт, что устарела
+}
+
+func newRoomCache() *roomCache {
+	return &roomCache{
+		members: make(map[string]map[string]bool),
+		changes: make(map[string]uint64),
+	}
+}
+
+// CreateRoom создаёт комнату и сразу добавляет создателя в участники
+func (db *DB) CreateRoom(name, createdBy string) (*core.Room, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	room := &core.Room{Name: name, CreatedBy: createdBy}
+	err = tx.QueryRow(
+		"INSERT INTO rooms (name, created_by) VALUES ($1, $2) RETURNING created_at",
+		name, createdBy,
+	).Scan(&room.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrRoomExists
+		}
+		return nil, err
+	}
+
+	if _, err = tx.Exec(
+		"INSERT INTO room_members (room, yui) VALUES ($1, $2)",
+		name, createdBy,
+	); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	db.setRoomMember(name, createdBy, true)
+
+	room.Members = 1
+	return room, nil
+}
+
+func (db *DB) GetRoom(name string) (*core.Room, error) {
+	room := &core.Room{}
+	err := db.conn.QueryRow(`
+        SELECT r.name, r.created_by, r.created_at, COUNT(m.yui)
+        FROM rooms r
+        LEFT JOIN room_members m ON m.room = r.name
+        WHERE r.name = $1
+        GROUP BY r.name`, name,
+	).Scan(&room.Name, &room.CreatedBy, &room.CreatedAt, &room.Members)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("room not found")
+	}
+
+	return room, err
+}
+
+func (db *DB) ListRooms() ([]*core.Room, error) {
+	rows, err := db.conn.Query(`
+        SELECT r.name, r.created_by, r.created_at, COUNT(m.yui)
+        FROM rooms r
+        LEFT JOIN room_members m ON m.room = r.name
+        GROUP BY r.name
+        ORDER BY r.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []*core.Room
+	for rows.Next() {
+		room := &core.Room{}
+		if err := rows.Scan(&room.Name, &room.CreatedBy, &room.CreatedAt, &room.Members); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+func (db *DB) AddRoomMember(room, yui string) error {
+	_, err := db.conn.Exec(`
+        INSERT INTO room_members (room, yui) VALUES ($1, $2)
+        ON CONFLICT (room, yui) DO NOTHING`,
+		room, yui,
+	)
+	if err != nil {
+		return err
+	}
+	db.setRoomMember(room, yui, true)
+	return nil
+}
+
+func (db *DB) RemoveRoomMember(room, yui string) error {
+	_, err := db.conn.Exec(
+		"DELETE FROM room_members WHERE room = $1 AND yui = $2",
+		room, yui,
+	)
+	if err != nil {
+		return err
+	}
+	db.setRoomMember(room, yui, false)
+	return nil
+}
+
+func (db *DB) GetRoomMembers(room string) ([]string, error) {
+	var members []string
+	err := db.withRoomMembers(room, func(set map[string]bool) {
+		members = make([]string, 0, len(set))
+		for yui := range set {
+			members = append(members, yui)
+		}
+	})
+	return members, err
+}
+
+// IsRoomMember проверяет членство в комнате; в lobby состоят все
+func (db *DB) IsRoomMember(room, yui string) (bool, error) {
+	if room == core.LobbyRoom {
+		return true, nil
+	}
+	var member bool
+	err := db.withRoomMembers(room, func(set map[string]bool) {
+		member = set[yui]
+	})
+	return member, err
+}
+
+// withRoomMembers вызывает fn с участниками комнаты под блокировкой кэша.
+// При промахе читает БД; если членство поменялось, пока шло чтение, прочитанное
+// могло устареть — читаем заново, а не кладём в кэш.
+func (db *DB) withRoomMembers(room string, fn func(set map[string]bool)) error {
+	c := db.rooms
+	for {
+		c.mu.Lock()
+		if set, ok := c.members[room]; ok {
+			fn(set)
+			c.mu.Unlock()
+			return nil
+		}
+		version := c.changes[room]
+		c.mu.Unlock()
+
+		members, err := db.queryStrings("SELECT yui FROM room_members WHERE room = $1", room)
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		if c.changes[room] != version {
+			c.mu.Unlock()
+			continue
+		}
+		set := make(map[string]bool, len(members))
+		for _, yui := range members {
+			set[yui] = true
+		}
+		// Пустые не кэшируем: иначе запросы к несуществующим комнатам копились бы в памяти
+		if len(set) > 0 {
+			c.members[room] = set
+		}
+		fn(set)
+		c.mu.Unlock()
+		return nil
+	}
+}
+
+// setRoomMember отражает в кэше изменение, уже записанное в БД
+func (db *DB) setRoomMember(room, yui string, member bool) {
+	c := db.rooms
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.changes[room]++
+	set, ok := c.members[room]
+	if !ok {
+		// Не в кэше — загрузится из БД при следующем обращении
+		return
+	}
+	if member {
+		set[yui] = true
+	} else {
+		delete(set, yui)
+	}
+}
+
+func (db *DB) GetUserRooms(yui string) ([]string, error) {
+	return db.queryStrings("SELECT room FROM room_members WHERE yui = $1 ORDER BY room", yui)
+}
+
+func (db *DB) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
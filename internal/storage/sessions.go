@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"time"
+)
+
+// Session — вход пользователя на устройстве. jti меняется при каждой ротации
+// токенов, SessionID остаётся прежним (совпадает с семейством refresh-токенов).
+type Session struct {
+	JTI       string    `json:"-"`
+	SessionID string    `json:"id"`
+	YUI       string    `json:"-"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (db *DB) CreateSession(s *Session) error {
+	return db.conn.QueryRow(`
+        INSERT INTO sessions (jti, session_id, yui, device, ip, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING created_at, last_seen`,
+		s.JTI, s.SessionID, s.YUI, s.Device, s.IP, s.ExpiresAt,
+	).Scan(&s.CreatedAt, &s.LastSeen)
+}
+
+// IsSessionActive сообщает, что сессия с этим jti не отозвана и не истекла
+func (db *DB) IsSessionActive(jti string) (bool, error) {
+	var active bool
+	err := db.conn.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM sessions
+            WHERE jti = $1 AND revoked_at IS NULL AND expires_at > $2
+        )`,
+		jti, time.Now(),
+	).Scan(&active)
+	return active, err
+}
+
+// TouchSession обновляет last_seen сессии
+func (db *DB) TouchSession(jti string, at time.Time) error {
+	_, err := db.conn.Exec(
+		"UPDATE sessions SET last_seen = $1 WHERE jti = $2 AND revoked_at IS NULL",
+		at, jti,
+	)
+	return err
+}
+
+// RotateSessionJTI привязывает сессию к новому access token; старый jti перестаёт быть валидным
+func (db *DB) RotateSessionJTI(sessionID, newJTI, ip string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(`
+        UPDATE sessions
+        SET jti = $1, expires_at = $2, last_seen = $3, ip = COALESCE(NULLIF($4, ''), ip)
+        WHERE session_id = $5 AND revoked_at IS NULL`,
+		newJTI, expiresAt, time.Now(), ip, sessionID,
+	)
+	return err
+}
+
+func (db *DB) ListSessions(yui string) ([]*Session, error) {
+	rows, err := db.conn.Query(`
+        SELECT jti, session_id, yui, COALESCE(device, ''), COALESCE(ip, ''), created_at, last_seen, expires_at
+        FROM sessions
+        WHERE yui = $1 AND revoked_at IS NULL AND expires_at > $2
+        ORDER BY last_seen DESC`,
+		yui, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s := &Session{}
+		if err := rows.Scan(&s.JTI, &s.SessionID, &s.YUI, &s.Device, &s.IP, &s.CreatedAt, &s.LastSeen, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession отзывает сессию пользователя. Возвращает false, если такой живой сессии нет.
+func (db *DB) RevokeSession(yui, sessionID string) (bool, error) {
+	res, err := db.conn.Exec(`
+        UPDATE sessions SET revoked_at = $1
+        WHERE yui = $2 AND session_id = $3 AND revoked_at IS NULL`,
+		time.Now(), yui, sessionID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeAllSessions отзывает все сессии пользователя, кроме exceptSessionID (если задан).
+// Возвращает id отозванных сессий.
+func (db *DB) RevokeAllSessions(yui, exceptSessionID string) ([]string, error) {
+	return db.queryStrings(`
+        UPDATE sessions SET revoked_at = $1
+        WHERE yui = $2 AND session_id <> $3 AND revoked_at IS NULL
+        RETURNING session_id`,
+		time.Now(), yui, exceptSessionID,
+	)
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"yep-protocol/internal/core"
+)
+
+// LinkTelegramID привязывает Telegram-аккаунт к пользователю с этим номером.
+// Аккаунт Telegram принадлежит одному пользователю: старая привязка снимается.
+func (db *DB) LinkTelegramID(phoneHash string, telegramID int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE users SET telegram_id = NULL WHERE telegram_id = $1 AND phone_hash IS DISTINCT FROM $2`,
+		telegramID, phoneHash,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`UPDATE users SET telegram_id = $1 WHERE phone_hash = $2`,
+		telegramID, phoneHash,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) GetUserByTelegramID(telegramID int64) (*core.User, error) {
+	user := &core.User{}
+	query := `
+        SELECT yui, email, COALESCE(phone, ''), COALESCE(phone_hash, ''), password_hash, level, created_at, last_login, is_active, telegram_id, email_verified_at
+        FROM users
+        WHERE telegram_id = $1`
+
+	err := db.conn.QueryRow(query, telegramID).Scan(
+		&user.YUI, &user.Email, &user.Phone, &user.PhoneHash,
+		&user.PasswordHash, &user.Level,
+		&user.CreatedAt, &user.LastLogin, &user.IsActive, &user.TelegramID, &user.EmailVerifiedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return user, err
+}
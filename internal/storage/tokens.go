@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrTokenNotFound = errors.New("refresh token not found")
+	ErrTokenReused   = errors.New("refresh token already used")
+)
+
+// RefreshToken — запись о выданном refresh-токене (храним только хэш)
+type RefreshToken struct {
+	TokenHash string
+	FamilyID  string
+	YUI       string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	RevokedAt sql.NullTime
+}
+
+func (db *DB) SaveRefreshToken(tokenHash, familyID, yui string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(`
+        INSERT INTO refresh_tokens (token_hash, family_id, yui, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+		tokenHash, familyID, yui, expiresAt,
+	)
+	return err
+}
+
+func (db *DB) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	t := &RefreshToken{}
+	err := db.conn.QueryRow(`
+        SELECT token_hash, family_id, yui, expires_at, used_at, revoked_at
+        FROM refresh_tokens
+        WHERE token_hash = $1`, tokenHash,
+	).Scan(&t.TokenHash, &t.FamilyID, &t.YUI, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	return t, err
+}
+
+// MarkRefreshTokenUsed атомарно помечает токен использованным.
+// Если токен уже использован или отозван, возвращает ErrTokenReused.
+func (db *DB) MarkRefreshTokenUsed(tokenHash string) error {
+	res, err := db.conn.Exec(`
+        UPDATE refresh_tokens SET used_at = $1
+        WHERE token_hash = $2 AND used_at IS NULL AND revoked_at IS NULL`,
+		time.Now(), tokenHash,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTokenReused
+	}
+	return nil
+}
+
+// RevokeTokenFamily отзывает всю цепочку ротаций
+func (db *DB) RevokeTokenFamily(familyID string) error {
+	_, err := db.conn.Exec(
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL",
+		time.Now(), familyID,
+	)
+	return err
+}
+
+// RevokeUserRefreshTokens отзывает все refresh-токены пользователя
+func (db *DB) RevokeUserRefreshTokens(yui string) error {
+	_, err := db.conn.Exec(
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE yui = $2 AND revoked_at IS NULL",
+		time.Now(), yui,
+	)
+	return err
+}
+
+// DeleteExpiredRefreshTokens чистит просроченные записи
+func (db *DB) DeleteExpiredRefreshTokens() error {
+	_, err := db.conn.Exec("DELETE FROM refresh_tokens WHERE expires_at < $1", time.Now())
+	return err
+}
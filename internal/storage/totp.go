@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrTOTPNotFound = errors.New("totp is not enrolled")
+
+// TOTP — секрет второго фактора; ConfirmedAt == nil — подключение не завершено
+type TOTP struct {
+	YUI         string
+	Secret      string // base32
+	ConfirmedAt *time.Time
+	LastStep    int64 // последний принятый шаг: один код нельзя использовать дважды
+}
+
+func (db *DB) GetTOTP(yui string) (*TOTP, error) {
+	t := &TOTP{YUI: yui}
+	var confirmed sql.NullTime
+	err := db.conn.QueryRow(
+		`SELECT secret, confirmed_at, last_step FROM user_totp WHERE yui = $1`, yui,
+	).Scan(&t.Secret, &confirmed, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, ErrTOTPNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if confirmed.Valid {
+		t.ConfirmedAt = &confirmed.Time
+	}
+	return t, nil
+}
+
+// SaveTOTPSecret начинает подключение; подтверждённый секрет не перезаписывается
+func (db *DB) SaveTOTPSecret(yui, secret string) (bool, error) {
+	res, err := db.conn.Exec(`
+        INSERT INTO user_totp (yui, secret) VALUES ($1, $2)
+        ON CONFLICT (yui) DO UPDATE
+        SET secret = EXCLUDED.secret, last_step = 0, created_at = CURRENT_TIMESTAMP
+        WHERE user_totp.confirmed_at IS NULL`,
+		yui, secret,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseTOTPStep атомарно принимает шаг, если он новее последнего (защита от повтора кода)
+func (db *DB) UseTOTPStep(yui string, step int64) (bool, error) {
+	res, err := db.conn.Exec(
+		`UPDATE user_totp SET last_step = $2 WHERE yui = $1 AND last_step < $2`,
+		yui, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ConfirmTOTP завершает подключение и заменяет коды восстановления
+func (db *DB) ConfirmTOTP(yui string, recoveryHashes []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE user_totp SET confirmed_at = $2 WHERE yui = $1`, yui, time.Now()); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, yui, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes выдаёт новый набор кодов восстановления, старые перестают работать
+func (db *DB) ReplaceRecoveryCodes(yui string, hashes []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, yui, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, yui string, hashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE yui = $1`, yui); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(
+			`INSERT INTO recovery_codes (yui, code_hash) VALUES ($1, $2)`, yui, h,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode гасит код восстановления; false — кода нет или он уже использован
+func (db *DB) UseRecoveryCode(yui, codeHash string) (bool, error) {
+	res, err := db.conn.Exec(`
+        UPDATE recovery_codes SET used_at = $3
+        WHERE yui = $1 AND code_hash = $2 AND used_at IS NULL`,
+		yui, codeHash, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// CountRecoveryCodes — сколько неиспользованных кодов осталось
+func (db *DB) CountRecoveryCodes(yui string) (int, error) {
+	var n int
+	err := db.conn.QueryRow(
+		`SELECT COUNT(*) FROM recovery_codes WHERE yui = $1 AND used_at IS NULL`, yui,
+	).Scan(&n)
+	return n, err
+}
+
+// DeleteTOTP отключает второй фактор вместе с кодами восстановления
+func (db *DB) DeleteTOTP(yui string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE yui = $1`, yui); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE yui = $1`, yui); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
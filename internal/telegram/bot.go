@@ -0,0 +1,240 @@
+// Package telegram — встроенный бот верификации: пользователь делится контактом,
+// бот считает phone_hash, привязывает telegram_id и присылает код подтверждения.
+package telegram
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/notify"
+	"yep-protocol/internal/storage"
+)
+
+// Сколько держим long polling запрос getUpdates
+const pollTimeout = 30 * time.Second
+
+// Bot — клиент Telegram Bot API
+type Bot struct {
+	apiURL string // <base>/bot<token>
+	client *http.Client
+	auth   *auth.Service
+	db     *storage.DB
+
+	webhookSecret string
+}
+
+// NewBot; baseURL пустой — api.telegram.org (для тестов подставляется локальный фейк)
+func NewBot(token, baseURL string, db *storage.DB, authService *auth.Service) *Bot {
+	if baseURL == "" {
+		baseURL = "https://api.telegram.org"
+	}
+	return &Bot{
+		apiURL: strings.TrimRight(baseURL, "/") + "/bot" + token,
+		client: &http.Client{Timeout: pollTimeout + 10*time.Second},
+		auth:   authService,
+		db:     db,
+	}
+}
+
+// Типы Bot API, нужные боту
+
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message,omitempty"`
+}
+
+type Message struct {
+	MessageID int64    `json:"message_id"`
+	From      *User    `json:"from,omitempty"`
+	Chat      Chat     `json:"chat"`
+	Text      string   `json:"text,omitempty"`
+	Contact   *Contact `json:"contact,omitempty"`
+}
+
+type User struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username,omitempty"`
+}
+
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+type Contact struct {
+	PhoneNumber string `json:"phone_number"`
+	UserID      int64  `json:"user_id,omitempty"`
+}
+
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Description string          `json:"description,omitempty"`
+}
+
+// call выполняет метод Bot API с JSON-параметрами
+func (b *Bot) call(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.client.Post(b.apiURL+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("%s: status %d: %w", method, resp.StatusCode, err)
+	}
+	if !r.OK {
+		return fmt.Errorf("%s: %s", method, r.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(r.Result, result)
+	}
+	return nil
+}
+
+// StartPolling получает обновления через getUpdates в фоне
+func (b *Bot) StartPolling() {
+	go func() {
+		// Webhook и getUpdates несовместимы: снимаем webhook, если он был
+		if err := b.call("deleteWebhook", map[string]interface{}{}, nil); err != nil {
+			log.Printf("Telegram deleteWebhook failed: %v", err)
+		}
+
+		var offset int64
+		for {
+			var updates []Update
+			err := b.call("getUpdates", map[string]interface{}{
+				"offset":          offset,
+				"timeout":         int(pollTimeout.Seconds()),
+				"allowed_updates": []string{"message"},
+			}, &updates)
+			if err != nil {
+				log.Printf("Telegram getUpdates failed: %v", err)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			for _, u := range updates {
+				offset = u.UpdateID + 1
+				b.handleUpdate(u)
+			}
+		}
+	}()
+}
+
+// SetWebhook просит Telegram присылать обновления на url; secret проверяется в HandleWebhook
+func (b *Bot) SetWebhook(url, secret string) error {
+	b.webhookSecret = secret
+	return b.call("setWebhook", map[string]interface{}{
+		"url":             url,
+		"secret_token":    secret,
+		"allowed_updates": []string{"message"},
+	}, nil)
+}
+
+// HandleWebhook принимает обновления от Telegram
+func (b *Bot) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if b.webhookSecret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(b.webhookSecret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var u Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&u); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+	b.handleUpdate(u)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (b *Bot) handleUpdate(u Update) {
+	msg := u.Message
+	if msg == nil || msg.From == nil {
+		return
+	}
+
+	if msg.Contact != nil {
+		b.handleContact(msg)
+		return
+	}
+
+	// Любой текст (/start в том числе) — просим поделиться номером
+	b.askContact(msg.Chat.ID)
+}
+
+func (b *Bot) askContact(chatID int64) {
+	b.send(chatID, "To get your YEP verification code, share your phone number with the button below.", map[string]interface{}{
+		"keyboard": [][]map[string]interface{}{
+			{{"text": "📱 Share phone number", "request_contact": true}},
+		},
+		"resize_keyboard":   true,
+		"one_time_keyboard": true,
+	})
+}
+
+// handleContact: номер берём только из собственного контакта пользователя
+func (b *Bot) handleContact(msg *Message) {
+	if msg.Contact.UserID != msg.From.ID {
+		b.send(msg.Chat.ID, "Please share your own number with the button, not someone else's contact.", nil)
+		return
+	}
+
+	phoneHash := auth.HashPhone(msg.Contact.PhoneNumber)
+	if _, err := b.db.GetUserByPhoneHash(phoneHash); err != nil {
+		b.send(msg.Chat.ID, "This number is not registered. Sign up in YEP with this number first.", nil)
+		return
+	}
+
+	// telegram_id привязывается к номеру вместе с кодом
+	code, err := b.auth.IssueOTP(phoneHash, msg.From.ID)
+	if err != nil {
+		var locked *auth.LockedError
+		if errors.As(err, &locked) {
+			b.send(msg.Chat.ID, "Too many attempts. Please try again later.", nil)
+			return
+		}
+		log.Printf("Telegram bot: failed to issue OTP: %v", err)
+		b.send(msg.Chat.ID, "Failed to create a code, please try again.", nil)
+		return
+	}
+
+	b.send(msg.Chat.ID, fmt.Sprintf("Your YEP code: %s\nValid for %d min. Do not share it with anyone.",
+		code, int(b.auth.OTPTTL().Minutes())), map[string]interface{}{"remove_keyboard": true})
+}
+
+// Notify отправляет служебное сообщение в Telegram, если аккаунт привязан (notify.Notifier)
+func (b *Bot) Notify(user *core.User, subject, text string) error {
+	if user.TelegramID == 0 {
+		return notify.ErrNoChannel
+	}
+	return b.call("sendMessage", map[string]interface{}{
+		"chat_id": user.TelegramID,
+		"text":    subject + "\n\n" + text,
+	}, nil)
+}
+
+func (b *Bot) send(chatID int64, text string, markup interface{}) {
+	params := map[string]interface{}{"chat_id": chatID, "text": text}
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+	if err := b.call("sendMessage", params, nil); err != nil {
+		log.Printf("Telegram sendMessage failed: %v", err)
+	}
+}
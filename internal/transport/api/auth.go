@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/middleware"
+)
+
+type AuthHandler struct {
+	auth             *auth.Service
+	telegramBotToken string // для проверки Telegram Login Widget; пусто — вход через Telegram выключен
+}
+
+func NewAuthHandler(authService *auth.Service, telegramBotToken string) *AuthHandler {
+	return &AuthHandler{auth: authService, telegramBotToken: telegramBotToken}
+}
+
+// HandleRefresh: POST {"refresh_token": "..."} -> новая пара токенов.
+// Публичный маршрут: access token к этому моменту обычно уже истёк.
+func (h *AuthHandler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use POST")
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "refresh_token is required")
+		return
+	}
+
+	tokens, _, err := h.auth.RefreshTokens(req.RefreshToken, auth.SessionMeta{
+		Device: r.UserAgent(),
+		IP:     middleware.ClientIP(r),
+	})
+	switch {
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		middleware.WriteError(w, http.StatusUnauthorized, "token_reused", "refresh token was already used, all sessions of this chain are revoked")
+		return
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		middleware.WriteError(w, http.StatusUnauthorized, "invalid_token", "invalid or expired refresh token")
+		return
+	case err != nil:
+		log.Printf("Failed to refresh tokens: %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to refresh tokens")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// HandleJWKS отдаёт публичные ключи проверки подписи (только асимметричные)
+func (h *AuthHandler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.PublicJWKS())
+}
+
+// HandleTelegramLogin: вход через Telegram Login Widget.
+// Данные виджета приходят query-параметрами (GET, data-auth-url) или JSON-объектом (POST).
+func (h *AuthHandler) HandleTelegramLogin(w http.ResponseWriter, r *http.Request) {
+	if h.telegramBotToken == "" {
+		middleware.WriteError(w, http.StatusServiceUnavailable, "not_configured", "telegram login is not configured")
+		return
+	}
+
+	fields := make(map[string]string)
+	switch r.Method {
+	case http.MethodGet:
+		for k, v := range r.URL.Query() {
+			fields[k] = v[0]
+		}
+	case http.MethodPost:
+		var raw map[string]interface{}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<10))
+		dec.UseNumber() // id и auth_date должны войти в проверку подписи как есть
+		if err := dec.Decode(&raw); err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, "bad_request", "invalid JSON body")
+			return
+		}
+		for k, v := range raw {
+			switch v := v.(type) {
+			case string:
+				fields[k] = v
+			case json.Number:
+				fields[k] = v.String()
+			default:
+				middleware.WriteError(w, http.StatusBadRequest, "bad_request", "unexpected field "+k)
+				return
+			}
+		}
+	default:
+		middleware.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST")
+		return
+	}
+
+	identity, err := auth.VerifyTelegramLogin(h.telegramBotToken, fields, auth.TelegramAuthMaxAge)
+	switch {
+	case errors.Is(err, auth.ErrTelegramAuthExpired):
+		middleware.WriteError(w, http.StatusUnauthorized, "auth_expired", "telegram login data is too old, please sign in again")
+		return
+	case err != nil:
+		middleware.WriteError(w, http.StatusUnauthorized, "invalid_signature", "invalid telegram login data")
+		return
+	}
+
+	user, created, err := h.auth.LoginWithTelegram(identity)
+	if err != nil {
+		log.Printf("Telegram login for %d failed: %v", identity.ID, err)
+		middleware.WriteError(w, http.StatusForbidden, "login_failed", "cannot sign in with this telegram account")
+		return
+	}
+
+	// Виджет не умеет спрашивать второй фактор — такие аккаунты входят через WebSocket
+	enrolled, err := h.auth.TOTPEnabled(user.YUI)
+	if err != nil {
+		log.Printf("Failed to check 2FA for %s: %v", user.YUI, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to sign in")
+		return
+	}
+	if enrolled || h.auth.TOTPRequiredByPolicy(user) {
+		middleware.WriteError(w, http.StatusForbidden, "totp_required", "two-factor authentication is enabled, sign in with your password and code")
+		return
+	}
+
+	tokens, err := h.auth.StartSession(user, auth.SessionMeta{
+		Device: r.UserAgent(),
+		IP:     middleware.ClientIP(r),
+	}, auth.LoginTelegram)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to issue tokens")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*auth.TokenPair
+		YUI     string `json:"yui"`
+		Level   string `json:"level"`
+		Created bool   `json:"created"`
+	}{tokens, user.YUI, user.Level, created})
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/middleware"
+	"yep-protocol/internal/storage"
+)
+
+// EmailHandler — подтверждение владения email
+type EmailHandler struct {
+	auth *auth.Service
+	db   *storage.DB
+}
+
+func NewEmailHandler(authService *auth.Service, db *storage.DB) *EmailHandler {
+	return &EmailHandler{auth: authService, db: db}
+}
+
+// HandleVerifyLink: GET ?token=... (ссылка из письма) или POST {"token"}. Публичный маршрут.
+func (h *EmailHandler) HandleVerifyLink(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req)
+		token = req.Token
+	default:
+		middleware.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST")
+		return
+	}
+	if token == "" {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "token is required")
+		return
+	}
+
+	yui, err := h.auth.ConfirmEmailToken(token)
+	if errors.Is(err, auth.ErrEmailTokenInvalid) {
+		middleware.WriteError(w, http.StatusBadRequest, "invalid_token", err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to confirm email: %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to verify email")
+		return
+	}
+
+	log.Printf("📧 Email verified for %s", yui)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"verified": true, "yui": yui})
+}
+
+// HandleStatus: GET /api/email — адрес и подтверждён ли он
+func (h *EmailHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	user, err := h.db.GetUserByYUI(claims.YUI)
+	if err != nil {
+		middleware.WriteError(w, http.StatusUnauthorized, "unauthorized", "user not found")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"email":    user.Email,
+		"verified": user.EmailVerifiedAt.Valid,
+		"required": h.auth.EmailVerificationRequired(user),
+	}
+	if user.EmailVerifiedAt.Valid {
+		resp["verified_at"] = user.EmailVerifiedAt.Time
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleResend: POST /api/email/verify/resend — отправить письмо ещё раз
+func (h *EmailHandler) HandleResend(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	user, err := h.db.GetUserByYUI(claims.YUI)
+	if err != nil {
+		middleware.WriteError(w, http.StatusUnauthorized, "unauthorized", "user not found")
+		return
+	}
+
+	err = h.auth.SendEmailVerification(user)
+	switch {
+	case errors.Is(err, auth.ErrEmailAlreadyVerified):
+		middleware.WriteError(w, http.StatusConflict, "already_verified", err.Error())
+		return
+	case errors.Is(err, auth.ErrEmailUndeliverable):
+		middleware.WriteError(w, http.StatusBadRequest, "no_email", err.Error())
+		return
+	case errors.Is(err, auth.ErrEmailRateLimited):
+		middleware.WriteError(w, http.StatusTooManyRequests, "rate_limited", err.Error())
+		return
+	case errors.Is(err, auth.ErrEmailNotConfigured):
+		middleware.WriteError(w, http.StatusServiceUnavailable, "not_configured", err.Error())
+		return
+	case err != nil:
+		log.Printf("Email verification for %s failed: %v", user.YUI, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// HandleVerifyCode: POST /api/email/verify {"code"} — код из письма
+func (h *EmailHandler) HandleVerifyCode(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.auth.ConfirmEmailCode(claims.YUI, code)
+	var locked *auth.LockedError
+	switch {
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+		middleware.WriteError(w, http.StatusTooManyRequests, "locked", err.Error())
+		return
+	case errors.Is(err, auth.ErrEmailTokenInvalid):
+		middleware.WriteError(w, http.StatusBadRequest, "invalid_code", err.Error())
+		return
+	case err != nil:
+		log.Printf("Email check failed for %s: %v", claims.YUI, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to verify email")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"verified": true})
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/middleware"
+	"yep-protocol/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type HistoryHandler struct {
+	db      *storage.DB
+	mongodb *storage.MongoDB
+}
+
+func NewHistoryHandler(db *storage.DB, mongodb *storage.MongoDB) *HistoryHandler {
+	return &HistoryHandler{
+		db:      db,
+		mongodb: mongodb,
+	}
+}
+
+// HistoryPage — ответ /api/messages/history
+type HistoryPage struct {
+	Messages   []core.YepMessage `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	HasMore    bool              `json:"has_more"`
+}
+
+// pageCursor — содержимое непрозрачного токена next_cursor
+type pageCursor struct {
+	Kind    string `json:"k"` // "id" или "seq"
+	Value   string `json:"v"`
+	Forward bool   `json:"f"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// HandleMessages — последние 50 сообщений пользователя (старый формат ответа: массив)
+func (h *HistoryHandler) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	yui, ok := middleware.OwnerYUI(r, r.URL.Query().Get("yui"))
+	if !ok {
+		middleware.WriteError(w, http.StatusForbidden, "forbidden", "access to another user's history is denied")
+		return
+	}
+
+	messages, err := h.mongodb.GetMessageHistory(yui, defaultPageSize)
+	if err != nil {
+		log.Printf("Failed to load history: %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to load history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// HandleHistory отдаёт историю постранично.
+//
+// Параметры: yui (необязателен, только свой), peer | room, cursor | before / after (ObjectID или seq),
+// direction (backward | forward), limit, since / until (RFC3339 или unix).
+func (h *HistoryHandler) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	// Историю можно читать только свою
+	yui, ok := middleware.OwnerYUI(r, q.Get("yui"))
+	if !ok {
+		middleware.WriteError(w, http.StatusForbidden, "forbidden", "access to another user's history is denied")
+		return
+	}
+
+	query := storage.HistoryQuery{
+		YUI:  yui,
+		Peer: q.Get("peer"),
+		Room: q.Get("room"),
+	}
+	if query.Peer != "" && query.Room != "" {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "peer and room are mutually exclusive")
+		return
+	}
+	if query.Room != "" {
+		query.Room = strings.ToLower(query.Room)
+		member, err := h.db.IsRoomMember(query.Room, query.YUI)
+		if err != nil {
+			log.Printf("Failed to check membership in %s: %v", query.Room, err)
+			middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to load history")
+			return
+		}
+		if !member {
+			middleware.WriteError(w, http.StatusForbidden, "forbidden", "not a member of this room")
+			return
+		}
+	}
+
+	// Размер страницы
+	query.Limit = defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			middleware.WriteError(w, http.StatusBadRequest, "bad_request", "invalid limit")
+			return
+		}
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+		query.Limit = n
+	}
+
+	// Направление
+	switch q.Get("direction") {
+	case "", "backward":
+	case "forward":
+		query.Forward = true
+	default:
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "direction must be backward or forward")
+		return
+	}
+
+	// Курсоры: токен из прошлого ответа важнее явных before/after
+	if token := q.Get("cursor"); token != "" {
+		c, err := decodeCursor(token)
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		query.Forward = c.Forward
+		param := "before"
+		if c.Forward {
+			param = "after"
+		}
+		if err := applyCursor(&query, param, c.Value, c.Kind); err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+	} else {
+		before, after := q.Get("before"), q.Get("after")
+		if before != "" {
+			if err := applyCursor(&query, "before", before, ""); err != nil {
+				middleware.WriteError(w, http.StatusBadRequest, "bad_request", err.Error())
+				return
+			}
+		}
+		if after != "" {
+			if err := applyCursor(&query, "after", after, ""); err != nil {
+				middleware.WriteError(w, http.StatusBadRequest, "bad_request", err.Error())
+				return
+			}
+			// Только after — листаем вперёд
+			if before == "" && q.Get("direction") == "" {
+				query.Forward = true
+			}
+		}
+	}
+	if query.BySeq && query.Peer == "" && query.Room == "" {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "seq cursors require peer or room")
+		return
+	}
+
+	// Временной диапазон
+	var err error
+	if query.Since, err = parseTime(q.Get("since")); err != nil {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "invalid since")
+		return
+	}
+	if query.Until, err = parseTime(q.Get("until")); err != nil {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "invalid until")
+		return
+	}
+
+	// Берём на одно больше, чтобы понять, есть ли следующая страница
+	pageSize := query.Limit
+	query.Limit++
+
+	messages, err := h.mongodb.QueryHistory(query)
+	if err != nil {
+		log.Printf("Failed to query history: %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to load history")
+		return
+	}
+
+	var page HistoryPage
+	if int64(len(messages)) > pageSize {
+		messages = messages[:pageSize]
+		page.HasMore = true
+
+		last := messages[len(messages)-1]
+		next := pageCursor{Kind: "id", Value: last.ID.Hex(), Forward: query.Forward}
+		if query.BySeq {
+			next = pageCursor{Kind: "seq", Value: strconv.FormatInt(last.Seq, 10), Forward: query.Forward}
+		}
+		page.NextCursor = encodeCursor(next)
+	}
+	page.Messages = make([]core.YepMessage, 0, len(messages))
+	for _, m := range messages {
+		page.Messages = append(page.Messages, m.ToYepMessage())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// applyCursor разбирает значение курсора: 24 hex-символа — ObjectID, число — seq
+func applyCursor(query *storage.HistoryQuery, param, value, kind string) error {
+	if kind == "" {
+		kind = "seq"
+		if len(value) == 24 {
+			kind = "id"
+		}
+	}
+
+	switch kind {
+	case "id":
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s cursor", param)
+		}
+		if param == "before" {
+			query.BeforeID = id
+		} else {
+			query.AfterID = id
+		}
+	case "seq":
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seq <= 0 {
+			return fmt.Errorf("invalid %s cursor", param)
+		}
+		if param == "before" {
+			query.BeforeSeq = seq
+		} else {
+			query.AfterSeq = seq
+		}
+		query.BySeq = true
+	default:
+		return fmt.Errorf("invalid %s cursor", param)
+	}
+	return nil
+}
+
+// parseTime принимает RFC3339 или unix-секунды; пустая строка — нулевое время
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
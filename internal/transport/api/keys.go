@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"yep-protocol/internal/e2e"
+	"yep-protocol/internal/middleware"
+	"yep-protocol/internal/storage"
+)
+
+var deviceIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// KeysHandler — каталог публичных ключей устройств для E2E-сообщений
+type KeysHandler struct {
+	db *storage.DB
+}
+
+func NewKeysHandler(db *storage.DB) *KeysHandler {
+	return &KeysHandler{db: db}
+}
+
+// HandleUpload: PUT /api/keys — загрузить ключи своего устройства и новый подписанный prekey
+func (h *KeysHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	var bundle e2e.Bundle
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&bundle); err != nil {
+		middleware.WriteError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		return
+	}
+	if !deviceIDRe.MatchString(bundle.DeviceID) {
+		middleware.WriteError(w, http.StatusBadRequest, "invalid_request", "invalid device_id")
+		return
+	}
+	if err := e2e.VerifyBundle(&bundle); err != nil {
+		msg := "identity_key, signing_key and signed_prekey are required"
+		if errors.Is(err, e2e.ErrBadSignature) || errors.Is(err, e2e.ErrIdentityMismatch) {
+			msg = err.Error()
+		}
+		middleware.WriteError(w, http.StatusBadRequest, "invalid_keys", msg)
+		return
+	}
+
+	keys := &storage.DeviceKeys{
+		YUI:         claims.YUI,
+		DeviceID:    bundle.DeviceID,
+		IdentityKey: bundle.IdentityKey,
+		SigningKey:  bundle.SigningKey,
+		SignedPrekey: &storage.SignedPrekey{
+			KeyID:     bundle.SignedPrekey.KeyID,
+			PublicKey: bundle.SignedPrekey.PublicKey,
+			Signature: bundle.SignedPrekey.Signature,
+		},
+	}
+	if err := h.db.SaveDeviceKeys(keys); err != nil {
+		log.Printf("Failed to save keys of %s/%s: %v", claims.YUI, bundle.DeviceID, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to save keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// HandleBundles: GET /api/keys/{yui} — бандлы всех устройств пользователя
+func (h *KeysHandler) HandleBundles(w http.ResponseWriter, r *http.Request) {
+	yui := r.PathValue("yui")
+
+	bundles, err := h.db.GetKeyBundles(yui)
+	if err != nil {
+		log.Printf("Failed to load keys of %s: %v", yui, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to load keys")
+		return
+	}
+	if len(bundles) == 0 {
+		middleware.WriteError(w, http.StatusNotFound, "not_found", "user has no registered devices")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"yui": yui, "devices": bundles})
+}
+
+// HandleDelete: DELETE /api/keys/{device} — убрать своё устройство из каталога
+func (h *KeysHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	deviceID := r.PathValue("device")
+
+	deleted, err := h.db.DeleteDeviceKeys(claims.YUI, deviceID)
+	if err != nil {
+		log.Printf("Failed to delete keys of %s/%s: %v", claims.YUI, deviceID, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to delete keys")
+		return
+	}
+	if !deleted {
+		middleware.WriteError(w, http.StatusNotFound, "not_found", "device not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/middleware"
+
+	"golang.org/x/time/rate"
+)
+
+// PasswordHandler — сброс пароля без входа в аккаунт
+type PasswordHandler struct {
+	auth    *auth.Service
+	closer  SessionCloser
+	limiter *middleware.IPRateLimiter
+}
+
+func NewPasswordHandler(authService *auth.Service, closer SessionCloser) *PasswordHandler {
+	return &PasswordHandler{
+		auth:   authService,
+		closer: closer,
+		// 5 запросов подряд, дальше один раз в 3 минуты с одного IP
+		limiter: middleware.NewIPRateLimiter(rate.Every(3*time.Minute), 5),
+	}
+}
+
+func (h *PasswordHandler) allow(w http.ResponseWriter, r *http.Request) bool {
+	if !h.limiter.GetLimiter(middleware.ClientIP(r)).Allow() {
+		middleware.WriteError(w, http.StatusTooManyRequests, "rate_limited", "too many requests, try again later")
+		return false
+	}
+	return true
+}
+
+// HandleResetRequest: POST /api/auth/password/reset {"email"} или {"phone_hash"}.
+// Всегда 202: по ответу нельзя узнать, существует ли аккаунт.
+func (h *PasswordHandler) HandleResetRequest(w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+
+	var req struct {
+		Email     string `json:"email"`
+		PhoneHash string `json:"phone_hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Email == "") == (req.PhoneHash == "") {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "either email or phone_hash is required")
+		return
+	}
+
+	h.auth.RequestPasswordReset(req.Email, req.PhoneHash)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "If the account exists, reset instructions have been sent",
+	})
+}
+
+// HandleResetConfirm: POST /api/auth/password/reset/confirm {"token", "password"}
+func (h *PasswordHandler) HandleResetConfirm(w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "token and password are required")
+		return
+	}
+
+	yui, err := h.auth.ConfirmPasswordReset(req.Token, req.Password)
+	switch {
+	case errors.Is(err, auth.ErrWeakPassword):
+		middleware.WriteError(w, http.StatusBadRequest, "weak_password", err.Error())
+		return
+	case errors.Is(err, auth.ErrResetTokenInvalid):
+		middleware.WriteError(w, http.StatusBadRequest, "invalid_token", "invalid or expired reset token")
+		return
+	case err != nil && yui == "":
+		log.Printf("Password reset failed: %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to reset password")
+		return
+	case err != nil:
+		// Пароль уже сменён — ошибка только в отзыве сессий
+		log.Printf("Password reset for %s: %v", yui, err)
+	}
+
+	h.closer.CloseUserSessions(yui, "")
+	w.WriteHeader(http.StatusNoContent)
+}
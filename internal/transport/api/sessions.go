@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/middleware"
+	"yep-protocol/internal/storage"
+)
+
+// SessionCloser закрывает живые WebSocket-соединения отозванных сессий
+type SessionCloser = auth.SessionCloser
+
+type SessionsHandler struct {
+	auth   *auth.Service
+	closer SessionCloser
+}
+
+func NewSessionsHandler(authService *auth.Service, closer SessionCloser) *SessionsHandler {
+	return &SessionsHandler{
+		auth:   authService,
+		closer: closer,
+	}
+}
+
+type sessionView struct {
+	ID        string `json:"id"`
+	Device    string `json:"device"`
+	IP        string `json:"ip"`
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	Current   bool   `json:"current"`
+}
+
+// HandleList: GET /api/sessions — активные сессии текущего пользователя
+func (h *SessionsHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	sessions, err := h.auth.ListSessions(claims.YUI)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to list sessions")
+		return
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, sessionView{
+			ID:        s.SessionID,
+			Device:    s.Device,
+			IP:        s.IP,
+			CreatedAt: s.CreatedAt.Unix(),
+			LastSeen:  s.LastSeen.Unix(),
+			Current:   s.SessionID == claims.SessionID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": views})
+}
+
+// HandleRevoke: DELETE /api/sessions/{id} — отозвать одну сессию
+func (h *SessionsHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	sessionID := r.PathValue("id")
+
+	revoked, err := h.auth.RevokeSession(claims.YUI, sessionID)
+	if err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to revoke session")
+		return
+	}
+	if !revoked {
+		middleware.WriteError(w, http.StatusNotFound, "not_found", "session not found")
+		return
+	}
+
+	h.closer.CloseAuthSession(claims.YUI, sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRevokeAll: DELETE /api/sessions[?except_current=true] — отозвать все сессии
+func (h *SessionsHandler) HandleRevokeAll(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	except := ""
+	if r.URL.Query().Get("except_current") == "true" {
+		except = claims.SessionID
+	}
+
+	revoked, err := h.auth.RevokeAllSessions(claims.YUI, except)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of %s: %v", claims.YUI, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to revoke sessions")
+		return
+	}
+
+	h.closer.CloseUserSessions(claims.YUI, except)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": len(revoked)})
+}
+
+// HandleLoginHistory: GET /api/logins — последние входы в аккаунт, включая неудачные
+func (h *SessionsHandler) HandleLoginHistory(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	logins, err := h.auth.LoginHistory(claims.YUI, 50)
+	if err != nil {
+		log.Printf("Failed to load login history of %s: %v", claims.YUI, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to load login history")
+		return
+	}
+	if logins == nil {
+		logins = []*storage.LoginAttempt{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"logins": logins})
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/middleware"
+	"yep-protocol/internal/storage"
+)
+
+// TwoFactorHandler — подключение и отключение TOTP, коды восстановления
+type TwoFactorHandler struct {
+	auth *auth.Service
+	db   *storage.DB
+}
+
+func NewTwoFactorHandler(authService *auth.Service, db *storage.DB) *TwoFactorHandler {
+	return &TwoFactorHandler{auth: authService, db: db}
+}
+
+// HandleStatus: GET /api/2fa — включён ли второй фактор и обязателен ли он
+func (h *TwoFactorHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+
+	enabled, err := h.auth.TOTPEnabled(user.YUI)
+	if err != nil {
+		log.Printf("Failed to check 2FA for %s: %v", user.YUI, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to load two-factor status")
+		return
+	}
+	left := 0
+	if enabled {
+		if left, err = h.auth.RecoveryCodesLeft(user.YUI); err != nil {
+			log.Printf("Failed to count recovery codes of %s: %v", user.YUI, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":             enabled,
+		"required":            h.auth.TOTPRequiredByPolicy(user),
+		"recovery_codes_left": left,
+	})
+}
+
+// HandleEnroll: POST /api/2fa/totp/enroll — новый секрет; включится после confirm
+func (h *TwoFactorHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := h.auth.BeginTOTP(user)
+	if errors.Is(err, auth.ErrTOTPAlreadyEnabled) {
+		middleware.WriteError(w, http.StatusConflict, "already_enabled", err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to begin 2FA enrollment for %s: %v", user.YUI, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "failed to start two-factor setup")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret, "uri": uri})
+}
+
+// HandleConfirm: POST {"code": "123456"} — включает TOTP и возвращает коды восстановления
+func (h *TwoFactorHandler) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.auth.ConfirmTOTP(claims.YUI, code)
+	if err != nil {
+		writeTOTPError(w, claims.YUI, err)
+		return
+	}
+	writeRecoveryCodes(w, codes)
+}
+
+// HandleDisable: DELETE {"code": "..."} — отключает TOTP, если политика уровня это разрешает
+func (h *TwoFactorHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.DisableTOTP(user, code); err != nil {
+		writeTOTPError(w, user.YUI, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRecoveryCodes: POST {"code": "..."} — новый набор кодов восстановления, старые перестают работать
+func (h *TwoFactorHandler) HandleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.auth.RegenerateRecoveryCodes(claims.YUI, code)
+	if err != nil {
+		writeTOTPError(w, claims.YUI, err)
+		return
+	}
+	writeRecoveryCodes(w, codes)
+}
+
+func (h *TwoFactorHandler) user(w http.ResponseWriter, r *http.Request) (*core.User, bool) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+	user, err := h.db.GetUserByYUI(claims.YUI)
+	if err != nil {
+		middleware.WriteError(w, http.StatusUnauthorized, "unauthorized", "user not found")
+		return nil, false
+	}
+	return user, true
+}
+
+func readCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil || req.Code == "" {
+		middleware.WriteError(w, http.StatusBadRequest, "bad_request", "code is required")
+		return "", false
+	}
+	return req.Code, true
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+func writeTOTPError(w http.ResponseWriter, yui string, err error) {
+	var locked *auth.LockedError
+	switch {
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+		middleware.WriteError(w, http.StatusTooManyRequests, "locked", err.Error())
+	case errors.Is(err, auth.ErrTOTPInvalid):
+		middleware.WriteError(w, http.StatusUnauthorized, "invalid_code", err.Error())
+	case errors.Is(err, auth.ErrTOTPNotEnrolled):
+		middleware.WriteError(w, http.StatusConflict, "not_enrolled", err.Error())
+	case errors.Is(err, auth.ErrTOTPAlreadyEnabled):
+		middleware.WriteError(w, http.StatusConflict, "already_enabled", err.Error())
+	case errors.Is(err, auth.ErrTOTPRequiredByPolicy):
+		middleware.WriteError(w, http.StatusForbidden, "required_by_policy", err.Error())
+	default:
+		log.Printf("2FA operation failed for %s: %v", yui, err)
+		middleware.WriteError(w, http.StatusInternalServerError, "internal_error", "two-factor operation failed")
+	}
+}
@@ -0,0 +1,261 @@
+package ws
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"yep-protocol/internal/core"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Размер исходящей очереди клиента по умолчанию
+	defaultSendQueueSize = 256
+	// Сколько ждём запись в сокет, прежде чем считать клиента мёртвым
+	writeWait = 10 * time.Second
+)
+
+// OverflowPolicy определяет, что делать, когда очередь клиента переполнена
+type OverflowPolicy int
+
+const (
+	DropOldest     OverflowPolicy = iota // выкидываем самое старое сообщение из очереди
+	DropNewest                           // выкидываем новое сообщение
+	DisconnectSlow                       // отключаем медленного клиента
+)
+
+// ParseOverflowPolicy разбирает политику из конфига ("drop_oldest", "drop_newest", "disconnect")
+func ParseOverflowPolicy(s string) OverflowPolicy {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "drop_newest":
+		return DropNewest
+	case "disconnect":
+		return DisconnectSlow
+	default:
+		return DropOldest
+	}
+}
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop_newest"
+	case DisconnectSlow:
+		return "disconnect"
+	default:
+		return "drop_oldest"
+	}
+}
+
+type Client struct {
+	conn     *websocket.Conn
+	user     *core.User
+	verified bool
+
+	sessionID string // у каждого устройства своя сессия
+	device    string // метка устройства ("iPhone", "laptop", User-Agent)
+	ip        string
+
+	authSessionID string // сессия входа (из JWT sid), по ней соединение закрывается при отзыве
+
+	send      chan core.YepMessage // исходящая очередь, её читает только writePump
+	done      chan struct{}        // закрывается при отключении клиента
+	closeOnce sync.Once
+	policy    OverflowPolicy
+
+	// Пока идёт досылка по RESUME, живые сообщения копятся в held и уходят после неё
+	holdMu  sync.Mutex
+	holding bool
+	held    []core.YepMessage
+	// conversation -> последний досланный seq: живое сообщение с seq не больше уже пришло досылкой
+	replayed map[string]int64
+}
+
+func newClient(conn *websocket.Conn, user *core.User, device string, queueSize int, policy OverflowPolicy) *Client {
+	if queueSize <= 0 {
+		queueSize = defaultSendQueueSize
+	}
+	return &Client{
+		conn:      conn,
+		user:      user,
+		verified:  true,
+		sessionID: newSessionID(),
+		device:    device,
+		send:      make(chan core.YepMessage, queueSize),
+		done:      make(chan struct{}),
+		policy:    policy,
+	}
+}
+
+// enqueue кладёт сообщение в очередь клиента, не блокируясь.
+// Возвращает false, если сообщение не было поставлено в очередь.
+func (c *Client) enqueue(msg core.YepMessage) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	c.holdMu.Lock()
+	if c.wasReplayed(msg) {
+		c.holdMu.Unlock()
+		return true
+	}
+	if c.holding {
+		// Буфер не больше очереди и переполняется по той же политике
+		if len(c.held) >= cap(c.send) {
+			if !c.overflow("held queue") {
+				c.holdMu.Unlock()
+				return false
+			}
+			c.held = c.held[1:]
+		}
+		c.held = append(c.held, msg)
+		c.holdMu.Unlock()
+		return true
+	}
+	c.holdMu.Unlock()
+
+	return c.push(msg)
+}
+
+// hold откладывает живые сообщения до release: клиент сначала получает досылку, потом остальное
+func (c *Client) hold() {
+	c.holdMu.Lock()
+	c.holding = true
+	c.holdMu.Unlock()
+}
+
+// release отправляет отложенные сообщения, кроме уже досланных
+// (replayed: conversation -> последний досланный seq).
+// Отправляет с ожиданием и без блокировки: пока идёт отправка, новые сообщения
+// продолжают копиться в held (по политике переполнения) и уходят следующим заходом.
+func (c *Client) release(replayed map[string]int64) {
+	c.holdMu.Lock()
+	// Рассылка могла сохранить сообщение до выборки досылки, а поставить в очередь
+	// уже после release — такое тоже отсекаем
+	c.replayed = replayed
+	c.holdMu.Unlock()
+
+	for {
+		c.holdMu.Lock()
+		batch := c.held
+		c.held = nil
+		if len(batch) == 0 {
+			c.holding = false
+			c.holdMu.Unlock()
+			return
+		}
+		c.holdMu.Unlock()
+
+		for _, msg := range batch {
+			if c.wasReplayed(msg) {
+				continue
+			}
+			if !c.enqueueWait(msg) {
+				return
+			}
+		}
+	}
+}
+
+// wasReplayed — сообщение диалога уже отправлено досылкой. Вызывается под holdMu
+// или после release, когда replayed больше не меняется.
+func (c *Client) wasReplayed(msg core.YepMessage) bool {
+	last, ok := c.replayed[msg.Conversation]
+	return ok && msg.Seq != 0 && msg.Seq <= last
+}
+
+// push кладёт сообщение в очередь по правилам политики переполнения
+func (c *Client) push(msg core.YepMessage) bool {
+	select {
+	case c.send <- msg:
+		return true
+	default:
+	}
+
+	if !c.overflow("queue") {
+		return false
+	}
+	// Освобождаем место, выкидывая самое старое сообщение
+	select {
+	case <-c.send:
+	default:
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		log.Printf("[QUEUE] %s: queue full, message dropped", c.user.YUI)
+		return false
+	}
+}
+
+// overflow применяет политику к переполненной очереди (queue — её название для лога).
+// true — надо выкинуть самое старое сообщение и поставить новое.
+func (c *Client) overflow(queue string) bool {
+	switch c.policy {
+	case DropNewest:
+		log.Printf("[QUEUE] %s: %s full, dropping newest message", c.user.YUI, queue)
+		return false
+	case DisconnectSlow:
+		log.Printf("[QUEUE] %s: %s full, disconnecting slow client", c.user.YUI, queue)
+		c.close()
+		return false
+	default:
+		return true
+	}
+}
+
+// enqueueWait ставит сообщение в очередь, дожидаясь свободного места.
+// Используется для доставки пачек (офлайн-сообщения), где терять нельзя.
+func (c *Client) enqueueWait(msg core.YepMessage) bool {
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// writePump единственный, кто пишет в сокет после авторизации
+func (c *Client) writePump() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				log.Printf("Error sending to %s: %v", c.user.YUI, err)
+				// Закрытие сокета прервёт чтение в handleMessages, и клиент будет удалён
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// kick отправляет последнее сообщение и закрывает соединение, дав writePump время его записать
+func (c *Client) kick(msg core.YepMessage) {
+	c.enqueue(msg)
+	go func() {
+		timer := time.NewTimer(time.Second)
+		defer timer.Stop()
+		select {
+		case <-c.done:
+		case <-timer.C:
+		}
+		c.close()
+	}()
+}
+
+// close закрывает соединение клиента, безопасно для повторных вызовов
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,248 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient — клиент поверх настоящего соединения, но без writePump:
+// очередь никто не читает, пока тест не заберёт из неё сам
+func newTestClient(t *testing.T, queueSize int, policy OverflowPolicy) *Client {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	c := newClient(<-conns, alice, "test", queueSize, policy)
+	t.Cleanup(c.close)
+	return c
+}
+
+// queued забирает всё, что лежит в очереди клиента
+func queued(c *Client) []string {
+	var contents []string
+	for {
+		select {
+		case msg := <-c.send:
+			contents = append(contents, msg.Content)
+		default:
+			return contents
+		}
+	}
+}
+
+func isClosed(c *Client) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestClientOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		policy     OverflowPolicy
+		held       bool // переполняется буфер на время досылки, а не сама очередь
+		wantQueued bool // поставлено ли третье сообщение
+		wantKept   []string
+		wantClosed bool
+	}{
+		{DropOldest, false, true, []string{"2", "3"}, false},
+		{DropNewest, false, false, []string{"1", "2"}, false},
+		{DisconnectSlow, false, false, nil, true},
+		{DropOldest, true, true, []string{"2", "3"}, false},
+		{DropNewest, true, false, []string{"1", "2"}, false},
+		{DisconnectSlow, true, false, nil, true},
+	}
+	for _, tt := range tests {
+		name := tt.policy.String()
+		if tt.held {
+			name += "/held"
+		}
+		t.Run(name, func(t *testing.T) {
+			c := newTestClient(t, 2, tt.policy)
+			if tt.held {
+				c.hold()
+			}
+			c.enqueue(core.YepMessage{Content: "1"})
+			c.enqueue(core.YepMessage{Content: "2"})
+			if got := c.enqueue(core.YepMessage{Content: "3"}); got != tt.wantQueued {
+				t.Fatalf("enqueue on a full queue = %v, want %v", got, tt.wantQueued)
+			}
+			if got := isClosed(c); got != tt.wantClosed {
+				t.Fatalf("closed = %v, want %v", got, tt.wantClosed)
+			}
+			if tt.wantClosed {
+				return
+			}
+
+			if tt.held {
+				go c.release(nil)
+			}
+			var kept []string
+			for len(kept) < len(tt.wantKept) {
+				select {
+				case msg := <-c.send:
+					kept = append(kept, msg.Content)
+				case <-time.After(frameWait):
+					t.Fatalf("kept %v, want %v", kept, tt.wantKept)
+				}
+			}
+			if strings.Join(kept, ",") != strings.Join(tt.wantKept, ",") {
+				t.Fatalf("kept %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestClientEnqueueAfterClose(t *testing.T) {
+	c := newTestClient(t, 2, DropOldest)
+	c.close()
+	if c.enqueue(core.YepMessage{Content: "late"}) {
+		t.Fatal("enqueue to a closed client succeeded")
+	}
+}
+
+func TestClientReleaseSkipsReplayed(t *testing.T) {
+	c := newTestClient(t, 8, DropOldest)
+	c.hold()
+
+	live := []core.YepMessage{
+		{Content: "a1", Conversation: "room:a", Seq: 1},
+		{Content: "a2", Conversation: "room:a", Seq: 2},
+		{Content: "a3", Conversation: "room:a", Seq: 3},
+		{Content: "b1", Conversation: "room:b", Seq: 1},
+		{Content: "presence"}, // без seq — не сообщение диалога
+	}
+	for _, msg := range live {
+		c.enqueue(msg)
+	}
+	if got := queued(c); len(got) != 0 {
+		t.Fatalf("queued during hold: %v", got)
+	}
+
+	// Досылка уже отдала room:a до seq 2
+	c.release(map[string]int64{"room:a": 2})
+	if got, want := strings.Join(queued(c), ","), "a3,b1,presence"; got != want {
+		t.Fatalf("released %s, want %s", got, want)
+	}
+
+	// После release сообщения идут сразу в очередь, кроме опоздавших досланных
+	c.enqueue(core.YepMessage{Content: "late a2", Conversation: "room:a", Seq: 2})
+	c.enqueue(core.YepMessage{Content: "after", Conversation: "room:a", Seq: 4})
+	if got := queued(c); len(got) != 1 || got[0] != "after" {
+		t.Fatalf("after release queued %v, want [after]", got)
+	}
+}
+
+func TestClientReleaseDoesNotBlockEnqueue(t *testing.T) {
+	c := newTestClient(t, 1, DropOldest)
+	c.push(core.YepMessage{Content: "0"}) // очередь полна, release будет ждать места
+	c.hold()
+	c.enqueue(core.YepMessage{Content: "1"})
+
+	released := make(chan struct{})
+	go func() {
+		c.release(nil)
+		close(released)
+	}()
+
+	// Ждём, пока release заберёт отложенное и встанет на ожидании места
+	for deadline := time.Now().Add(frameWait); ; time.Sleep(time.Millisecond) {
+		c.holdMu.Lock()
+		taken := len(c.held) == 0
+		c.holdMu.Unlock()
+		if taken {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("release never took the held messages")
+		}
+	}
+
+	// Пока release ждёт места в очереди, новые сообщения не блокируются, а копятся
+	enqueued := make(chan bool)
+	go func() { enqueued <- c.enqueue(core.YepMessage{Content: "2"}) }()
+	select {
+	case ok := <-enqueued:
+		if !ok {
+			t.Fatal("enqueue during release failed")
+		}
+	case <-time.After(frameWait):
+		t.Fatal("enqueue blocked while release waited for the queue")
+	}
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case msg := <-c.send:
+			got = append(got, msg.Content)
+		case <-time.After(frameWait):
+			t.Fatalf("received %v, want [0 1 2]", got)
+		}
+	}
+	if strings.Join(got, ",") != "0,1,2" {
+		t.Fatalf("order %v, want [0 1 2]", got)
+	}
+	<-released
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want OverflowPolicy
+	}{
+		{"", DropOldest},
+		{"drop_oldest", DropOldest},
+		{" Drop_Newest ", DropNewest},
+		{"disconnect", DisconnectSlow},
+		{"unknown", DropOldest},
+	}
+	for _, tt := range tests {
+		if got := ParseOverflowPolicy(tt.in); got != tt.want {
+			t.Errorf("ParseOverflowPolicy(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlowConsumerEvicted(t *testing.T) {
+	s := newTestServer(t)
+	s.h.SetSendQueue(8, DisconnectSlow)
+
+	// Медленный клиент вошёл и перестал читать
+	s.dial(t, alice, "slow", nil)
+	fast, _ := s.connect(t, bob, "fast", nil)
+
+	big := strings.Repeat("x", 64<<10)
+	for i := 0; s.h.sessions.isOnline(alice.YUI); i++ {
+		if i == 2000 {
+			t.Fatal("slow client was never disconnected")
+		}
+		fast.send(core.YepMessage{Type: "MESSAGE", Content: big})
+		fast.until("MESSAGE_SENT")
+	}
+
+	// Остальные продолжают работать
+	fast.until("USER_LEAVE")
+	fast.send(core.YepMessage{Type: "PING"})
+	fast.until("PONG")
+}
@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"yep-protocol/internal/storage"
+)
+
+// Сколько помним client_msg_id в памяти; дальше спасает уникальный индекс в MongoDB
+const dedupTTL = 10 * time.Minute
+
+type dedupEntry struct {
+	messageID string
+	expires   time.Time
+}
+
+// dedupCache — короткоживущий кэш (отправитель, client_msg_id) -> ID сохранённого сообщения
+type dedupCache struct {
+	mu        sync.Mutex
+	entries   map[string]dedupEntry
+	lastPurge time.Time
+}
+
+func newDedupCache() *dedupCache {
+	return &dedupCache{
+		entries:   make(map[string]dedupEntry),
+		lastPurge: time.Now(),
+	}
+}
+
+func dedupKey(fromYUI, clientMsgID string) string {
+	return fromYUI + "\x00" + clientMsgID
+}
+
+func (c *dedupCache) get(fromYUI, clientMsgID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[dedupKey(fromYUI, clientMsgID)]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+	return e.messageID, true
+}
+
+func (c *dedupCache) put(fromYUI, clientMsgID, messageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.entries[dedupKey(fromYUI, clientMsgID)] = dedupEntry{
+		messageID: messageID,
+		expires:   now.Add(dedupTTL),
+	}
+
+	// Периодически чистим просроченные записи
+	if now.Sub(c.lastPurge) > dedupTTL {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPurge = now
+	}
+}
+
+// storeMessage сохраняет сообщение с учётом client_msg_id.
+// Если такое сообщение от отправителя уже было, возвращает его ID и duplicate = true.
+func (h *Handler) storeMessage(msg *storage.MongoMessage, clientMsgID string) (id string, duplicate bool, err error) {
+	if clientMsgID != "" {
+		if id, ok := h.dedup.get(msg.FromYUI, clientMsgID); ok {
+			return id, true, nil
+		}
+		msg.ClientMsgID = clientMsgID
+	}
+
+	err = h.mongodb.SaveMessage(msg)
+	if errors.Is(err, storage.ErrDuplicateMessage) {
+		// Кэш уже забыл, но индекс поймал повтор
+		existing, findErr := h.mongodb.GetMessageByClientID(msg.FromYUI, clientMsgID)
+		if findErr != nil {
+			return "", false, findErr
+		}
+		id = existing.ID.Hex()
+		h.dedup.put(msg.FromYUI, clientMsgID, id)
+		return id, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	id = msg.ID.Hex()
+	if clientMsgID != "" {
+		h.dedup.put(msg.FromYUI, clientMsgID, id)
+	}
+	return id, false, nil
+}
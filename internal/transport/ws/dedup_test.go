@@ -0,0 +1,76 @@
+package ws
+
+import (
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+func TestRetriedSendStoredOnce(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     core.YepMessage
+		forgetful bool   // кэш забыл id — повтор ловит уникальный индекс
+		delivery  string // тип кадра, которым сообщение приходит alice
+	}{
+		{"lobby", core.YepMessage{Type: "MESSAGE", Content: "hi"}, false, "MESSAGE"},
+		{"lobby/index", core.YepMessage{Type: "MESSAGE", Content: "hi"}, true, "MESSAGE"},
+		{"direct", core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: alice.YUI, Content: "hi"}, false, "DIRECT_MESSAGE"},
+		{"direct/index", core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: alice.YUI, Content: "hi"}, true, "DIRECT_MESSAGE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			a, _ := s.connect(t, alice, "phone", nil)
+			b, _ := s.connect(t, bob, "desktop", nil)
+
+			frame := tt.frame
+			frame.ClientMsgID = "retry-1"
+			b.send(frame)
+			first := ofType(b.until("MESSAGE_SENT"), "MESSAGE_SENT")[0]
+			a.until(tt.delivery)
+
+			if tt.forgetful {
+				s.h.dedup = newDedupCache()
+			}
+			b.send(frame)
+			second := ofType(b.until("MESSAGE_SENT"), "MESSAGE_SENT")[0]
+
+			if second.MessageID != first.MessageID || second.ClientMsgID != "retry-1" {
+				t.Fatalf("retry ack = %s/%s, want %s/retry-1", second.MessageID, second.ClientMsgID, first.MessageID)
+			}
+			a.none(tt.delivery, 100*time.Millisecond)
+			if n := s.msgs.count(); n != 1 {
+				t.Fatalf("stored %d messages, want 1", n)
+			}
+		})
+	}
+}
+
+func TestClientMsgIDScopedToSender(t *testing.T) {
+	s := newTestServer(t)
+	a, _ := s.connect(t, alice, "phone", nil)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	// Один и тот же client_msg_id у разных отправителей — разные сообщения
+	a.send(core.YepMessage{Type: "MESSAGE", Content: "from alice", ClientMsgID: "same"})
+	fromAlice := ofType(a.until("MESSAGE_SENT"), "MESSAGE_SENT")[0]
+	b.send(core.YepMessage{Type: "MESSAGE", Content: "from bob", ClientMsgID: "same"})
+	fromBob := ofType(b.until("MESSAGE_SENT"), "MESSAGE_SENT")[0]
+
+	if fromAlice.MessageID == fromBob.MessageID {
+		t.Fatalf("both senders got message %s", fromAlice.MessageID)
+	}
+	if n := s.msgs.count(); n != 2 {
+		t.Fatalf("stored %d messages, want 2", n)
+	}
+
+	// Без client_msg_id повтор — новое сообщение
+	for i := 0; i < 2; i++ {
+		b.send(core.YepMessage{Type: "MESSAGE", Content: "no id"})
+		b.until("MESSAGE_SENT")
+	}
+	if n := s.msgs.count(); n != 4 {
+		t.Fatalf("stored %d messages, want 4", n)
+	}
+}
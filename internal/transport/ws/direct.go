@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"log"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+// Статусы доставки личного сообщения
+const (
+	directDelivered = "delivered" // получатель онлайн, сообщение поставлено в очередь его устройств
+	directStored    = "stored"    // получатель офлайн, сообщение сохранено
+	directFailed    = "failed"
+)
+
+// Предел размера шифротекста E2E: содержимое не проверяем, но и бесконечным его не делаем
+const maxE2EContent = 64 << 10
+
+// handleDirectMessage доставляет личное сообщение только устройствам адресата.
+// E2E_MESSAGE идёт тем же путём, но Content — шифротекст клиента: сервер его
+// не читает, не форматирует и не шифрует повторно при хранении.
+func (h *Handler) handleDirectMessage(client *Client, msg core.YepMessage) {
+	e2e := msg.Type == "E2E_MESSAGE"
+
+	if msg.ToYUI == "" {
+		h.sendDirectStatus(client, msg.ToYUI, "", directFailed, "to_yui is required")
+		return
+	}
+
+	if _, err := h.db.GetUserByYUI(msg.ToYUI); err != nil {
+		h.sendDirectStatus(client, msg.ToYUI, "", directFailed, "recipient not found")
+		return
+	}
+
+	var response core.YepMessage
+	if e2e {
+		if msg.Content == "" || len(msg.Content) > maxE2EContent {
+			h.sendDirectStatus(client, msg.ToYUI, "", directFailed, "invalid encrypted content")
+			return
+		}
+		response = core.YepMessage{
+			Content:   msg.Content,
+			YUI:       client.user.YUI,
+			Level:     client.user.Level,
+			Encrypted: true,
+			Timestamp: time.Now().Unix(),
+		}
+	} else {
+		// Ограничения по уровню те же, что и для общего чата
+		response = h.processMessage(msg, client.user)
+		if response.Type == "ERROR" {
+			client.enqueue(response)
+			return
+		}
+	}
+
+	mongoMsg := &storage.MongoMessage{
+		FromYUI:      client.user.YUI,
+		ToYUI:        msg.ToYUI,
+		Conversation: directConversation(client.user.YUI, msg.ToYUI),
+		Content:      msg.Content,
+		Level:        client.user.Level,
+		Encrypted:    e2e,
+		E2E:          e2e,
+		IsRead:       false,
+	}
+
+	messageID, duplicate, err := h.storeMessage(mongoMsg, msg.ClientMsgID)
+	if err != nil {
+		log.Printf("Failed to save direct message: %v", err)
+		h.sendDirectStatus(client, msg.ToYUI, "", directFailed, "failed to save message")
+		return
+	}
+
+	h.ackSent(client, messageID, msg.ClientMsgID)
+	if duplicate {
+		// Повтор: сообщение уже сохранено и разослано, повторно не доставляем
+		return
+	}
+
+	response.Type = msg.Type
+	response.ToYUI = msg.ToYUI
+	response.MessageID = messageID
+	response.ClientMsgID = msg.ClientMsgID
+	response.Conversation = mongoMsg.Conversation
+	response.Seq = mongoMsg.Seq
+
+	// Доставляем на все устройства адресата и копию на другие устройства отправителя
+	delivered := h.sendToUser(msg.ToYUI, response, nil)
+	if msg.ToYUI != client.user.YUI {
+		h.sendToUser(client.user.YUI, response, client)
+	}
+
+	// delivered_at выставляет только DELIVERED/ACK от получателя: постановка в очередь
+	// ещё не доставка, а квитанция отправителю уходит из handleReceipt
+	status := directStored
+	if delivered > 0 {
+		status = directDelivered
+	}
+	h.sendDirectStatus(client, msg.ToYUI, messageID, status, "")
+}
+
+func (h *Handler) sendDirectStatus(client *Client, toYUI, messageID, status, reason string) {
+	client.enqueue(core.YepMessage{
+		Type:      "DIRECT_STATUS",
+		Content:   reason,
+		ToYUI:     toYUI,
+		MessageID: messageID,
+		Data:      map[string]string{"status": status},
+		Timestamp: time.Now().Unix(),
+	})
+}
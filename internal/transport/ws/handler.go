@@ -1,13 +1,15 @@
 package ws
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"sync"
 	"time"
 	"yep-protocol/internal/auth"
 	"yep-protocol/internal/core"
+	"yep-protocol/internal/middleware"
 	"yep-protocol/internal/storage"
 
 	"github.com/gorilla/websocket"
@@ -15,25 +17,25 @@ import (
 
 type Handler struct {
 	auth     *auth.Service
-	db       *storage.DB
-	mongodb  *storage.MongoDB
+	db       store
+	mongodb  messageStore
 	upgrader websocket.Upgrader
-	clients  map[string]*Client
-	mu       sync.RWMutex // Добавим mutex для безопасной работы с clients
-}
+	sessions *sessionRegistry // все живые соединения, по несколько на YUI
+	dedup    *dedupCache      // недавние client_msg_id
 
-type Client struct {
-	conn     *websocket.Conn
-	user     *core.User
-	verified bool
+	sendQueueSize int
+	overflow      OverflowPolicy
 }
 
 func NewHandler(authService *auth.Service, db *storage.DB, mongodb *storage.MongoDB) *Handler {
 	return &Handler{
-		auth:    authService,
-		db:      db,
-		mongodb: mongodb,
-		clients: make(map[string]*Client),
+		auth:          authService,
+		db:            db,
+		mongodb:       mongodb,
+		sessions:      newSessionRegistry(),
+		dedup:         newDedupCache(),
+		sendQueueSize: defaultSendQueueSize,
+		overflow:      DropOldest,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
@@ -42,6 +44,14 @@ func NewHandler(authService *auth.Service, db *storage.DB, mongodb *storage.Mong
 	}
 }
 
+// SetSendQueue задаёт размер исходящей очереди клиента и политику при переполнении
+func (h *Handler) SetSendQueue(size int, policy OverflowPolicy) {
+	if size > 0 {
+		h.sendQueueSize = size
+	}
+	h.overflow = policy
+}
+
 func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -63,6 +73,8 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	var user *core.User
 	needsVerification := false
 
+	hs := parseHandshake(authMsg, r)
+
 	// Проверяем токен сначала
 	if token, ok := authMsg["token"].(string); ok && token != "" {
 		// Авторизация по токену
@@ -71,12 +83,36 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 			// Токен валидный, получаем пользователя
 			user, err = h.db.GetUserByEmail(claims.Email)
 			if err == nil && user.IsActive {
-				// Успешная авторизация по токену
-				h.addClient(user, conn)
+				// Успешная авторизация по токену: продолжаем ту же сессию с тем же токеном
+				hs.tokens = &auth.TokenPair{
+					AccessToken: token,
+					ExpiresIn:   int64(time.Until(claims.ExpiresAt.Time).Seconds()),
+					SessionID:   claims.SessionID,
+				}
+				h.addClient(user, conn, hs)
 				return
 			}
 		}
-		// Если токен невалидный, продолжаем обычную авторизацию
+	}
+
+	// Access token истёк — пробуем обменять refresh token
+	if refresh, ok := authMsg["refresh_token"].(string); ok && refresh != "" {
+		tokens, refreshedUser, err := h.auth.RefreshTokens(refresh, hs.meta())
+		if err != nil {
+			log.Printf("[AUTH] refresh failed: %v", err)
+			conn.WriteJSON(core.YepMessage{
+				Type:    "TOKEN_EXPIRED",
+				Content: "Token expired, please login again",
+			})
+			return
+		}
+		hs.tokens = tokens
+		h.addClient(refreshedUser, conn, hs)
+		return
+	}
+
+	if token, ok := authMsg["token"].(string); ok && token != "" {
+		// Токен невалидный, а refresh token не прислали
 		conn.WriteJSON(core.YepMessage{
 			Type:    "TOKEN_EXPIRED",
 			Content: "Token expired, please login again",
@@ -102,19 +138,30 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		hs.method = auth.LoginRegister
+
 		if !user.IsActive {
 			needsVerification = true
 		}
 	} else {
 		// Логин
-		user, err = h.auth.Login(email, password)
+		user, err = h.auth.Login(email, password, hs.meta())
 		if err != nil {
+			var locked *auth.LockedError
+			if errors.As(err, &locked) {
+				sendLocked(conn, "LOGIN_LOCKED", locked)
+				return
+			}
+			if !errors.Is(err, auth.ErrInvalidCredentials) {
+				log.Printf("Login failed: %v", err)
+			}
 			conn.WriteJSON(core.YepMessage{
 				Type:    "ERROR",
-				Content: "Login failed: " + err.Error(),
+				Content: "Login failed: " + auth.ErrInvalidCredentials.Error(),
 			})
 			return
 		}
+		hs.method = auth.LoginPassword
 
 		if !user.IsActive {
 			needsVerification = true
@@ -136,62 +183,124 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		})
 
 		// Ждём OTP
-		h.waitForOTP(client)
-	} else {
-		// Сразу успех если уже активирован
-		h.addClient(user, conn)
+		h.waitForOTP(client, hs)
+	} else if h.waitForTOTP(user, conn) {
+		// Сразу успех если уже активирован (и второй фактор пройден)
+		h.addClient(user, conn, hs)
 	}
 }
 
-func (h *Handler) addClient(user *core.User, conn *websocket.Conn) {
-	// Генерируем JWT токен
-	token, err := auth.GenerateToken(user.YUI, user.Email, user.Level)
-	if err != nil {
-		log.Printf("Failed to generate token: %v", err)
-		token = "" // Продолжаем без токена
+// handshake — параметры из первого (авторизационного) сообщения клиента
+type handshake struct {
+	device string           // метка устройства
+	ip     string           // адрес клиента
+	resume map[string]int64 // conversation -> последний увиденный seq
+	tokens *auth.TokenPair  // уже выданные токены (вход по токену или обмен refresh token)
+
+	method string // как пользователь вошёл, если сессию ещё предстоит открыть (для истории входов)
+}
+
+func (hs handshake) meta() auth.SessionMeta {
+	return auth.SessionMeta{Device: hs.device, IP: hs.ip}
+}
+
+func parseHandshake(authMsg map[string]interface{}, r *http.Request) handshake {
+	// Метка устройства: от клиента, иначе User-Agent
+	device, _ := authMsg["device"].(string)
+	if device == "" {
+		device = r.UserAgent()
+	}
+
+	hs := handshake{device: device, ip: middleware.ClientIP(r)}
+	if resume, ok := authMsg["resume"].(map[string]interface{}); ok {
+		hs.resume = make(map[string]int64, len(resume))
+		for conversation, seq := range resume {
+			if n, ok := seq.(float64); ok {
+				hs.resume[conversation] = int64(n)
+			}
+		}
+	}
+	return hs
+}
+
+func (h *Handler) addClient(user *core.User, conn *websocket.Conn, hs handshake) {
+	// Политика: в чат только с подтверждённым email
+	if h.auth.EmailVerificationRequired(user) && !h.waitForEmail(user, conn) {
+		return
 	}
 
+	// Генерируем JWT токены, если их ещё не выдали при авторизации
+	tokens := hs.tokens
+	if tokens == nil {
+		var err error
+		tokens, err = h.auth.StartSession(user, hs.meta(), hs.method)
+		if err != nil {
+			log.Printf("Failed to generate token: %v", err)
+			tokens = &auth.TokenPair{} // Продолжаем без токена
+		}
+	}
+
+	client := newClient(conn, user, hs.device, h.sendQueueSize, h.overflow)
+	client.ip = hs.ip
+	client.authSessionID = tokens.SessionID
+
+	// С этого момента в сокет пишет только writePump
+	go client.writePump()
+
 	// Отправляем успешную авторизацию с токеном
-	conn.WriteJSON(core.YepMessage{
-		Type:      "AUTH_SUCCESS",
-		YUI:       user.YUI,
-		Level:     user.Level,
-		Content:   fmt.Sprintf("Welcome to YEP! Level: %s", user.Level),
-		Token:     token,
+	client.enqueue(core.YepMessage{
+		Type:         "AUTH_SUCCESS",
+		YUI:          user.YUI,
+		Level:        user.Level,
+		Content:      fmt.Sprintf("Welcome to YEP! Level: %s", user.Level),
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		Data: map[string]string{
+			"session_id":      client.sessionID,
+			"auth_session_id": client.authSessionID,
+			"device":          client.device,
+		},
 		Timestamp: time.Now().Unix(),
 	})
 
-	client := &Client{
-		conn:     conn,
-		user:     user,
-		verified: true,
-	}
+	// Регистрируем сессию раньше досылки, иначе сообщение, сохранённое между выборкой и
+	// регистрацией, не придёт ни так, ни так. Живой трафик копится, пока идёт досылка.
+	client.hold()
+	first := h.sessions.add(client)
 
-	// Добавляем клиента в список
-	h.mu.Lock()
-	h.clients[user.YUI] = client
-	clientCount := len(h.clients)
-	h.mu.Unlock()
+	// Досылаем пропущенное по RESUME из авторизации до начала живого трафика
+	replayed := make(map[string]int64, len(hs.resume))
+	for conversation, lastSeq := range hs.resume {
+		replayed[conversation] = h.replayConversation(client, conversation, lastSeq)
+	}
+	client.release(replayed)
 
-	// Уведомляем всех о входе
-	h.broadcast(core.YepMessage{
-		Type:      "USER_JOIN",
-		Content:   fmt.Sprintf("%s joined the chat", user.Email),
-		YUI:       "SYSTEM",
-		Level:     "S",
-		Timestamp: time.Now().Unix(),
-	}, "")
+	// JOIN только для первого устройства пользователя
+	if first {
+		h.broadcast(core.YepMessage{
+			Type:      "USER_JOIN",
+			Content:   fmt.Sprintf("%s joined the chat", user.Email),
+			YUI:       "SYSTEM",
+			Level:     "S",
+			Timestamp: time.Now().Unix(),
+		}, client)
+	}
 
 	// Отправляем список онлайн пользователей новому клиенту
 	h.sendOnlineUsers(client)
 
-	log.Printf("[JOIN] %s (%s) - Total online: %d", user.YUI, user.Email, clientCount)
+	// Досылаем личные сообщения, пришедшие пока пользователь был офлайн
+	h.deliverOffline(client)
+
+	users, sessions := h.sessions.counts()
+	log.Printf("[JOIN] %s (%s) session %s [%s] - Total online: %d users, %d sessions",
+		user.YUI, user.Email, client.sessionID, client.device, users, sessions)
 
 	// Обрабатываем сообщения
 	h.handleMessages(client)
 }
 
-func (h *Handler) waitForOTP(client *Client) {
+func (h *Handler) waitForOTP(client *Client, hs handshake) {
 	for {
 		var msg map[string]interface{}
 		if err := client.conn.ReadJSON(&msg); err != nil {
@@ -210,9 +319,22 @@ func (h *Handler) waitForOTP(client *Client) {
 			}
 
 			if err := h.auth.VerifyCodeByPhoneHash(client.user.PhoneHash, code); err != nil {
+				var locked *auth.LockedError
+				if errors.As(err, &locked) {
+					// Номер заблокирован: дальше читать коды бессмысленно
+					sendLocked(client.conn, "OTP_LOCKED", locked)
+					return
+				}
+
+				content := "Invalid verification code"
+				if errors.Is(err, auth.ErrOTPExpired) {
+					content = "Verification code expired, request a new one"
+				} else if !errors.Is(err, auth.ErrOTPInvalid) {
+					log.Printf("OTP check failed: %v", err)
+				}
 				client.conn.WriteJSON(core.YepMessage{
 					Type:    "ERROR",
-					Content: "Invalid verification code",
+					Content: content,
 				})
 				continue
 			}
@@ -222,31 +344,123 @@ func (h *Handler) waitForOTP(client *Client) {
 			client.user.IsActive = true
 
 			// Переходим к обычной авторизации
-			h.addClient(client.user, client.conn)
+			if h.waitForTOTP(client.user, client.conn) {
+				h.addClient(client.user, client.conn, hs)
+			}
 			return
 		}
 	}
 }
 
+// waitForTOTP — шаг TOTP_REQUIRED после входа по паролю. Возвращает true, если можно пускать.
+// Вход по токену и refresh token этот шаг не проходит: второй фактор уже проверен при выдаче.
+func (h *Handler) waitForTOTP(user *core.User, conn *websocket.Conn) bool {
+	enrolled, err := h.auth.TOTPEnabled(user.YUI)
+	if err != nil {
+		log.Printf("Failed to check 2FA for %s: %v", user.YUI, err)
+		conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Authentication failed, try again later"})
+		return false
+	}
+	required := h.auth.TOTPRequiredByPolicy(user)
+	if !enrolled && !required {
+		return true
+	}
+
+	content := "Enter the code from your authenticator app or a recovery code"
+	if !enrolled {
+		content = "Two-factor authentication is required for your account, send TOTP_ENROLL to set it up"
+	}
+	conn.WriteJSON(core.YepMessage{
+		Type:    "TOTP_REQUIRED",
+		Content: content,
+		YUI:     user.YUI,
+		Data:    map[string]bool{"enrolled": enrolled},
+	})
+
+	for {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			log.Printf("Error reading TOTP: %v", err)
+			return false
+		}
+
+		switch msg["type"] {
+		case "TOTP_ENROLL":
+			// Обязательная настройка прямо при входе: выдаём секрет, ждём первый код
+			if enrolled {
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: auth.ErrTOTPAlreadyEnabled.Error()})
+				continue
+			}
+			secret, uri, err := h.auth.BeginTOTP(user)
+			if err != nil {
+				log.Printf("Failed to begin 2FA enrollment for %s: %v", user.YUI, err)
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Failed to start two-factor setup"})
+				continue
+			}
+			conn.WriteJSON(core.YepMessage{
+				Type:    "TOTP_ENROLL",
+				Content: "Add this secret to your authenticator app and send TOTP_VERIFY with the first code",
+				Data:    map[string]string{"secret": secret, "uri": uri},
+			})
+
+		case "TOTP_VERIFY":
+			code, ok := msg["code"].(string)
+			if !ok {
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Invalid code format"})
+				continue
+			}
+
+			if enrolled {
+				err = h.auth.VerifyTOTP(user.YUI, code)
+			} else {
+				var codes []string
+				if codes, err = h.auth.ConfirmTOTP(user.YUI, code); err == nil {
+					// Коды восстановления показываются один раз
+					conn.WriteJSON(core.YepMessage{
+						Type:    "TOTP_RECOVERY_CODES",
+						Content: "Two-factor authentication enabled. Store these recovery codes in a safe place",
+						Data:    map[string][]string{"recovery_codes": codes},
+					})
+				}
+			}
+			if err == nil {
+				return true
+			}
+
+			var locked *auth.LockedError
+			switch {
+			case errors.As(err, &locked):
+				sendLocked(conn, "OTP_LOCKED", locked)
+				return false
+			case errors.Is(err, auth.ErrTOTPInvalid):
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Invalid two-factor code"})
+			case errors.Is(err, auth.ErrTOTPNotEnrolled):
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Send TOTP_ENROLL first"})
+			default:
+				log.Printf("2FA check failed for %s: %v", user.YUI, err)
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Failed to verify two-factor code"})
+			}
+		}
+	}
+}
+
 func (h *Handler) handleMessages(client *Client) {
 	defer func() {
-		// При выходе
-		h.mu.Lock()
-		delete(h.clients, client.user.YUI)
-		clientCount := len(h.clients)
-		h.mu.Unlock()
-
-		// Уведомляем всех о выходе
-		h.broadcast(core.YepMessage{
-			Type:      "USER_LEAVE",
-			Content:   fmt.Sprintf("%s left the chat", client.user.Email),
-			YUI:       "SYSTEM",
-			Level:     "S",
-			Timestamp: time.Now().Unix(),
-		}, "")
+		// При выходе; LEAVE только когда отключилось последнее устройство
+		if last := h.sessions.remove(client); last {
+			h.broadcast(core.YepMessage{
+				Type:      "USER_LEAVE",
+				Content:   fmt.Sprintf("%s left the chat", client.user.Email),
+				YUI:       "SYSTEM",
+				Level:     "S",
+				Timestamp: time.Now().Unix(),
+			}, nil)
+		}
 
-		log.Printf("[LEAVE] %s - Total online: %d", client.user.YUI, clientCount)
-		client.conn.Close()
+		users, sessions := h.sessions.counts()
+		log.Printf("[LEAVE] %s session %s - Total online: %d users, %d sessions",
+			client.user.YUI, client.sessionID, users, sessions)
+		client.close()
 	}()
 
 	for {
@@ -259,9 +473,32 @@ func (h *Handler) handleMessages(client *Client) {
 		switch msg.Type {
 		case "MESSAGE":
 			h.handleChatMessage(client, msg)
+		case "DIRECT_MESSAGE", "E2E_MESSAGE":
+			h.handleDirectMessage(client, msg)
+		case "DELIVERED":
+			h.handleReceipt(client, msg, receiptDelivered)
+		case "READ", "ACK":
+			// ACK оставлен для совместимости: подтверждение офлайн-доставки = прочитано
+			h.handleReceipt(client, msg, receiptRead)
+		case "REFRESH":
+			h.handleRefresh(client, msg)
+		case "LOGOUT":
+			h.handleLogout(client)
+		case "RESUME":
+			h.handleResume(client, msg)
+		case "ROOM_CREATE":
+			h.handleRoomCreate(client, msg)
+		case "ROOM_JOIN":
+			h.handleRoomJoin(client, msg)
+		case "ROOM_LEAVE":
+			h.handleRoomLeave(client, msg)
+		case "ROOM_LIST":
+			h.handleRoomList(client)
+		case "ROOM_HISTORY":
+			h.handleRoomHistory(client, msg)
 		case "PING":
 			// Отвечаем на пинг для поддержания соединения
-			client.conn.WriteJSON(core.YepMessage{
+			client.enqueue(core.YepMessage{
 				Type:      "PONG",
 				Timestamp: time.Now().Unix(),
 			})
@@ -275,25 +512,70 @@ func (h *Handler) handleChatMessage(client *Client, msg core.YepMessage) {
 	msg.YUI = client.user.YUI
 	msg.Level = client.user.Level
 	msg.Timestamp = time.Now().Unix()
+	msg.Room = normalizeRoom(msg.Room)
+
+	if !h.isRoomMember(msg.Room, client.user.YUI) {
+		h.sendError(client, "Not a member of this room")
+		return
+	}
+
+	// Готовим ответ; ошибку (лимиты уровня) отдаём только отправителю
+	response := h.processMessage(msg, client.user)
+	if response.Type == "ERROR" {
+		client.enqueue(response)
+		return
+	}
+	response.Room = msg.Room
 
 	// Сохраняем в MongoDB
 	mongoMsg := &storage.MongoMessage{
-		FromYUI:   client.user.YUI,
-		Content:   msg.Content,
-		Level:     client.user.Level,
-		Encrypted: false,
-		IsRead:    false,
+		FromYUI:      client.user.YUI,
+		Room:         msg.Room,
+		Conversation: roomConversation(msg.Room),
+		Content:      msg.Content,
+		Level:        client.user.Level,
+		Encrypted:    false,
+		IsRead:       false,
 	}
 
-	if err := h.mongodb.SaveMessage(mongoMsg); err != nil {
+	messageID, duplicate, err := h.storeMessage(mongoMsg, msg.ClientMsgID)
+	if err != nil {
 		log.Printf("Failed to save message: %v", err)
+		h.sendError(client, "Failed to save message")
+		return
+	}
+	h.ackSent(client, messageID, msg.ClientMsgID)
+	if duplicate {
+		// Повтор уже доставленного сообщения: только подтверждаем
+		return
 	}
+	response.MessageID = messageID
+	response.ClientMsgID = msg.ClientMsgID
+	response.Conversation = mongoMsg.Conversation
+	response.Seq = mongoMsg.Seq
 
-	// Готовим ответ
-	response := h.processMessage(msg, client.user)
+	// Отправляем участникам комнаты КРОМЕ отправившего соединения (другие устройства автора тоже получат)
+	h.broadcast(response, client)
+}
 
-	// Отправляем всем КРОМЕ отправителя
-	h.broadcast(response, client.user.YUI)
+// handleRefresh обменивает refresh token на новую пару, не разрывая соединение
+func (h *Handler) handleRefresh(client *Client, msg core.YepMessage) {
+	tokens, user, err := h.auth.RefreshTokens(msg.RefreshToken, auth.SessionMeta{Device: client.device, IP: client.ip})
+	if err != nil || user.YUI != client.user.YUI {
+		client.enqueue(core.YepMessage{
+			Type:      "TOKEN_EXPIRED",
+			Content:   "Refresh token is invalid, please login again",
+			Timestamp: time.Now().Unix(),
+		})
+		return
+	}
+
+	client.enqueue(core.YepMessage{
+		Type:         "TOKEN_REFRESHED",
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		Timestamp:    time.Now().Unix(),
+	})
 }
 
 func (h *Handler) processMessage(msg core.YepMessage, user *core.User) core.YepMessage {
@@ -317,36 +599,139 @@ func (h *Handler) processMessage(msg core.YepMessage, user *core.User) core.YepM
 	}
 }
 
-func (h *Handler) broadcast(msg core.YepMessage, excludeYUI string) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// broadcast рассылает сообщение участникам комнаты msg.Room (пусто или lobby — всем)
+func (h *Handler) broadcast(msg core.YepMessage, exclude *Client) {
+	room := normalizeRoom(msg.Room)
+	if room == core.LobbyRoom {
+		// Кладём в очереди без блокировки: медленный клиент не тормозит остальных
+		for _, client := range h.sessions.all() {
+			// Пропускаем отправителя если указан
+			if client == exclude {
+				continue
+			}
+			client.enqueue(msg)
+		}
+		return
+	}
+
+	members, err := h.db.GetRoomMembers(room)
+	if err != nil {
+		log.Printf("Failed to load members of %s: %v", room, err)
+		return
+	}
+	for _, yui := range members {
+		h.sendToUser(yui, msg, exclude)
+	}
+}
 
-	for yui, client := range h.clients {
-		// Пропускаем отправителя если указан
-		if excludeYUI != "" && yui == excludeYUI {
+// sendToUser доставляет сообщение на все устройства пользователя.
+// Возвращает число соединений, которым сообщение поставлено в очередь.
+func (h *Handler) sendToUser(yui string, msg core.YepMessage, exclude *Client) int {
+	delivered := 0
+	for _, client := range h.sessions.clientsOf(yui) {
+		if client == exclude {
 			continue
 		}
-
-		if err := client.conn.WriteJSON(msg); err != nil {
-			log.Printf("Error sending to %s: %v", yui, err)
-			// Не удаляем клиента здесь, так как это может вызвать deadlock
+		if client.enqueue(msg) {
+			delivered++
 		}
 	}
+	return delivered
 }
 
 func (h *Handler) sendOnlineUsers(client *Client) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
 	var users []string
-	for _, c := range h.clients {
-		users = append(users, c.user.Email)
+	for _, u := range h.sessions.users() {
+		users = append(users, u.Email)
 	}
 
-	client.conn.WriteJSON(core.YepMessage{
+	client.enqueue(core.YepMessage{
 		Type:      "ONLINE_USERS",
 		Content:   fmt.Sprintf("Online: %d users", len(users)),
 		Data:      users,
 		Timestamp: time.Now().Unix(),
 	})
 }
+
+// waitForEmail — шаг EMAIL_VERIFICATION_REQUIRED. Клиент присылает EMAIL_VERIFY с кодом из письма
+// или без кода, если адрес подтвердили по ссылке; EMAIL_RESEND — отправить письмо ещё раз.
+func (h *Handler) waitForEmail(user *core.User, conn *websocket.Conn) bool {
+	content := fmt.Sprintf("Please confirm your email %s, we've sent you a code", user.Email)
+	if err := h.auth.EnsureEmailVerification(user); err != nil {
+		if !errors.Is(err, auth.ErrEmailRateLimited) {
+			log.Printf("Email verification for %s failed: %v", user.YUI, err)
+		}
+		content = fmt.Sprintf("Please confirm your email %s. We couldn't send a code right now, send EMAIL_RESEND to try again", user.Email)
+	}
+	conn.WriteJSON(core.YepMessage{
+		Type:    "EMAIL_VERIFICATION_REQUIRED",
+		Content: content,
+		YUI:     user.YUI,
+	})
+
+	for {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			log.Printf("Error reading email verification: %v", err)
+			return false
+		}
+
+		switch msg["type"] {
+		case "EMAIL_RESEND":
+			err := h.auth.SendEmailVerification(user)
+			switch {
+			case err == nil:
+				conn.WriteJSON(core.YepMessage{Type: "EMAIL_SENT", Content: "Verification email sent"})
+			case errors.Is(err, auth.ErrEmailRateLimited):
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: err.Error()})
+			default:
+				log.Printf("Email verification for %s failed: %v", user.YUI, err)
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Failed to send verification email"})
+			}
+
+		case "EMAIL_VERIFY":
+			code, _ := msg["code"].(string)
+			if code == "" {
+				// Подтверждено по ссылке из письма — перечитываем пользователя
+				fresh, err := h.db.GetUserByYUI(user.YUI)
+				if err == nil && fresh.EmailVerifiedAt.Valid {
+					user.EmailVerifiedAt = fresh.EmailVerifiedAt
+					return true
+				}
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Email is not verified yet"})
+				continue
+			}
+
+			err := h.auth.ConfirmEmailCode(user.YUI, code)
+			if err == nil {
+				user.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+				return true
+			}
+
+			var locked *auth.LockedError
+			switch {
+			case errors.As(err, &locked):
+				sendLocked(conn, "OTP_LOCKED", locked)
+				return false
+			case errors.Is(err, auth.ErrEmailTokenInvalid):
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Invalid or expired verification code"})
+			default:
+				log.Printf("Email check failed for %s: %v", user.YUI, err)
+				conn.WriteJSON(core.YepMessage{Type: "ERROR", Content: "Failed to verify email"})
+			}
+		}
+	}
+}
+
+// sendLocked сообщает, что вход или проверка кода временно заблокированы, и когда пробовать снова
+func sendLocked(conn *websocket.Conn, typ string, locked *auth.LockedError) {
+	content := "Too many attempts, try again later"
+	if typ == "LOGIN_LOCKED" {
+		content = "Too many failed sign-in attempts, try again later"
+	}
+	conn.WriteJSON(core.YepMessage{
+		Type:    typ,
+		Content: content,
+		Data:    map[string]int64{"retry_after": int64(time.Until(locked.Until).Seconds()) + 1},
+	})
+}
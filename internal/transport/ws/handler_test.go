@@ -0,0 +1,199 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/core"
+
+	"github.com/gorilla/websocket"
+)
+
+// Сколько ждём кадр, прежде чем считать, что он не придёт
+const frameWait = 2 * time.Second
+
+var (
+	alice = &core.User{YUI: "YUI-ALICE", Email: "alice@example.com", Level: "A", IsActive: true}
+	bob   = &core.User{YUI: "YUI-BOB", Email: "bob@example.com", Level: "A", IsActive: true}
+)
+
+type testServer struct {
+	h    *Handler
+	db   *fakeStore
+	msgs *fakeMessages
+	url  string
+}
+
+// newTestServer поднимает Handler поверх хранилищ в памяти; входят по access token
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ks, err := auth.LoadKeySet(auth.KeyConfig{Secret: "ws-test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.SetKeySet(ks)
+
+	s := &testServer{
+		db:   newFakeStore(alice, bob),
+		msgs: &fakeMessages{},
+	}
+	s.h = NewHandler(auth.NewService(nil, nil), nil, nil)
+	s.h.db = s.db
+	s.h.mongodb = s.msgs
+
+	srv := httptest.NewServer(http.HandlerFunc(s.h.HandleWebSocket))
+	t.Cleanup(srv.Close)
+	s.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return s
+}
+
+// testConn — клиент протокола: кадры читает отдельная горутина, чтобы таймаут
+// ожидания не ломал соединение
+type testConn struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	frames chan core.YepMessage
+}
+
+// dial входит по токену и читает кадры до конца входа (OFFLINE_DONE)
+func (s *testServer) dial(t *testing.T, user *core.User, device string, resume map[string]int64) (*websocket.Conn, []core.YepMessage) {
+	t.Helper()
+	token, _, err := auth.GenerateToken(user.YUI, user.Email, user.Level, "sid-"+device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(s.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	handshake := map[string]interface{}{"token": token, "device": device}
+	if resume != nil {
+		handshake["resume"] = resume
+	}
+	if err := conn.WriteJSON(handshake); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(frameWait))
+	defer conn.SetReadDeadline(time.Time{})
+	var frames []core.YepMessage
+	for {
+		var msg core.YepMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("login of %s: %v", user.YUI, err)
+		}
+		frames = append(frames, msg)
+		if msg.Type == "OFFLINE_DONE" {
+			return conn, frames
+		}
+	}
+}
+
+// connect — dial, после которого кадры читает отдельная горутина
+func (s *testServer) connect(t *testing.T, user *core.User, device string, resume map[string]int64) (*testConn, []core.YepMessage) {
+	t.Helper()
+	conn, frames := s.dial(t, user, device, resume)
+	c := &testConn{t: t, conn: conn, frames: make(chan core.YepMessage, 1024)}
+	go func() {
+		defer close(c.frames)
+		for {
+			var msg core.YepMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			c.frames <- msg
+		}
+	}()
+	return c, frames
+}
+
+func (c *testConn) send(msg core.YepMessage) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testConn) next() core.YepMessage {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.frames:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(frameWait):
+		c.t.Fatal("no frame received")
+		return core.YepMessage{}
+	}
+}
+
+// until читает кадры до первого кадра типа typ включительно
+func (c *testConn) until(typ string) []core.YepMessage {
+	c.t.Helper()
+	var frames []core.YepMessage
+	for {
+		msg := c.next()
+		frames = append(frames, msg)
+		if msg.Type == typ {
+			return frames
+		}
+	}
+}
+
+// none проверяет, что за wait не пришло кадров типа typ
+func (c *testConn) none(typ string, wait time.Duration) {
+	c.t.Helper()
+	deadline := time.After(wait)
+	for {
+		select {
+		case msg, ok := <-c.frames:
+			if !ok {
+				return
+			}
+			if msg.Type == typ {
+				c.t.Fatalf("unexpected %s frame: %+v", typ, msg)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// closed ждёт, пока сервер закроет соединение
+func (c *testConn) closed() bool {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-c.frames:
+			if !ok {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// ofType отбирает кадры нужного типа
+func ofType(frames []core.YepMessage, typ string) []core.YepMessage {
+	var found []core.YepMessage
+	for _, f := range frames {
+		if f.Type == typ {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// status — поле status из Data кадра
+func status(msg core.YepMessage) string {
+	data, _ := msg.Data.(map[string]interface{})
+	s, _ := data["status"].(string)
+	return s
+}
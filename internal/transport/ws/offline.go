@@ -0,0 +1,38 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+// deliverOffline отправляет пользователю всё, что пришло, пока он был офлайн.
+// Сообщения помечаются доставленными только по DELIVERED/ACK от клиента — и живые,
+// и офлайн, поэтому обрыв соединения до подтверждения ничего не теряет: остаток
+// придёт при следующем входе.
+func (h *Handler) deliverOffline(client *Client) {
+	messages, err := h.mongodb.GetUndeliveredMessages(client.user.YUI)
+	if err != nil {
+		log.Printf("Failed to load unread messages for %s: %v", client.user.YUI, err)
+		return
+	}
+
+	sent := 0
+	for _, m := range messages {
+		frame := m.ToYepMessage()
+		frame.Data = map[string]bool{"offline": true}
+		if !client.enqueueWait(frame) {
+			// Клиент отключился — остальное доставим в следующий раз
+			return
+		}
+		sent++
+	}
+
+	client.enqueue(core.YepMessage{
+		Type:      "OFFLINE_DONE",
+		Content:   fmt.Sprintf("%d offline messages delivered", sent),
+		Data:      map[string]int{"count": sent},
+		Timestamp: time.Now().Unix(),
+	})
+}
@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+// offline — личные сообщения, досланные при входе (помечены data.offline)
+func offline(frames []core.YepMessage) []core.YepMessage {
+	var found []core.YepMessage
+	for _, f := range ofType(frames, "DIRECT_MESSAGE") {
+		if data, _ := f.Data.(map[string]interface{}); data["offline"] == true {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func TestOfflineDeliveryUntilAcknowledged(t *testing.T) {
+	s := newTestServer(t)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	// alice офлайн: сообщения сохраняются
+	for _, text := range []string{"first", "second"} {
+		b.send(core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: alice.YUI, Content: text})
+		frames := b.until("DIRECT_STATUS")
+		if got := status(frames[len(frames)-1]); got != directStored {
+			t.Fatalf("status of %q = %q, want %q", text, got, directStored)
+		}
+	}
+
+	// Вход без подтверждения: оба сообщения по порядку, и при следующем входе снова
+	var ids []string
+	for attempt := 1; attempt <= 2; attempt++ {
+		a, frames := s.connect(t, alice, "phone", nil)
+		got := offline(frames)
+		if len(got) != 2 || got[0].Seq != 1 || got[1].Seq != 2 {
+			t.Fatalf("login %d: offline messages %+v, want seq 1 and 2", attempt, got)
+		}
+		ids = []string{got[0].MessageID, got[1].MessageID}
+		a.conn.Close()
+		waitOffline(t, s, alice.YUI)
+	}
+
+	// Подтверждённое больше не досылается, неподтверждённое — досылается
+	a, _ := s.connect(t, alice, "phone", nil)
+	a.send(core.YepMessage{Type: "DELIVERED", MessageID: ids[0]})
+	b.until("RECEIPT")
+	a.conn.Close()
+	waitOffline(t, s, alice.YUI)
+
+	_, frames := s.connect(t, alice, "phone", nil)
+	if got := offline(frames); len(got) != 1 || got[0].MessageID != ids[1] {
+		t.Fatalf("after ack: offline messages %+v, want only %s", got, ids[1])
+	}
+}
+
+func TestLiveDirectMessageRedeliveredWithoutAck(t *testing.T) {
+	s := newTestServer(t)
+	a, _ := s.connect(t, alice, "phone", nil)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	// Живое сообщение пришло, но соединение оборвалось до DELIVERED
+	b.send(core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: alice.YUI, Content: "are you there?"})
+	live := a.until("DIRECT_MESSAGE")
+	id := live[len(live)-1].MessageID
+	a.conn.Close()
+	waitOffline(t, s, alice.YUI)
+
+	_, frames := s.connect(t, alice, "phone", nil)
+	if got := offline(frames); len(got) != 1 || got[0].MessageID != id {
+		t.Fatalf("offline messages %+v, want %s", got, id)
+	}
+}
+
+// waitOffline ждёт, пока сервер заметит отключение всех устройств пользователя
+func waitOffline(t *testing.T, s *testServer, yui string) {
+	t.Helper()
+	for deadline := time.Now().Add(frameWait); s.h.sessions.isOnline(yui); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is still online", yui)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Виды квитанций
+const (
+	receiptDelivered = "delivered"
+	receiptRead      = "read"
+)
+
+// ackSent подтверждает отправителю, что сообщение принято и сохранено.
+// client_msg_id возвращается как есть, чтобы клиент сопоставил подтверждение с отправкой.
+func (h *Handler) ackSent(client *Client, messageID, clientMsgID string) {
+	client.enqueue(core.YepMessage{
+		Type:        "MESSAGE_SENT",
+		MessageID:   messageID,
+		ClientMsgID: clientMsgID,
+		Timestamp:   time.Now().Unix(),
+	})
+}
+
+// handleReceipt обрабатывает DELIVERED/READ от получателя и уведомляет отправителя
+func (h *Handler) handleReceipt(client *Client, msg core.YepMessage, kind string) {
+	if msg.MessageID == "" {
+		h.sendError(client, "message_id is required")
+		return
+	}
+
+	var (
+		stored *storage.MongoMessage
+		err    error
+	)
+	switch kind {
+	case receiptRead:
+		stored, err = h.mongodb.MarkAsRead(msg.MessageID, client.user.YUI)
+	default:
+		stored, err = h.mongodb.MarkDelivered(msg.MessageID, client.user.YUI)
+	}
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Уже отмечено (например, с другого устройства) или сообщение не этому пользователю
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to mark %s as %s: %v", msg.MessageID, kind, err)
+		h.sendError(client, "Invalid message_id")
+		return
+	}
+
+	at := time.Now()
+	if kind == receiptRead && stored.ReadAt != nil {
+		at = *stored.ReadAt
+	} else if stored.DeliveredAt != nil {
+		at = *stored.DeliveredAt
+	}
+
+	// Квитанция отправителю на все его устройства
+	h.sendToUser(stored.FromYUI, core.YepMessage{
+		Type:      "RECEIPT",
+		YUI:       client.user.YUI,
+		ToYUI:     stored.FromYUI,
+		MessageID: msg.MessageID,
+		Data:      map[string]string{"status": kind},
+		Timestamp: at.Unix(),
+	}, nil)
+}
@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+func TestDirectMessageReceipts(t *testing.T) {
+	s := newTestServer(t)
+	a, _ := s.connect(t, alice, "phone", nil)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	b.send(core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: alice.YUI, Content: "hi", ClientMsgID: "c-1"})
+	frames := b.until("DIRECT_STATUS")
+	sent := ofType(frames, "MESSAGE_SENT")
+	if len(sent) != 1 || sent[0].MessageID == "" || sent[0].ClientMsgID != "c-1" {
+		t.Fatalf("MESSAGE_SENT = %+v, want a stored id and client_msg_id c-1", sent)
+	}
+	id := sent[0].MessageID
+	if got := status(frames[len(frames)-1]); got != directDelivered {
+		t.Fatalf("DIRECT_STATUS = %q, want %q", got, directDelivered)
+	}
+	a.until("DIRECT_MESSAGE")
+
+	tests := []struct {
+		frame string
+		want  string
+	}{
+		{"DELIVERED", receiptDelivered},
+		{"READ", receiptRead},
+	}
+	for _, tt := range tests {
+		a.send(core.YepMessage{Type: tt.frame, MessageID: id})
+		receipt := b.until("RECEIPT")[0]
+		if receipt.MessageID != id || receipt.YUI != alice.YUI || status(receipt) != tt.want {
+			t.Fatalf("%s: RECEIPT = %+v, want %s from %s", tt.frame, receipt, tt.want, alice.YUI)
+		}
+
+		// Повтор (например, с другого устройства) второй квитанции не даёт
+		a.send(core.YepMessage{Type: tt.frame, MessageID: id})
+		b.none("RECEIPT", 100*time.Millisecond)
+	}
+
+	stored, err := s.msgs.find(func(m *storage.MongoMessage) bool { return m.ID.Hex() == id })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.DeliveredAt == nil || !stored.IsRead || stored.ReadAt == nil {
+		t.Fatalf("stored message delivered_at=%v is_read=%v read_at=%v", stored.DeliveredAt, stored.IsRead, stored.ReadAt)
+	}
+}
+
+func TestReceiptOnlyFromRecipient(t *testing.T) {
+	s := newTestServer(t)
+	a, _ := s.connect(t, alice, "phone", nil)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	b.send(core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: alice.YUI, Content: "hi"})
+	frames := b.until("DIRECT_STATUS")
+	id := ofType(frames, "MESSAGE_SENT")[0].MessageID
+	a.until("DIRECT_MESSAGE")
+
+	// Отправитель не может сам отметить своё сообщение прочитанным
+	b.send(core.YepMessage{Type: "READ", MessageID: id})
+	b.none("RECEIPT", 100*time.Millisecond)
+
+	// Без message_id — ошибка
+	a.send(core.YepMessage{Type: "DELIVERED"})
+	if e := a.until("ERROR"); e[len(e)-1].Content != "message_id is required" {
+		t.Fatalf("ERROR = %+v", e[len(e)-1])
+	}
+}
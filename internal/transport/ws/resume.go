@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"log"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+// Сколько сообщений досылаем за один RESUME; остаток клиент запрашивает повторно
+const resumeBatchLimit = 500
+
+// roomConversation — идентификатор диалога комнаты
+func roomConversation(room string) string {
+	return "room:" + room
+}
+
+// directConversation — идентификатор личного диалога, не зависит от направления
+func directConversation(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return "dm:" + a + ":" + b
+}
+
+// canAccessConversation проверяет, может ли пользователь читать диалог
+func (h *Handler) canAccessConversation(yui, conversation string) bool {
+	switch {
+	case strings.HasPrefix(conversation, "room:"):
+		return h.isRoomMember(strings.TrimPrefix(conversation, "room:"), yui)
+	case strings.HasPrefix(conversation, "dm:"):
+		parts := strings.Split(strings.TrimPrefix(conversation, "dm:"), ":")
+		return len(parts) == 2 && (parts[0] == yui || parts[1] == yui)
+	default:
+		return false
+	}
+}
+
+// replayConversation досылает клиенту сообщения диалога с seq > lastSeq по порядку.
+// Возвращает seq последнего досланного сообщения.
+func (h *Handler) replayConversation(client *Client, conversation string, lastSeq int64) int64 {
+	if !h.canAccessConversation(client.user.YUI, conversation) {
+		h.sendError(client, "Cannot resume conversation "+conversation)
+		return lastSeq
+	}
+
+	messages, err := h.mongodb.GetConversationSince(conversation, lastSeq, resumeBatchLimit)
+	if err != nil {
+		log.Printf("Failed to load %s since %d: %v", conversation, lastSeq, err)
+		h.sendError(client, "Failed to resume conversation")
+		return lastSeq
+	}
+
+	for _, m := range messages {
+		frame := m.ToYepMessage()
+		if !client.enqueueWait(frame) {
+			return lastSeq
+		}
+		lastSeq = m.Seq
+	}
+
+	client.push(core.YepMessage{
+		Type:         "RESUME_DONE",
+		Conversation: conversation,
+		Seq:          lastSeq,
+		Data:         map[string]bool{"more": len(messages) == resumeBatchLimit},
+		Timestamp:    time.Now().Unix(),
+	})
+	return lastSeq
+}
+
+// handleResume: клиент присылает conversation (или room / to_yui) и последний увиденный seq
+func (h *Handler) handleResume(client *Client, msg core.YepMessage) {
+	conversation := msg.Conversation
+	if conversation == "" {
+		if msg.ToYUI != "" {
+			conversation = directConversation(client.user.YUI, msg.ToYUI)
+		} else {
+			conversation = roomConversation(normalizeRoom(msg.Room))
+		}
+	}
+	h.replayConversation(client, conversation, msg.Seq)
+}
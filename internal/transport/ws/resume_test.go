@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+var lobby = roomConversation(core.LobbyRoom)
+
+// seqs — номера сообщений lobby среди кадров
+func seqs(frames []core.YepMessage) []int64 {
+	var found []int64
+	for _, f := range ofType(frames, "MESSAGE") {
+		if f.Conversation == lobby {
+			found = append(found, f.Seq)
+		}
+	}
+	return found
+}
+
+func sameSeqs(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResumeOnLogin(t *testing.T) {
+	s := newTestServer(t)
+	for _, text := range []string{"one", "two", "three"} {
+		if err := s.msgs.SaveMessage(&storage.MongoMessage{FromYUI: bob.YUI, Room: core.LobbyRoom, Conversation: lobby, Content: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, frames := s.connect(t, alice, "phone", map[string]int64{lobby: 1})
+	if got := seqs(frames); !sameSeqs(got, []int64{2, 3}) {
+		t.Fatalf("replayed seq %v, want [2 3]", got)
+	}
+	done := ofType(frames, "RESUME_DONE")
+	if len(done) != 1 || done[0].Conversation != lobby || done[0].Seq != 3 {
+		t.Fatalf("RESUME_DONE = %+v, want %s up to seq 3", done, lobby)
+	}
+	// Досылка идёт сразу после AUTH_SUCCESS, раньше списка онлайн и офлайн-сообщений
+	var order []string
+	for _, f := range frames[:4] {
+		order = append(order, f.Type)
+	}
+	if got, want := strings.Join(order, ","), "AUTH_SUCCESS,MESSAGE,MESSAGE,RESUME_DONE"; got != want {
+		t.Fatalf("login frames start with %s, want %s", got, want)
+	}
+}
+
+func TestResumeDirectConversationOfOthers(t *testing.T) {
+	s := newTestServer(t)
+	other := directConversation(bob.YUI, "YUI-CAROL")
+
+	_, frames := s.connect(t, alice, "phone", map[string]int64{other: 0})
+	errs := ofType(frames, "ERROR")
+	if len(errs) != 1 || errs[0].Content != "Cannot resume conversation "+other {
+		t.Fatalf("errors %+v, want refusal for %s", errs, other)
+	}
+	if len(ofType(frames, "RESUME_DONE")) != 0 {
+		t.Fatal("RESUME_DONE for a foreign conversation")
+	}
+}
+
+func TestResumeRaceWithLiveMessage(t *testing.T) {
+	s := newTestServer(t)
+	b, _ := s.connect(t, bob, "desktop", nil)
+	if err := s.msgs.SaveMessage(&storage.MongoMessage{FromYUI: bob.YUI, Room: core.LobbyRoom, Conversation: lobby, Content: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Пока сервер собирает досылку для alice, bob пишет в lobby: сообщение попадает
+	// и в выборку, и в живую рассылку, но alice должна получить его один раз
+	s.msgs.beforeSince = func() {
+		b.conn.WriteJSON(core.YepMessage{Type: "MESSAGE", Content: "during resume"})
+		for deadline := time.Now().Add(frameWait); s.msgs.count() < 2 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	a, frames := s.connect(t, alice, "phone", map[string]int64{lobby: 0})
+	b.until("MESSAGE_SENT")
+
+	// Следующее сообщение приходит живым и с очередным seq
+	b.send(core.YepMessage{Type: "MESSAGE", Content: "after resume"})
+	b.until("MESSAGE_SENT")
+	got := seqs(frames)
+	for got[len(got)-1] < 3 {
+		got = append(got, seqs(a.until("MESSAGE"))...)
+	}
+	a.none("MESSAGE", 100*time.Millisecond)
+	if !sameSeqs(got, []int64{1, 2, 3}) {
+		t.Fatalf("alice got seq %v, want [1 2 3]", got)
+	}
+}
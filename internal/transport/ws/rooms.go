@@ -0,0 +1,187 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+// Сколько сообщений отдаём по ROOM_HISTORY
+const roomHistoryLimit = 50
+
+var roomNameRe = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// normalizeRoom приводит имя комнаты к каноническому виду; пусто = lobby
+func normalizeRoom(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return core.LobbyRoom
+	}
+	return name
+}
+
+// isRoomMember — членство в комнате; ошибка БД считается отказом
+func (h *Handler) isRoomMember(room, yui string) bool {
+	member, err := h.db.IsRoomMember(room, yui)
+	if err != nil {
+		log.Printf("Failed to load members of %s: %v", room, err)
+		return false
+	}
+	return member
+}
+
+func (h *Handler) handleRoomCreate(client *Client, msg core.YepMessage) {
+	room := normalizeRoom(msg.Room)
+	if room == core.LobbyRoom || !roomNameRe.MatchString(room) {
+		h.sendError(client, "Invalid room name")
+		return
+	}
+
+	created, err := h.db.CreateRoom(room, client.user.YUI)
+	if err != nil {
+		if errors.Is(err, storage.ErrRoomExists) {
+			h.sendError(client, "Room already exists")
+			return
+		}
+		log.Printf("Failed to create room %s: %v", room, err)
+		h.sendError(client, "Failed to create room")
+		return
+	}
+
+	h.sendToUser(client.user.YUI, core.YepMessage{
+		Type:      "ROOM_JOINED",
+		Room:      room,
+		Content:   fmt.Sprintf("Room %s created", room),
+		Data:      created,
+		Timestamp: time.Now().Unix(),
+	}, nil)
+}
+
+func (h *Handler) handleRoomJoin(client *Client, msg core.YepMessage) {
+	room := normalizeRoom(msg.Room)
+	if room == core.LobbyRoom {
+		// В lobby состоят все
+		client.enqueue(core.YepMessage{Type: "ROOM_JOINED", Room: room, Timestamp: time.Now().Unix()})
+		return
+	}
+
+	if _, err := h.db.GetRoom(room); err != nil {
+		h.sendError(client, "Room not found")
+		return
+	}
+
+	if err := h.db.AddRoomMember(room, client.user.YUI); err != nil {
+		log.Printf("Failed to join room %s: %v", room, err)
+		h.sendError(client, "Failed to join room")
+		return
+	}
+
+	// Подтверждаем всем устройствам пользователя, остальных участников уведомляем
+	h.sendToUser(client.user.YUI, core.YepMessage{
+		Type:      "ROOM_JOINED",
+		Room:      room,
+		Content:   fmt.Sprintf("Joined %s", room),
+		Timestamp: time.Now().Unix(),
+	}, nil)
+	h.broadcast(core.YepMessage{
+		Type:      "USER_JOIN",
+		Room:      room,
+		Content:   fmt.Sprintf("%s joined %s", client.user.Email, room),
+		YUI:       "SYSTEM",
+		Level:     "S",
+		Timestamp: time.Now().Unix(),
+	}, client)
+}
+
+func (h *Handler) handleRoomLeave(client *Client, msg core.YepMessage) {
+	room := normalizeRoom(msg.Room)
+	if room == core.LobbyRoom {
+		h.sendError(client, "Cannot leave lobby")
+		return
+	}
+
+	if err := h.db.RemoveRoomMember(room, client.user.YUI); err != nil {
+		log.Printf("Failed to leave room %s: %v", room, err)
+		h.sendError(client, "Failed to leave room")
+		return
+	}
+
+	h.sendToUser(client.user.YUI, core.YepMessage{
+		Type:      "ROOM_LEFT",
+		Room:      room,
+		Content:   fmt.Sprintf("Left %s", room),
+		Timestamp: time.Now().Unix(),
+	}, nil)
+	h.broadcast(core.YepMessage{
+		Type:      "USER_LEAVE",
+		Room:      room,
+		Content:   fmt.Sprintf("%s left %s", client.user.Email, room),
+		YUI:       "SYSTEM",
+		Level:     "S",
+		Timestamp: time.Now().Unix(),
+	}, nil)
+}
+
+func (h *Handler) handleRoomList(client *Client) {
+	rooms, err := h.db.ListRooms()
+	if err != nil {
+		log.Printf("Failed to list rooms: %v", err)
+		h.sendError(client, "Failed to list rooms")
+		return
+	}
+
+	joined, err := h.db.GetUserRooms(client.user.YUI)
+	if err != nil {
+		log.Printf("Failed to load rooms of %s: %v", client.user.YUI, err)
+	}
+
+	client.enqueue(core.YepMessage{
+		Type:    "ROOM_LIST",
+		Content: fmt.Sprintf("%d rooms", len(rooms)),
+		Data: map[string]interface{}{
+			"rooms":  rooms,
+			"joined": append([]string{core.LobbyRoom}, joined...),
+		},
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+func (h *Handler) handleRoomHistory(client *Client, msg core.YepMessage) {
+	room := normalizeRoom(msg.Room)
+	if !h.isRoomMember(room, client.user.YUI) {
+		h.sendError(client, "Not a member of this room")
+		return
+	}
+
+	messages, err := h.mongodb.GetRoomHistory(room, roomHistoryLimit)
+	if err != nil {
+		log.Printf("Failed to load history of %s: %v", room, err)
+		h.sendError(client, "Failed to load history")
+		return
+	}
+
+	history := make([]core.YepMessage, 0, len(messages))
+	for _, m := range messages {
+		history = append(history, m.ToYepMessage())
+	}
+
+	client.enqueue(core.YepMessage{
+		Type:      "ROOM_HISTORY",
+		Room:      room,
+		Data:      history,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+func (h *Handler) sendError(client *Client, content string) {
+	client.enqueue(core.YepMessage{
+		Type:      "ERROR",
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+	})
+}
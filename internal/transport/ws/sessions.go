@@ -0,0 +1,183 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"yep-protocol/internal/auth"
+	"yep-protocol/internal/core"
+)
+
+// sessionRegistry хранит все живые соединения: один YUI может держать несколько устройств
+type sessionRegistry struct {
+	mu    sync.RWMutex
+	byYUI map[string]map[string]*Client // yui -> sessionID -> client
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		byYUI: make(map[string]map[string]*Client),
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// add регистрирует сессию. Возвращает true, если это первое устройство пользователя.
+func (r *sessionRegistry) add(c *Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions, ok := r.byYUI[c.user.YUI]
+	if !ok {
+		sessions = make(map[string]*Client)
+		r.byYUI[c.user.YUI] = sessions
+	}
+	sessions[c.sessionID] = c
+	return len(sessions) == 1
+}
+
+// remove удаляет сессию. Возвращает true, если это было последнее устройство пользователя.
+func (r *sessionRegistry) remove(c *Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions, ok := r.byYUI[c.user.YUI]
+	if !ok {
+		return false
+	}
+	if _, ok := sessions[c.sessionID]; !ok {
+		return false
+	}
+	delete(sessions, c.sessionID)
+	if len(sessions) == 0 {
+		delete(r.byYUI, c.user.YUI)
+		return true
+	}
+	return false
+}
+
+// clientsOf возвращает все соединения пользователя
+func (r *sessionRegistry) clientsOf(yui string) []*Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := r.byYUI[yui]
+	clients := make([]*Client, 0, len(sessions))
+	for _, c := range sessions {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// all возвращает снимок всех соединений
+func (r *sessionRegistry) all() []*Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var clients []*Client
+	for _, sessions := range r.byYUI {
+		for _, c := range sessions {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// users возвращает онлайн-пользователей (по одному на YUI)
+func (r *sessionRegistry) users() []*core.User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]*core.User, 0, len(r.byYUI))
+	for _, sessions := range r.byYUI {
+		for _, c := range sessions {
+			users = append(users, c.user)
+			break
+		}
+	}
+	return users
+}
+
+// isOnline проверяет, есть ли у пользователя хоть одно соединение
+func (r *sessionRegistry) isOnline(yui string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byYUI[yui]) > 0
+}
+
+// counts возвращает число онлайн-пользователей и число соединений
+func (r *sessionRegistry) counts() (users, sessions int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, s := range r.byYUI {
+		sessions += len(s)
+	}
+	return len(r.byYUI), sessions
+}
+
+// CloseAuthSession закрывает все соединения, открытые с токенами этой сессии входа
+func (h *Handler) CloseAuthSession(yui, authSessionID string) {
+	for _, client := range h.sessions.clientsOf(yui) {
+		if client.authSessionID == authSessionID {
+			client.kick(sessionRevokedFrame())
+		}
+	}
+}
+
+// NotifySignIn предупреждает остальные устройства о новом входе: если это не владелец, сессию можно отозвать
+func (h *Handler) NotifySignIn(yui, authSessionID string, meta auth.SessionMeta) {
+	frame := core.YepMessage{
+		Type:    "NEW_SIGN_IN",
+		Content: fmt.Sprintf("New sign-in to your account from %s (%s)", meta.Device, meta.IP),
+		YUI:     "SYSTEM",
+		Data: map[string]string{
+			"auth_session_id": authSessionID,
+			"device":          meta.Device,
+			"ip":              meta.IP,
+		},
+		Timestamp: time.Now().Unix(),
+	}
+	for _, client := range h.sessions.clientsOf(yui) {
+		if client.authSessionID != authSessionID {
+			client.enqueue(frame)
+		}
+	}
+}
+
+// CloseUserSessions закрывает все соединения пользователя, кроме сессии exceptAuthSessionID
+func (h *Handler) CloseUserSessions(yui, exceptAuthSessionID string) {
+	for _, client := range h.sessions.clientsOf(yui) {
+		if exceptAuthSessionID != "" && client.authSessionID == exceptAuthSessionID {
+			continue
+		}
+		client.kick(sessionRevokedFrame())
+	}
+}
+
+func sessionRevokedFrame() core.YepMessage {
+	return core.YepMessage{
+		Type:      "SESSION_REVOKED",
+		Content:   "Session was revoked, please login again",
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// handleLogout отзывает текущую сессию входа на сервере и закрывает её соединения
+func (h *Handler) handleLogout(client *Client) {
+	if client.authSessionID == "" {
+		client.kick(sessionRevokedFrame())
+		return
+	}
+	if _, err := h.auth.RevokeSession(client.user.YUI, client.authSessionID); err != nil {
+		log.Printf("Failed to revoke session %s: %v", client.authSessionID, err)
+	}
+	h.CloseAuthSession(client.user.YUI, client.authSessionID)
+}
@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"testing"
+	"time"
+	"yep-protocol/internal/core"
+)
+
+func TestDirectMessageReachesAllDevices(t *testing.T) {
+	s := newTestServer(t)
+	phone, _ := s.connect(t, alice, "phone", nil)
+	laptop, _ := s.connect(t, alice, "laptop", nil)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	// Личное сообщение alice приходит на оба её устройства одним и тем же сообщением
+	b.send(core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: alice.YUI, Content: "hi"})
+	id := b.until("MESSAGE_SENT")
+	sent := id[len(id)-1].MessageID
+	for name, c := range map[string]*testConn{"phone": phone, "laptop": laptop} {
+		got := c.until("DIRECT_MESSAGE")
+		if dm := got[len(got)-1]; dm.MessageID != sent || dm.YUI != bob.YUI {
+			t.Fatalf("%s got %+v, want message %s from %s", name, dm, sent, bob.YUI)
+		}
+	}
+
+	// Ответ с телефона уходит bob и копией на ноутбук, но не обратно на телефон
+	phone.send(core.YepMessage{Type: "DIRECT_MESSAGE", ToYUI: bob.YUI, Content: "hey"})
+	id = phone.until("MESSAGE_SENT")
+	sent = id[len(id)-1].MessageID
+	for name, c := range map[string]*testConn{"bob": b, "laptop": laptop} {
+		got := c.until("DIRECT_MESSAGE")
+		if dm := got[len(got)-1]; dm.MessageID != sent || dm.ToYUI != bob.YUI {
+			t.Fatalf("%s got %+v, want message %s to %s", name, dm, sent, bob.YUI)
+		}
+	}
+	phone.none("DIRECT_MESSAGE", 100*time.Millisecond)
+}
+
+func TestLobbyMessageSkipsOnlySendingDevice(t *testing.T) {
+	s := newTestServer(t)
+	phone, _ := s.connect(t, alice, "phone", nil)
+	laptop, _ := s.connect(t, alice, "laptop", nil)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	phone.send(core.YepMessage{Type: "MESSAGE", Content: "hello all"})
+	phone.until("MESSAGE_SENT")
+	laptop.until("MESSAGE")
+	b.until("MESSAGE")
+	phone.none("MESSAGE", 100*time.Millisecond)
+}
+
+func TestJoinAndLeaveOncePerUser(t *testing.T) {
+	s := newTestServer(t)
+	b, _ := s.connect(t, bob, "desktop", nil)
+
+	phone, _ := s.connect(t, alice, "phone", nil)
+	if join := b.until("USER_JOIN"); join[len(join)-1].Content != alice.Email+" joined the chat" {
+		t.Fatalf("USER_JOIN = %+v", join[len(join)-1])
+	}
+	laptop, _ := s.connect(t, alice, "laptop", nil)
+	b.none("USER_JOIN", 100*time.Millisecond)
+
+	// Пользователь онлайн, пока живо хотя бы одно устройство
+	phone.conn.Close()
+	b.none("USER_LEAVE", 100*time.Millisecond)
+	if !s.h.sessions.isOnline(alice.YUI) {
+		t.Fatal("alice went offline with the laptop still connected")
+	}
+
+	laptop.conn.Close()
+	b.until("USER_LEAVE")
+	if s.h.sessions.isOnline(alice.YUI) {
+		t.Fatal("alice is online after the last device disconnected")
+	}
+}
@@ -0,0 +1,32 @@
+package ws
+
+import (
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+)
+
+// store — пользователи и комнаты из Postgres (*storage.DB); в тестах подменяется
+type store interface {
+	GetUserByEmail(email string) (*core.User, error)
+	GetUserByYUI(yui string) (*core.User, error)
+
+	CreateRoom(name, createdBy string) (*core.Room, error)
+	GetRoom(name string) (*core.Room, error)
+	ListRooms() ([]*core.Room, error)
+	AddRoomMember(room, yui string) error
+	RemoveRoomMember(room, yui string) error
+	GetRoomMembers(room string) ([]string, error)
+	IsRoomMember(room, yui string) (bool, error)
+	GetUserRooms(yui string) ([]string, error)
+}
+
+// messageStore — сообщения в MongoDB (*storage.MongoDB)
+type messageStore interface {
+	SaveMessage(msg *storage.MongoMessage) error
+	GetMessageByClientID(fromYUI, clientMsgID string) (*storage.MongoMessage, error)
+	GetConversationSince(conversation string, afterSeq, limit int64) ([]*storage.MongoMessage, error)
+	GetRoomHistory(room string, limit int64) ([]*storage.MongoMessage, error)
+	GetUndeliveredMessages(yui string) ([]*storage.MongoMessage, error)
+	MarkDelivered(messageID, yui string) (*storage.MongoMessage, error)
+	MarkAsRead(messageID, yui string) (*storage.MongoMessage, error)
+}
@@ -0,0 +1,215 @@
+package ws
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+	"yep-protocol/internal/core"
+	"yep-protocol/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeStore — пользователи и комнаты в памяти. Методы, которые тестам не нужны,
+// достаются nil-интерфейсу и паникуют.
+type fakeStore struct {
+	store
+
+	mu      sync.Mutex
+	users   map[string]*core.User      // yui -> пользователь
+	members map[string]map[string]bool // room -> участники
+}
+
+func newFakeStore(users ...*core.User) *fakeStore {
+	db := &fakeStore{
+		users:   make(map[string]*core.User),
+		members: make(map[string]map[string]bool),
+	}
+	for _, u := range users {
+		db.users[u.YUI] = u
+	}
+	return db
+}
+
+func (db *fakeStore) GetUserByEmail(email string) (*core.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, u := range db.users {
+		if u.Email == email && u.IsActive {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (db *fakeStore) GetUserByYUI(yui string) (*core.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, ok := db.users[yui]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (db *fakeStore) GetRoomMembers(room string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var members []string
+	for yui := range db.members[room] {
+		members = append(members, yui)
+	}
+	return members, nil
+}
+
+func (db *fakeStore) IsRoomMember(room, yui string) (bool, error) {
+	if room == core.LobbyRoom {
+		return true, nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.members[room][yui], nil
+}
+
+// fakeMessages — коллекция сообщений в памяти: seq по диалогам и уникальность
+// (from_yui, client_msg_id), как у индексов MongoDB
+type fakeMessages struct {
+	messageStore
+
+	mu       sync.Mutex
+	messages []*storage.MongoMessage
+
+	// beforeSince вызывается один раз перед выборкой GetConversationSince
+	beforeSince func()
+}
+
+func (m *fakeMessages) SaveMessage(msg *storage.MongoMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last int64
+	for _, stored := range m.messages {
+		if msg.ClientMsgID != "" && stored.FromYUI == msg.FromYUI && stored.ClientMsgID == msg.ClientMsgID {
+			return storage.ErrDuplicateMessage
+		}
+		if msg.Conversation != "" && stored.Conversation == msg.Conversation && stored.Seq > last {
+			last = stored.Seq
+		}
+	}
+
+	msg.ID = primitive.NewObjectID()
+	msg.CreatedAt = time.Now()
+	if msg.Conversation != "" {
+		msg.Seq = last + 1
+	}
+	copied := *msg
+	m.messages = append(m.messages, &copied)
+	return nil
+}
+
+func (m *fakeMessages) GetMessageByClientID(fromYUI, clientMsgID string) (*storage.MongoMessage, error) {
+	return m.find(func(msg *storage.MongoMessage) bool {
+		return msg.FromYUI == fromYUI && msg.ClientMsgID == clientMsgID
+	})
+}
+
+func (m *fakeMessages) GetConversationSince(conversation string, afterSeq, limit int64) ([]*storage.MongoMessage, error) {
+	m.mu.Lock()
+	hook := m.beforeSince
+	m.beforeSince = nil
+	m.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+
+	messages := m.filter(func(msg *storage.MongoMessage) bool {
+		return msg.Conversation == conversation && msg.Seq > afterSeq
+	})
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Seq < messages[j].Seq })
+	if int64(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (m *fakeMessages) GetUndeliveredMessages(yui string) ([]*storage.MongoMessage, error) {
+	return m.filter(func(msg *storage.MongoMessage) bool {
+		return msg.ToYUI == yui && !msg.IsRead && msg.DeliveredAt == nil
+	}), nil
+}
+
+func (m *fakeMessages) MarkDelivered(messageID, yui string) (*storage.MongoMessage, error) {
+	return m.update(messageID, func(msg *storage.MongoMessage, now time.Time) bool {
+		if msg.ToYUI != yui || msg.DeliveredAt != nil {
+			return false
+		}
+		msg.DeliveredAt = &now
+		return true
+	})
+}
+
+func (m *fakeMessages) MarkAsRead(messageID, yui string) (*storage.MongoMessage, error) {
+	return m.update(messageID, func(msg *storage.MongoMessage, now time.Time) bool {
+		if msg.ToYUI != yui || msg.IsRead {
+			return false
+		}
+		msg.IsRead, msg.ReadAt = true, &now
+		if msg.DeliveredAt == nil {
+			msg.DeliveredAt = &now
+		}
+		return true
+	})
+}
+
+// update меняет сообщение, если change согласен; иначе mongo.ErrNoDocuments, как у FindOneAndUpdate
+func (m *fakeMessages) update(messageID string, change func(*storage.MongoMessage, time.Time) bool) (*storage.MongoMessage, error) {
+	id, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.messages {
+		if msg.ID == id {
+			if !change(msg, time.Now()) {
+				return nil, mongo.ErrNoDocuments
+			}
+			copied := *msg
+			return &copied, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *fakeMessages) find(match func(*storage.MongoMessage) bool) (*storage.MongoMessage, error) {
+	found := m.filter(match)
+	if len(found) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return found[0], nil
+}
+
+// filter возвращает копии подходящих сообщений в порядке сохранения
+func (m *fakeMessages) filter(match func(*storage.MongoMessage) bool) []*storage.MongoMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var found []*storage.MongoMessage
+	for _, msg := range m.messages {
+		if match(msg) {
+			copied := *msg
+			found = append(found, &copied)
+		}
+	}
+	return found
+}
+
+// count — сколько сообщений сохранено
+func (m *fakeMessages) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.messages)
+}